@@ -16,25 +16,141 @@ package main
 import (
 	"context"
 	_ "embed"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
 	in "github.com/mikebz/bubblechat/internal"
 )
 
 func main() {
-	// Start the chat session
 	ctx := context.Background()
+
+	cfg, err := in.LoadConfig(in.ConfigPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	// audit only reads the log, it neither records nor needs an LLM or a cluster
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		if err := audit(cfg, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	cfg.Cluster = in.DetectClusterContext()
+
+	cfg.Audit, err = in.OpenAuditLog(cfg.AuditPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening audit log: %v\n", err)
+		os.Exit(1)
+	}
+	defer cfg.Audit.Close()
+
+	// mcp-serve owns stdout for the protocol and does not need an LLM
+	if len(os.Args) > 1 && os.Args[1] == "mcp-serve" {
+		if err := mcpServe(ctx, cfg, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving MCP: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Start the chat session
 	client, err := gollm.NewClient(ctx, "gemini")
 	if err != nil {
 		fmt.Printf("Error creating client: %v\n", err)
 		return
 	}
 
-	err = in.Repl(ctx, client)
+	mcpClients, err := in.ConnectMCPServers(ctx, cfg.MCPServers)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	defer mcpClients.Close()
+	cfg.Tools = append(cfg.Tools, mcpClients.Tools...)
+
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err = serve(ctx, client, cfg, os.Args[2:])
+	} else {
+		cfg.Prompts, err = in.LoadPromptHistory(cfg.PromptHistoryPath(), cfg.PromptHistorySize)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		cfg.Runbooks, err = in.LoadRunbooks(cfg.RunbooksPath())
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		err = in.Repl(ctx, client, cfg)
+	}
 	if err != nil {
 		os.Exit(1)
+	}
+}
+
+// audit checks the hash chain of the audit log.
+func audit(cfg *in.Config, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		return fmt.Errorf("usage: bubblechat audit verify [file]")
+	}
+	path := cfg.AuditPath()
+	if len(args) > 1 {
+		path = args[1]
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	count, err := in.VerifyAuditLog(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Printf("%s: %d records, chain intact\n", path, count)
+	return nil
+}
 
+// serve runs the HTTP/JSON API instead of the terminal UI.
+func serve(ctx context.Context, client gollm.Client, cfg *in.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address for the HTTP API to listen on")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	err := in.Serve(ctx, client, cfg, *addr)
+	if err != nil {
+		fmt.Printf("Error serving API: %v\n", err)
+	}
+	return err
+}
+
+// mcpServe exposes bubblechat's tools to other agents over MCP on stdio.
+// Only read-only commands run unless mutations are allowed explicitly.
+func mcpServe(ctx context.Context, cfg *in.Config, args []string) error {
+	fs := flag.NewFlagSet("mcp-serve", flag.ExitOnError)
+	allowMutations := fs.Bool("allow-mutations", false, "allow commands that change cluster or cloud state")
+	timeout := fs.Duration("timeout", 5*time.Minute, "stop tool calls running longer than this, 0 for no limit")
+	fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	executor, err := cfg.NewExecutor()
+	if err != nil {
+		return err
+	}
+	// nobody can type a confirmation over MCP, so protected contexts stay untouched
+	executor.Approve = func(req in.ApprovalRequest) bool {
+		return *allowMutations && req.Protection == in.Unprotected
 	}
+	return in.ServeMCP(ctx, executor, *timeout)
 }
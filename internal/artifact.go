@@ -0,0 +1,242 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// readArtifactTool is the name of the tool paging through stored artifacts.
+const readArtifactTool = "read_artifact"
+
+const (
+	// DefaultArtifactThreshold is the size in bytes above which tool output is
+	// stored as an artifact instead of being sent to the model.
+	DefaultArtifactThreshold = 16 * 1024
+	// artifactPreviewLines is how many lines of the head and of the tail of
+	// an artifact the model is shown.
+	artifactPreviewLines = 20
+	// defaultArtifactLimit is how many lines read_artifact returns unless told otherwise.
+	defaultArtifactLimit = 200
+	// maxArtifactLimit is the most lines read_artifact returns at once.
+	maxArtifactLimit = 1000
+	// maxArtifactLineLength is how much of a single line read_artifact returns.
+	maxArtifactLineLength = 1000
+)
+
+// Artifact is a tool output too large to send to the model whole. It is kept
+// for the session, redacted, and the model reads it with read_artifact.
+type Artifact struct {
+	ID      string    `json:"id"`
+	Tool    string    `json:"tool"`
+	Command string    `json:"command,omitempty"`
+	Created time.Time `json:"created"`
+	Content string    `json:"content"`
+}
+
+// Lines returns the number of lines of the artifact.
+func (a *Artifact) Lines() int {
+	return len(artifactLines(a.Content))
+}
+
+// artifactLines splits content into lines, without an empty last line for
+// the final newline.
+func artifactLines(content string) []string {
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// readArtifactDefinition describes the read_artifact tool.
+func readArtifactDefinition() *gollm.FunctionDefinition {
+	return &gollm.FunctionDefinition{
+		Name: readArtifactTool,
+		Description: "Read part of a large tool output that was stored as an artifact instead of being returned whole. " +
+			"Page through it with offset and limit, or pass a pattern to get only the matching lines.",
+		Parameters: &gollm.Schema{
+			Type: gollm.TypeObject,
+			Properties: map[string]*gollm.Schema{
+				"id": {
+					Type:        gollm.TypeString,
+					Description: "ID of the artifact, e.g. a1.",
+				},
+				"offset": {
+					Type:        gollm.TypeInteger,
+					Description: "Number of lines to skip, 0 by default.",
+				},
+				"limit": {
+					Type:        gollm.TypeInteger,
+					Description: fmt.Sprintf("Number of lines to return, %d by default and at most %d.", defaultArtifactLimit, maxArtifactLimit),
+				},
+				"pattern": {
+					Type:        gollm.TypeString,
+					Description: "Regular expression in Go syntax. Only lines matching it are returned, e.g. (?i)error|timeout.",
+				},
+			},
+			Required: []string{"id"},
+		},
+	}
+}
+
+// artifactThreshold returns the size above which output becomes an artifact.
+func (e *Executor) artifactThreshold() int {
+	if e.ArtifactThreshold > 0 {
+		return e.ArtifactThreshold
+	}
+	return DefaultArtifactThreshold
+}
+
+// Artifacts returns the artifacts stored in the session.
+func (e *Executor) Artifacts() []Artifact {
+	e.artifactMu.Lock()
+	defer e.artifactMu.Unlock()
+	return append([]Artifact(nil), e.artifacts...)
+}
+
+// artifact returns the artifact with the given ID.
+func (e *Executor) artifact(id string) (Artifact, bool) {
+	e.artifactMu.Lock()
+	defer e.artifactMu.Unlock()
+	for _, artifact := range e.artifacts {
+		if artifact.ID == id {
+			return artifact, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// Offload stores the output of a tool call as an artifact when it is larger
+// than the threshold. It returns what to send to the model instead: the
+// artifact's ID and size with a preview of its head and tail. Small outputs
+// are returned as they are, with a nil artifact.
+func (e *Executor) Offload(fnCall gollm.FunctionCall, output string) (string, *Artifact) {
+	if len(output) <= e.artifactThreshold() || fnCall.Name == readArtifactTool {
+		return output, nil
+	}
+
+	e.artifactMu.Lock()
+	command, _ := fnCall.Arguments["command"].(string)
+	artifact := Artifact{
+		ID:      fmt.Sprintf("a%d", len(e.artifacts)+1),
+		Tool:    fnCall.Name,
+		Command: command,
+		Created: time.Now(),
+		Content: output,
+	}
+	e.artifacts = append(e.artifacts, artifact)
+	e.artifactMu.Unlock()
+
+	lines := artifactLines(output)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The output is too large to return whole (%d bytes, %d lines). It is stored as artifact %s, "+
+		"use read_artifact with this id to page through it or search it.\n", len(output), len(lines), artifact.ID)
+	if len(lines) <= 2*artifactPreviewLines {
+		fmt.Fprintf(&sb, "--- preview ---\n%s", previewLines(lines))
+		return sb.String(), &artifact
+	}
+	fmt.Fprintf(&sb, "--- first %d lines ---\n%s\n", artifactPreviewLines, previewLines(lines[:artifactPreviewLines]))
+	fmt.Fprintf(&sb, "--- last %d lines ---\n%s", artifactPreviewLines, previewLines(lines[len(lines)-artifactPreviewLines:]))
+	return sb.String(), &artifact
+}
+
+// previewLines joins lines for a preview, shortening long ones.
+func previewLines(lines []string) string {
+	shortened := make([]string, len(lines))
+	for i, line := range lines {
+		shortened[i] = shortenLine(line, maxGrepLineLength)
+	}
+	return strings.Join(shortened, "\n")
+}
+
+// shortenLine cuts a line to n bytes and says how much was left out.
+func shortenLine(line string, n int) string {
+	if len(line) <= n {
+		return line
+	}
+	return fmt.Sprintf("%s... [%d more bytes]", line[:n], len(line)-n)
+}
+
+// readArtifact runs a read_artifact call. Lines are numbered from 1 so the
+// model can tell where it is.
+func (e *Executor) readArtifact(fnCall gollm.FunctionCall) (string, error) {
+	id, _ := fnCall.Arguments["id"].(string)
+	artifact, ok := e.artifact(id)
+	if !ok {
+		return "", fmt.Errorf("no artifact %q", id)
+	}
+	offset, _ := intArgument(fnCall.Arguments, "offset")
+	if offset < 0 {
+		return "", errors.New("offset must not be negative")
+	}
+	limit, ok := intArgument(fnCall.Arguments, "limit")
+	if !ok || limit <= 0 {
+		limit = defaultArtifactLimit
+	}
+	limit = min(limit, maxArtifactLimit)
+	var re *regexp.Regexp
+	if pattern, _ := fnCall.Arguments["pattern"].(string); pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return "", fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+
+	lines := artifactLines(artifact.Content)
+	if offset >= len(lines) {
+		return fmt.Sprintf("Artifact %s has %d lines.", id, len(lines)), nil
+	}
+	var out []string
+	size := 0
+	next := len(lines)
+	for i := offset; i < len(lines); i++ {
+		if re != nil && !re.MatchString(lines[i]) {
+			continue
+		}
+		line := fmt.Sprintf("%d: %s", i+1, shortenLine(lines[i], maxArtifactLineLength))
+		if len(out) == limit || size+len(line) > e.artifactThreshold() {
+			next = i
+			break
+		}
+		out = append(out, line)
+		size += len(line) + 1
+	}
+
+	switch {
+	case len(out) == 0 && re != nil:
+		out = append(out, fmt.Sprintf("No lines after line %d match.", offset))
+	case next < len(lines):
+		out = append(out, fmt.Sprintf("[artifact %s has %d lines, continue with offset %d]", id, len(lines), next))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// intArgument returns an integer argument of a tool call. Arguments decoded
+// from JSON are numbers of type float64.
+func intArgument(args map[string]any, key string) (int, bool) {
+	switch v := args[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
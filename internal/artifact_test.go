@@ -0,0 +1,187 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// numberedLines returns "line 1" to "line n", one per line.
+func numberedLines(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	return sb.String()
+}
+
+// readArtifactCall builds a read_artifact call with JSON-style numbers.
+func readArtifactCall(args map[string]any) gollm.FunctionCall {
+	return gollm.FunctionCall{ID: "1", Name: readArtifactTool, Arguments: args}
+}
+
+// TestOffload keeps small outputs and stores large ones with a preview.
+func TestOffload(t *testing.T) {
+	executor := NewExecutor()
+	executor.ArtifactThreshold = 1000
+	call := toolCall("1", "kubectl", "kubectl logs web")
+
+	output, artifact := executor.Offload(call, "short")
+	assert.Equal(t, "short", output)
+	assert.Nil(t, artifact)
+
+	content := numberedLines(500)
+	output, artifact = executor.Offload(call, content)
+	require.NotNil(t, artifact)
+	assert.Equal(t, "a1", artifact.ID)
+	assert.Equal(t, "kubectl logs web", artifact.Command)
+	assert.Equal(t, 500, artifact.Lines())
+	assert.Contains(t, output, fmt.Sprintf("(%d bytes, 500 lines)", len(content)))
+	assert.Contains(t, output, "artifact a1")
+	assert.Contains(t, output, "--- first 20 lines ---\nline 1\n")
+	assert.Contains(t, output, "line 20\n--- last 20 lines ---\nline 481\n")
+	assert.True(t, strings.HasSuffix(output, "line 500"), output)
+	assert.NotContains(t, output, "line 21\n")
+
+	_, artifact = executor.Offload(call, strings.Repeat("x", 2000))
+	require.NotNil(t, artifact)
+	assert.Equal(t, "a2", artifact.ID)
+	assert.Len(t, executor.Artifacts(), 2)
+}
+
+// TestReadArtifact pages through and searches a stored artifact.
+func TestReadArtifact(t *testing.T) {
+	executor := NewExecutor()
+	executor.ArtifactThreshold = 1000
+	// reading artifacts never asks
+	executor.Approve = func(ApprovalRequest) bool { return false }
+	_, artifact := executor.Offload(toolCall("1", "kubectl", "kubectl logs web"), numberedLines(500))
+	require.NotNil(t, artifact)
+
+	tests := []struct {
+		name    string
+		args    map[string]any
+		want    string
+		wantErr string
+	}{
+		{
+			name: "page",
+			args: map[string]any{"id": "a1", "offset": float64(10), "limit": float64(3)},
+			want: "11: line 11\n12: line 12\n13: line 13\n[artifact a1 has 500 lines, continue with offset 13]",
+		},
+		{
+			name: "last page",
+			args: map[string]any{"id": "a1", "offset": float64(498)},
+			want: "499: line 499\n500: line 500",
+		},
+		{
+			name: "grep",
+			args: map[string]any{"id": "a1", "pattern": `^line 4[0-9]5$`, "limit": float64(2)},
+			want: "405: line 405\n415: line 415\n[artifact a1 has 500 lines, continue with offset 424]",
+		},
+		{
+			name: "grep from an offset",
+			args: map[string]any{"id": "a1", "pattern": `^line 49[0-9]$`, "offset": float64(497)},
+			want: "498: line 498\n499: line 499",
+		},
+		{
+			name: "grep without matches",
+			args: map[string]any{"id": "a1", "pattern": `error`},
+			want: "No lines after line 0 match.",
+		},
+		{
+			name: "past the end",
+			args: map[string]any{"id": "a1", "offset": float64(500)},
+			want: "Artifact a1 has 500 lines.",
+		},
+		{
+			name:    "unknown artifact",
+			args:    map[string]any{"id": "a9"},
+			wantErr: `no artifact "a9"`,
+		},
+		{
+			name:    "bad pattern",
+			args:    map[string]any{"id": "a1", "pattern": "("},
+			wantErr: "invalid pattern",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executor.Call(t.Context(), readArtifactCall(tt.args))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// a page never gets large enough to become an artifact itself
+	got, err := executor.Call(t.Context(), readArtifactCall(map[string]any{"id": "a1", "limit": float64(1000)}))
+	require.NoError(t, err)
+	lines := strings.Split(got, "\n")
+	assert.Equal(t, "[artifact a1 has 500 lines, continue with offset 84]", lines[len(lines)-1])
+	assert.Equal(t, "84: line 84", lines[len(lines)-2])
+	assert.LessOrEqual(t, len(got)-len(lines[len(lines)-1]), executor.ArtifactThreshold)
+}
+
+// TestChatLoopArtifact sends a preview of a large output to the model and
+// exports the whole output with the conversation.
+func TestChatLoopArtifact(t *testing.T) {
+	fakeKubectl(t, `i=1; while [ $i -le 3000 ]; do echo "log line $i"; i=$((i+1)); done`)
+	h, chat := newFakeHistory(t,
+		callResponse(toolCall("1", "kubectl", "kubectl logs web")),
+		callResponse(readArtifactCall(map[string]any{"id": "a1", "pattern": "line 2999$"})),
+		textResponse("Line 2999 is the interesting one."),
+	)
+
+	h.ChatLoop("what do the logs say?")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 3)
+	preview := sent[1][0].(gollm.FunctionCallResult).Result["output"].(string)
+	assert.Contains(t, preview, "artifact a1")
+	assert.Contains(t, preview, "log line 3000")
+	assert.Less(t, len(preview), DefaultArtifactThreshold)
+	read := sent[2][0].(gollm.FunctionCallResult).Result["output"]
+	assert.Equal(t, "2999: log line 2999", read)
+
+	var notes []string
+	for _, block := range h.Snapshot() {
+		if block.Type == ToolBlock && strings.HasPrefix(block.Text, "Stored") {
+			notes = append(notes, block.Text)
+		}
+	}
+	assert.Equal(t, []string{"Stored the kubectl output (40893 bytes, 3000 lines) as artifact a1, the model gets its head and tail."}, notes)
+
+	var sb strings.Builder
+	require.NoError(t, h.ExportJSON(&sb))
+	var export Export
+	require.NoError(t, json.Unmarshal([]byte(sb.String()), &export))
+	require.Len(t, export.Artifacts, 1)
+	assert.Equal(t, 3000, export.Artifacts[0].Lines())
+
+	sb.Reset()
+	require.NoError(t, h.ExportMarkdown(&sb))
+	assert.Contains(t, sb.String(), "## Artifact a1\n\nkubectl `kubectl logs web`, 40893 bytes\n\n```text\nlog line 1\n")
+}
@@ -0,0 +1,217 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// maxAttachmentSize is the largest file that can be attached to a prompt, in bytes.
+const maxAttachmentSize = 64 * 1024
+
+// attachmentPattern matches @path mentions at the start of the prompt or after whitespace.
+var attachmentPattern = regexp.MustCompile(`(^|\s)@(\S+)`)
+
+// Attachment is a file mentioned with @path in a prompt.
+type Attachment struct {
+	// Path is the file as mentioned, relative to the working directory.
+	Path    string
+	Content string
+}
+
+// confine resolves path against root and makes sure it stays inside root,
+// following symbolic links.
+func confine(root, path string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", err
+	}
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(root, path)
+	}
+	// checked before and after following links, so nothing outside is even looked at
+	if !within(root, abs) {
+		return "", fmt.Errorf("%s is outside of %s", path, root)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	if !within(root, resolved) {
+		return "", fmt.Errorf("%s is outside of %s", path, root)
+	}
+	return resolved, nil
+}
+
+// within reports whether path is root or below it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// FindAttachments reads the files mentioned with @path in the prompt. The
+// files must be inside root and no larger than maxAttachmentSize. Mentions
+// that are not existing files, such as @here or @sha256:..., are left as
+// they are.
+func FindAttachments(root, prompt string) ([]Attachment, error) {
+	var attachments []Attachment
+	for _, match := range attachmentPattern.FindAllStringSubmatch(prompt, -1) {
+		path := match[2]
+		if slices.ContainsFunc(attachments, func(a Attachment) bool { return a.Path == path }) {
+			continue
+		}
+		resolved, err := confine(root, path)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot attach @%s: %w", path, err)
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("cannot attach @%s: %w", path, err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("cannot attach @%s: it is a directory", path)
+		}
+		if info.Size() > maxAttachmentSize {
+			return nil, fmt.Errorf("cannot attach @%s: it has %d bytes, at most %d can be attached", path, info.Size(), maxAttachmentSize)
+		}
+		data, err := os.ReadFile(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("cannot attach @%s: %w", path, err)
+		}
+		attachments = append(attachments, Attachment{Path: path, Content: string(data)})
+	}
+	return attachments, nil
+}
+
+// withAttachments appends the attached files to the prompt, each between
+// delimiters naming the file so the model can tell them from the question.
+func withAttachments(prompt string, attachments []Attachment) string {
+	var sb strings.Builder
+	sb.WriteString(prompt)
+	for _, a := range attachments {
+		fmt.Fprintf(&sb, "\n\n<attached-file path=%q>\n%s\n</attached-file>", a.Path, strings.TrimRight(a.Content, "\n"))
+	}
+	return sb.String()
+}
+
+// Attach expands the @path mentions of a prompt with the content of the
+// files, which is redacted like tool output. An attachment block is shown
+// for every file.
+func (h *History) Attach(root, prompt string) (string, error) {
+	attachments, err := FindAttachments(root, prompt)
+	if err != nil {
+		return "", err
+	}
+	for i, a := range attachments {
+		content, redactions := h.Redact(a.Content)
+		attachments[i].Content = content
+		text := fmt.Sprintf("Attached %s (%d bytes)", a.Path, len(a.Content))
+		if len(redactions) > 0 {
+			text += ", redacted " + DescribeRedactions(redactions)
+		}
+		h.AddBlock(Block{Text: text, Type: AttachmentBlock})
+	}
+	return withAttachments(prompt, attachments), nil
+}
+
+// completePath completes the partial path of an @mention inside root. It
+// returns the text to add to partial, and the candidates when there is no
+// single completion. Directories end with a slash.
+func completePath(root, partial string) (string, []string) {
+	dir, prefix := filepath.Split(partial)
+	if dir == "" {
+		dir = "."
+	}
+	resolved, err := confine(root, dir)
+	if err != nil {
+		return "", nil
+	}
+	entries, err := os.ReadDir(resolved)
+	if err != nil {
+		return "", nil
+	}
+
+	var candidates []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		candidates = append(candidates, name)
+	}
+	return completeFrom(prefix, candidates)
+}
+
+// completeFrom completes prefix to the longest common prefix of the
+// candidates starting with it. It returns the text to add to prefix, and the
+// candidates when there is no single completion.
+func completeFrom(prefix string, candidates []string) (string, []string) {
+	var matching []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matching = append(matching, c)
+		}
+	}
+	if len(matching) == 0 {
+		return "", nil
+	}
+	if len(matching) == 1 {
+		return strings.TrimPrefix(matching[0], prefix), nil
+	}
+
+	common := matching[0]
+	for _, c := range matching[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	return strings.TrimPrefix(common, prefix), matching
+}
+
+// completeMention completes the @path mention before the cursor. It
+// returns false when the cursor is not after a mention.
+func (doc *Document) completeMention() bool {
+	lines := strings.Split(doc.input.Value(), "\n")
+	line := []rune(lines[min(doc.input.Line(), len(lines)-1)])
+	info := doc.input.LineInfo()
+	before := string(line[:min(info.StartColumn+info.CharOffset, len(line))])
+	word := before[strings.LastIndexAny(before, " \t")+1:]
+	if !strings.HasPrefix(word, "@") {
+		return false
+	}
+
+	completion, candidates := completePath(".", strings.TrimPrefix(word, "@"))
+	doc.input.InsertString(completion)
+	doc.completions = candidates
+	return true
+}
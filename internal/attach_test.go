@@ -0,0 +1,120 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// attachmentTree creates files to attach in a temporary working directory.
+func attachmentTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "deploy"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "deploy", "app.yaml"), []byte("kind: Deployment\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "deploy", "values.yaml"), []byte("replicas: 3\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "big.log"), []byte(strings.Repeat("x", maxAttachmentSize+1)), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("nope"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")))
+	t.Chdir(root)
+	return root
+}
+
+// TestFindAttachments reads mentioned files inside the working directory only.
+func TestFindAttachments(t *testing.T) {
+	attachmentTree(t)
+	tests := []struct {
+		prompt  string
+		want    []Attachment
+		wantErr string
+	}{
+		{prompt: "mail me@example.com, no attachments"},
+		{
+			prompt: "@deploy/app.yaml why does it not start? see @deploy/app.yaml",
+			want:   []Attachment{{Path: "deploy/app.yaml", Content: "kind: Deployment\n"}},
+		},
+		{prompt: "check @../secret.txt", wantErr: "is outside of"},
+		{prompt: "check @link.txt", wantErr: "is outside of"},
+		{prompt: "check @big.log", wantErr: "at most 65536 can be attached"},
+		{prompt: "check @deploy", wantErr: "it is a directory"},
+		{prompt: "check @missing.yaml and @deploy/app.yaml/x"},
+		{
+			prompt: "@here is @deploy/values.yaml at image@sha256:abc or @sha256:abc?",
+			want:   []Attachment{{Path: "deploy/values.yaml", Content: "replicas: 3\n"}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := FindAttachments(".", tt.prompt)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.prompt)
+			continue
+		}
+		require.NoError(t, err, tt.prompt)
+		assert.Equal(t, tt.want, got, tt.prompt)
+	}
+}
+
+// TestCompletePath completes @mentions like a shell.
+func TestCompletePath(t *testing.T) {
+	attachmentTree(t)
+	tests := []struct {
+		partial    string
+		want       string
+		candidates []string
+	}{
+		{"de", "ploy/", nil},
+		{"deploy/", "", []string{"app.yaml", "values.yaml"}},
+		{"deploy/v", "alues.yaml", nil},
+		{"nothing", "", nil},
+		{"../", "", nil},
+	}
+	for _, tt := range tests {
+		got, candidates := completePath(".", tt.partial)
+		assert.Equal(t, tt.want, got, tt.partial)
+		assert.Equal(t, tt.candidates, candidates, tt.partial)
+	}
+}
+
+// TestAttachInPrompt sends attached files to the model and completes them with Tab.
+func TestAttachInPrompt(t *testing.T) {
+	attachmentTree(t)
+	doc, chat := newTestDoc(t, textResponse("it is missing a container"))
+
+	doc.input.SetValue("why is @dep")
+	doc.Update(tea.KeyMsg{Type: tea.KeyTab})
+	doc.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	doc.Update(tea.KeyMsg{Type: tea.KeyTab})
+	assert.Equal(t, "why is @deploy/app.yaml", doc.input.Value())
+
+	send(doc, doc.input.Value()+" broken?")
+	blocks := doc.Snapshot()
+	assert.Equal(t, Block{Text: "Attached deploy/app.yaml (17 bytes)", Type: AttachmentBlock}, blocks[len(blocks)-2])
+	sent := chat.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "why is @deploy/app.yaml broken?\n\n<attached-file path=\"deploy/app.yaml\">\nkind: Deployment\n</attached-file>", sent[0][0])
+
+	send(doc, "and @../secret.txt?")
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+	assert.Equal(t, "and @../secret.txt?", doc.input.Value(), "the prompt is given back")
+	assert.Len(t, chat.Sent(), 1)
+}
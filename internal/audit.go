@@ -0,0 +1,240 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Approval decisions recorded in the audit log.
+const (
+	// DecisionReadOnly is recorded for calls that ran without approval because they are read-only.
+	DecisionReadOnly = "read-only"
+	// DecisionApproved is recorded for calls the user approved.
+	DecisionApproved = "approved"
+	// DecisionDeclined is recorded for calls the user declined.
+	DecisionDeclined = "declined"
+	// DecisionProtected is recorded for calls refused because the context is read-only.
+	DecisionProtected = "protected"
+	// DecisionBlocked is recorded for commands refused because they print credentials.
+	DecisionBlocked = "blocked"
+	// DecisionUnchecked is recorded for calls that ran without consulting any approver.
+	DecisionUnchecked = "unchecked"
+)
+
+// AuditRecord is one line of the audit log. Hash covers every other field,
+// including Prev, the hash of the record before it, so that changing,
+// removing or reordering records breaks the chain.
+type AuditRecord struct {
+	Time        time.Time `json:"ts"`
+	User        string    `json:"user"`
+	Session     string    `json:"session"`
+	Tool        string    `json:"tool"`
+	Argv        []string  `json:"argv"`
+	KubeContext string    `json:"kubeContext,omitempty"`
+	Decision    string    `json:"decision"`
+	// ExitCode is the exit code of the command, or -1 when it did not run
+	// or failed without one.
+	ExitCode   int    `json:"exitCode"`
+	DurationMS int64  `json:"durationMs"`
+	OutputHash string `json:"outputHash"`
+	Prev       string `json:"prev"`
+	Hash       string `json:"hash"`
+}
+
+// hash returns the hash of the record with its Hash field left out.
+func (r AuditRecord) hash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditLog appends hash-chained records to a JSONL file.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	user string
+}
+
+// AuditPath returns the default location of the audit log, next to the config file.
+func AuditPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "audit.jsonl")
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("creating audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &AuditLog{file: file, user: name}, nil
+}
+
+// Append chains a record to the end of the log and writes it. The user, the
+// time if unset and the hashes are filled in.
+func (l *AuditLog) Append(record AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the file lock keeps other bubblechat processes from appending between
+	// reading the last hash and writing the record
+	if err := lockFile(l.file); err != nil {
+		return fmt.Errorf("locking audit log: %w", err)
+	}
+	defer unlockFile(l.file)
+
+	// the chain continues from what is in the file, which another
+	// bubblechat process may have appended to since the last record
+	prev, err := lastAuditHash(l.file)
+	if err != nil {
+		return err
+	}
+
+	record.User = l.user
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	record.Time = record.Time.UTC()
+	record.Prev = prev
+	if record.Hash, err = record.hash(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the audit log file.
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
+
+// lastAuditHash returns the hash of the last record in the file, reading
+// backwards from the end so that large logs stay cheap to append to.
+func lastAuditHash(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	const chunk = 4096
+	end := info.Size()
+	var tail []byte
+	for offset := end; offset > 0; {
+		size := min(chunk, offset)
+		offset -= size
+		buf := make([]byte, size)
+		if _, err := file.ReadAt(buf, offset); err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		tail = append(buf, tail...)
+
+		line := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 || offset == 0 {
+			line = line[i+1:]
+			if len(line) == 0 {
+				return "", nil
+			}
+			var record AuditRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				return "", fmt.Errorf("reading last audit record: %w", err)
+			}
+			return record.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// VerifyAuditLog checks the hash chain of an audit log and returns the
+// number of intact records. The error names the first record that does
+// not match.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	prev := ""
+	count := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		if record.Prev != prev {
+			return count, fmt.Errorf("record %d: chain broken, a record before it was changed or removed", count+1)
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return count, fmt.Errorf("record %d: %w", count+1, err)
+		}
+		if hash != record.Hash {
+			return count, fmt.Errorf("record %d: hash mismatch, the record was changed", count+1)
+		}
+		prev = record.Hash
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// outputHash returns the SHA-256 of a tool's output.
+func outputHash(output string) string {
+	sum := sha256.Sum256([]byte(output))
+	return hex.EncodeToString(sum[:])
+}
+
+// exitCode returns the exit code of a finished command, 0 on success and
+// -1 for errors that did not come from a command exiting.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
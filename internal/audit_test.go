@@ -0,0 +1,150 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAudit returns the records of an audit log file.
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+// TestAuditExecutor checks the records of a declined and a failed call.
+func TestAuditExecutor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log, err := OpenAuditLog(path)
+	require.NoError(t, err)
+	defer log.Close()
+
+	executor := NewExecutor()
+	executor.Audit = log
+	executor.Session = "s1"
+	executor.Cluster = ClusterContext{KubeContext: "kind-dev", Namespace: "web"}
+	executor.Approve = func(req ApprovalRequest) bool { return false }
+	require.NoError(t, executor.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "restart"},
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return "", errors.New("not allowed")
+		},
+	}))
+
+	_, err = executor.Call(t.Context(), toolCall("1", "kubectl", "delete pod api-0"))
+	assert.ErrorIs(t, err, ErrDeclined)
+	// without an approver the call runs unchecked
+	executor.Approve = nil
+	_, err = executor.Call(t.Context(), gollm.FunctionCall{Name: "restart", Arguments: map[string]any{"deploy": "api"}})
+	assert.ErrorContains(t, err, "not allowed")
+
+	records := readAudit(t, path)
+	require.Len(t, records, 2)
+
+	declined := records[0]
+	assert.Equal(t, "s1", declined.Session)
+	assert.Equal(t, "kubectl", declined.Tool)
+	assert.Equal(t, []string{"kubectl", "delete", "pod", "api-0", "--context", "kind-dev", "--namespace", "web"}, declined.Argv)
+	assert.Equal(t, "kind-dev", declined.KubeContext)
+	assert.Equal(t, DecisionDeclined, declined.Decision)
+	assert.Equal(t, -1, declined.ExitCode)
+	assert.Equal(t, outputHash(""), declined.OutputHash)
+	assert.Empty(t, declined.Prev)
+
+	failed := records[1]
+	assert.Equal(t, []string{"restart", `{"deploy":"api"}`}, failed.Argv)
+	assert.Equal(t, DecisionUnchecked, failed.Decision)
+	assert.Empty(t, failed.KubeContext)
+	assert.Equal(t, declined.Hash, failed.Prev)
+}
+
+// TestVerifyAuditLog checks that the chain survives reopening the log
+// and that changed or removed records are detected.
+func TestVerifyAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i, tool := range []string{"kubectl", "gcloud", "kubectl"} {
+		// a new process continues the chain of the previous one
+		log, err := OpenAuditLog(path)
+		require.NoError(t, err)
+		require.NoError(t, log.Append(AuditRecord{Tool: tool, Session: string(rune('a' + i))}))
+		require.NoError(t, log.Close())
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	count, err := VerifyAuditLog(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	lines := strings.SplitAfter(string(data), "\n")
+
+	changed := strings.Replace(string(data), `"tool":"gcloud"`, `"tool":"kubectl"`, 1)
+	count, err = VerifyAuditLog(strings.NewReader(changed))
+	assert.ErrorContains(t, err, "record 2: hash mismatch")
+	assert.Equal(t, 1, count)
+
+	removed := lines[0] + lines[2]
+	_, err = VerifyAuditLog(strings.NewReader(removed))
+	assert.ErrorContains(t, err, "record 2: chain broken")
+}
+
+// TestAuditConcurrentLogs keeps the chain intact when several processes,
+// played by separately opened logs, append at the same time.
+func TestAuditConcurrentLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	var wg sync.WaitGroup
+	for i := range 4 {
+		log, err := OpenAuditLog(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { log.Close() })
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 250 {
+				assert.NoError(t, log.Append(AuditRecord{Tool: "kubectl", Session: string(rune('a' + i))}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	count, err := VerifyAuditLog(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 1000, count)
+}
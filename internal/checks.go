@@ -0,0 +1,310 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/robfig/cron/v3"
+)
+
+// checkPrompt is the system prompt of the chats running scheduled checks.
+const checkPrompt = `You run a scheduled health check of a Kubernetes cluster for a user who is busy with something else.
+Only use read-only tools, nothing may be changed. Answer in one or two sentences.
+Start the answer with "OK:" when nothing needs the user's attention, or with "PROBLEM:" when something does.`
+
+// defaultCommandCheck is what the model is asked about the output of a
+// command check without a prompt.
+const defaultCommandCheck = "Does this output show a problem that needs attention?"
+
+// CheckConfig is a recurring health check from the configuration. It
+// either asks the model a prompt, or runs a command and has the model
+// assess its output.
+type CheckConfig struct {
+	Name string `yaml:"name"`
+	// Every runs the check at an interval, e.g. 10m.
+	Every time.Duration `yaml:"every"`
+	// Cron runs the check on a cron schedule instead, e.g. "*/10 * * * *".
+	Cron string `yaml:"cron"`
+	// Prompt is what the model is asked. It may use the read-only tools.
+	Prompt string `yaml:"prompt"`
+	// Command is a kubectl or gcloud command whose output the model assesses.
+	Command string `yaml:"command"`
+}
+
+// schedule returns when the check runs.
+func (c CheckConfig) schedule() (cron.Schedule, error) {
+	switch {
+	case c.Name == "":
+		return nil, errors.New("a check needs a name")
+	case c.Prompt == "" && c.Command == "":
+		return nil, fmt.Errorf("check %s needs a prompt or a command", c.Name)
+	case c.Command != "" && !strings.HasPrefix(c.Command, "kubectl ") && !strings.HasPrefix(c.Command, "gcloud "):
+		return nil, fmt.Errorf("check %s: only kubectl and gcloud commands can be run, not %q", c.Name, c.Command)
+	case (c.Every > 0) == (c.Cron != ""):
+		return nil, fmt.Errorf("check %s needs either every or cron", c.Name)
+	case c.Every < 0:
+		return nil, fmt.Errorf("check %s: every must be positive", c.Name)
+	case c.Every > 0:
+		return intervalSchedule(c.Every), nil
+	}
+	schedule, err := cron.ParseStandard(c.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("check %s: %w", c.Name, err)
+	}
+	return schedule, nil
+}
+
+// intervalSchedule runs a check at a fixed interval. Unlike cron.Every it
+// is not rounded to seconds.
+type intervalSchedule time.Duration
+
+// Next returns the time one interval after t.
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// CheckResult is the outcome of one run of a check.
+type CheckResult struct {
+	Name string
+	// Problem is set when the model flagged a problem.
+	Problem bool
+	// Answer is the model's assessment.
+	Answer string
+	Time   time.Time
+}
+
+// String renders the result for a CheckBlock, e.g. "✓ Check nodes: OK: all nodes are ready".
+// Problems start with ⚠ instead.
+func (r CheckResult) String() string {
+	mark := "✓"
+	if r.Problem {
+		mark = checkProblemMark
+	}
+	return fmt.Sprintf("%s Check %s: %s", mark, r.Name, r.Answer)
+}
+
+// checkProblemMark starts the CheckBlocks of problems.
+const checkProblemMark = "⚠"
+
+// scheduledCheck is a check running in the background.
+type scheduledCheck struct {
+	config   CheckConfig
+	schedule cron.Schedule
+	next     time.Time
+	last     *CheckResult
+}
+
+// StartChecks runs the checks on their schedules in the background until
+// the conversation ends. Every result is added as a CheckBlock, and
+// OnAlert is called when the model flags a problem. No check is started
+// when one of them is invalid.
+func (h *History) StartChecks(checks []CheckConfig) error {
+	names := map[string]bool{}
+	var scheduled []*scheduledCheck
+	for _, check := range checks {
+		schedule, err := check.schedule()
+		if err != nil {
+			return err
+		}
+		if names[check.Name] {
+			return fmt.Errorf("there are several checks named %s", check.Name)
+		}
+		names[check.Name] = true
+		scheduled = append(scheduled, &scheduledCheck{config: check, schedule: schedule})
+	}
+
+	h.mu.Lock()
+	h.checks = append(h.checks, scheduled...)
+	h.mu.Unlock()
+	for _, check := range scheduled {
+		go h.scheduleCheck(check)
+	}
+	return nil
+}
+
+// scheduleCheck runs a check whenever it is due. A run that takes longer
+// than the interval delays the next one.
+func (h *History) scheduleCheck(check *scheduledCheck) {
+	for {
+		next := check.schedule.Next(time.Now())
+		h.mu.Lock()
+		check.next = next
+		h.mu.Unlock()
+		select {
+		case <-h.Context.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		h.RunCheck(check.config.Name)
+	}
+}
+
+// RunCheck runs the named check now and posts the result.
+func (h *History) RunCheck(name string) error {
+	var check *scheduledCheck
+	h.mu.Lock()
+	for _, c := range h.checks {
+		if c.config.Name == name {
+			check = c
+		}
+	}
+	h.mu.Unlock()
+	if check == nil {
+		return fmt.Errorf("there is no check named %s", name)
+	}
+
+	result, err := h.runCheck(h.Context, check.config)
+	if err != nil {
+		if h.Context.Err() == nil {
+			h.AddBlock(Block{Text: fmt.Sprintf("Check %s failed: %v", name, err), Type: ErrorBlock})
+		}
+		return err
+	}
+	h.mu.Lock()
+	check.last = &result
+	h.mu.Unlock()
+	h.AddBlock(Block{Text: result.String(), Type: CheckBlock})
+	if result.Problem && h.OnAlert != nil {
+		h.OnAlert(result)
+	}
+	return nil
+}
+
+// Checks describes the scheduled checks with their next run and last result.
+func (h *History) Checks() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var checks []string
+	for _, check := range h.checks {
+		line := check.config.Name
+		if !check.next.IsZero() {
+			line += ", next at " + check.next.Format(time.Kitchen)
+		}
+		if check.last != nil {
+			line += fmt.Sprintf(", last at %s: %s", check.last.Time.Format(time.Kitchen), check.last.Answer)
+		}
+		checks = append(checks, line)
+	}
+	return checks
+}
+
+// runCheck asks the model to assess a check in a chat of its own, so the
+// conversation is not disturbed. Only read-only tool calls are run.
+func (h *History) runCheck(ctx context.Context, check CheckConfig) (CheckResult, error) {
+	query := check.Prompt
+	if check.Command != "" {
+		tool, args, _ := strings.Cut(check.Command, " ")
+		fnCall := gollm.FunctionCall{Name: tool, Arguments: map[string]any{"command": strings.TrimSpace(args)}}
+		if !h.IsReadOnly(fnCall) {
+			return CheckResult{}, fmt.Errorf("%s is not read-only", check.Command)
+		}
+		output, err := h.Call(ctx, fnCall)
+		output, _ = h.Redact(output)
+		if err != nil {
+			output += fmt.Sprintf("\n(failed: %v)", err)
+		}
+		if query == "" {
+			query = defaultCommandCheck
+		}
+		query = fmt.Sprintf("Output of `%s`:\n\n%s\n\n%s", check.Command, output, query)
+	}
+
+	chat := h.startChat(checkPrompt + h.Cluster.PromptSection())
+	if err := chat.SetFunctionDefinitions(h.readOnlyDefinitions()); err != nil {
+		return CheckResult{}, err
+	}
+	resp, err := chat.Send(ctx, query)
+	for i := 0; ; i++ {
+		if err != nil {
+			return CheckResult{}, err
+		}
+		// a check running during a turn is not part of it
+		h.accountTo(resp, false)
+		if len(resp.Candidates()) == 0 {
+			return CheckResult{}, errors.New("no response from the model")
+		}
+
+		var answer strings.Builder
+		var results []any
+		for _, part := range resp.Candidates()[0].Parts() {
+			if text, ok := part.AsText(); ok {
+				answer.WriteString(text)
+			}
+			calls, _ := part.AsFunctionCalls()
+			for _, fnCall := range calls {
+				results = append(results, h.checkCall(ctx, fnCall))
+			}
+		}
+		if len(results) == 0 {
+			return checkResult(check.Name, answer.String()), nil
+		}
+		if i == chatIterations {
+			return CheckResult{}, fmt.Errorf("no answer after %d rounds of tool calls", chatIterations)
+		}
+		resp, err = chat.Send(ctx, results...)
+	}
+}
+
+// checkCall runs a tool call of a check, refusing anything not read-only.
+func (h *History) checkCall(ctx context.Context, fnCall gollm.FunctionCall) gollm.FunctionCallResult {
+	result := gollm.FunctionCallResult{ID: fnCall.ID, Name: fnCall.Name}
+	if fnCall.Name == writeManifestTool || !h.IsReadOnly(fnCall) {
+		result.Result = map[string]any{"error": "scheduled checks may only use read-only tools"}
+		return result
+	}
+	output, err := h.Call(ctx, fnCall)
+	if err != nil {
+		result.Result = map[string]any{"error": err.Error()}
+		return result
+	}
+	output, _ = h.Redact(output)
+	output, _ = h.Offload(fnCall, output)
+	result.Result = map[string]any{"output": output}
+	return result
+}
+
+// readOnlyDefinitions returns the definitions of the tools a check may use:
+// the read-only ones, and kubectl and gcloud for their read-only commands.
+// write_manifest runs without approval but still writes files, so checks do
+// not get it.
+func (h *History) readOnlyDefinitions() []*gollm.FunctionDefinition {
+	var defs []*gollm.FunctionDefinition
+	for _, def := range h.Definitions() {
+		if def.Name == writeManifestTool {
+			continue
+		}
+		if def.Name == "kubectl" || def.Name == "gcloud" || h.IsReadOnly(gollm.FunctionCall{Name: def.Name}) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// checkResult reads the model's verdict from its answer.
+func checkResult(name, answer string) CheckResult {
+	answer = strings.TrimSpace(answer)
+	return CheckResult{
+		Name:    name,
+		Problem: strings.HasPrefix(strings.ToUpper(answer), "PROBLEM"),
+		Answer:  answer,
+		Time:    time.Now(),
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// TestCheckSchedule validates checks and works out when they run.
+func TestCheckSchedule(t *testing.T) {
+	start := time.Date(2025, 6, 1, 10, 3, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		config string
+		next   time.Time
+		err    string
+	}{
+		{name: "interval", config: "{name: nodes, every: 10m, prompt: 'Are all nodes ready?'}", next: start.Add(10 * time.Minute)},
+		{name: "cron", config: "{name: nodes, cron: '*/15 * * * *', command: kubectl get nodes}", next: start.Add(12 * time.Minute)},
+		{name: "no name", config: "{every: 10m, prompt: x}", err: "a check needs a name"},
+		{name: "nothing to do", config: "{name: nodes, every: 10m}", err: "check nodes needs a prompt or a command"},
+		{name: "other command", config: "{name: nodes, every: 10m, command: rm -rf /}", err: "only kubectl and gcloud commands"},
+		{name: "no schedule", config: "{name: nodes, prompt: x}", err: "check nodes needs either every or cron"},
+		{name: "two schedules", config: "{name: nodes, every: 1m, cron: '* * * * *', prompt: x}", err: "check nodes needs either every or cron"},
+		{name: "bad cron", config: "{name: nodes, cron: 'every monday', prompt: x}", err: "check nodes:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config CheckConfig
+			require.NoError(t, yaml.Unmarshal([]byte(tt.config), &config))
+			schedule, err := config.schedule()
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, schedule.Next(start))
+		})
+	}
+}
+
+// checkBlocks returns the texts of the CheckBlocks of a history.
+func checkBlocks(h *History) []string {
+	var texts []string
+	for _, block := range h.Snapshot() {
+		if block.Type == CheckBlock {
+			texts = append(texts, block.Text)
+		}
+	}
+	return texts
+}
+
+// TestRunCheck runs a prompt check that may only use read-only tools and
+// alerts when the model flags a problem.
+func TestRunCheck(t *testing.T) {
+	fakeKubectl(t, `echo "node-1 NotReady"`)
+	h, chat := newFakeHistory(t,
+		callResponse(
+			toolCall("1", "kubectl", "get nodes"),
+			toolCall("2", "kubectl", "delete node node-1"),
+			gollm.FunctionCall{ID: "3", Name: writeManifestTool, Arguments: map[string]any{"name": "node.yaml", "content": "kind: Node"}},
+		),
+		withUsage(textResponse("PROBLEM: node-1 is not ready."), &geminiUsage{PromptTokenCount: 100, CandidatesTokenCount: 10}),
+	)
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "restart"},
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return "restarted", nil
+		},
+	}))
+	var alerts []CheckResult
+	h.OnAlert = func(result CheckResult) { alerts = append(alerts, result) }
+	require.NoError(t, h.StartChecks([]CheckConfig{{Name: "nodes", Every: time.Hour, Prompt: "Are all nodes ready?"}}))
+
+	require.NoError(t, h.RunCheck("nodes"))
+	var offered []string
+	for _, def := range chat.defs {
+		offered = append(offered, def.Name)
+	}
+	assert.Contains(t, offered, "kubectl")
+	assert.Contains(t, offered, k8sGetTool)
+	assert.NotContains(t, offered, "restart", "checks are only offered read-only tools")
+	assert.NotContains(t, offered, writeManifestTool, "checks do not write files")
+	assert.Equal(t, []string{"⚠ Check nodes: PROBLEM: node-1 is not ready."}, checkBlocks(h))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "nodes", alerts[0].Name)
+	assert.True(t, alerts[0].Problem)
+	assert.Contains(t, chat.systemPrompt, `"PROBLEM:"`)
+
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, []any{"Are all nodes ready?"}, sent[0])
+	assert.Equal(t, []any{
+		gollm.FunctionCallResult{ID: "1", Name: "kubectl", Result: map[string]any{"output": "node-1 NotReady\n"}},
+		gollm.FunctionCallResult{ID: "2", Name: "kubectl", Result: map[string]any{"error": "scheduled checks may only use read-only tools"}},
+		gollm.FunctionCallResult{ID: "3", Name: writeManifestTool, Result: map[string]any{"error": "scheduled checks may only use read-only tools"}},
+	}, sent[1])
+
+	turn, session := h.Usage()
+	assert.Zero(t, turn.TotalTokens, "checks are not part of a turn")
+	assert.Equal(t, 110, session.TotalTokens)
+
+	assert.ErrorContains(t, h.RunCheck("pods"), "there is no check named pods")
+	checks := h.Checks()
+	require.Len(t, checks, 1)
+	assert.Contains(t, checks[0], "PROBLEM: node-1 is not ready.")
+}
+
+// TestCommandCheck has the model assess the output of a command.
+func TestCommandCheck(t *testing.T) {
+	fakeKubectl(t, `echo "args: $*"`)
+	h, chat := newFakeHistory(t, textResponse("OK: nothing unusual."))
+	h.OnAlert = func(result CheckResult) { t.Errorf("unexpected alert for %s", result.Name) }
+	require.NoError(t, h.StartChecks([]CheckConfig{{Name: "events", Every: time.Hour, Command: "kubectl get events --types=Warning"}}))
+
+	require.NoError(t, h.RunCheck("events"))
+	assert.Equal(t, []string{"✓ Check events: OK: nothing unusual."}, checkBlocks(h))
+	sent := chat.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, []any{"Output of `kubectl get events --types=Warning`:\n\nargs: get events --types=Warning\n\n\n" + defaultCommandCheck}, sent[0])
+
+	assert.ErrorContains(t, h.StartChecks([]CheckConfig{
+		{Name: "a", Every: time.Hour, Prompt: "x"},
+		{Name: "a", Every: time.Hour, Prompt: "y"},
+	}), "there are several checks named a")
+}
+
+// TestScheduledChecks runs checks on their schedule until the conversation ends.
+func TestScheduledChecks(t *testing.T) {
+	h, _ := newFakeHistory(t, textResponse("PROBLEM: the API server is slow."))
+	var mu sync.Mutex
+	alerts := 0
+	h.OnAlert = func(CheckResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		alerts++
+	}
+	require.NoError(t, h.StartChecks([]CheckConfig{{Name: "api", Every: 20 * time.Millisecond, Prompt: "Is the API server healthy?"}}))
+
+	require.Eventually(t, func() bool { return len(checkBlocks(h)) >= 3 }, 5*time.Second, 10*time.Millisecond)
+	blocks := checkBlocks(h)
+	assert.Equal(t, "⚠ Check api: PROBLEM: the API server is slow.", blocks[0])
+	// the fake model has nothing to say after its first answer
+	assert.True(t, strings.HasPrefix(blocks[1], "✓ Check api"))
+	mu.Lock()
+	assert.Equal(t, 1, alerts)
+	mu.Unlock()
+	assert.Contains(t, h.Checks()[0], "api, next at ")
+}
+
+// TestChecksCommand lists the checks and runs one now.
+func TestChecksCommand(t *testing.T) {
+	doc, _ := newTestDoc(t, textResponse("OK: all nodes are ready."))
+	send(doc, "/checks")
+	assert.Contains(t, lastBlock(doc).Text, "No checks are scheduled")
+
+	require.NoError(t, doc.StartChecks([]CheckConfig{{Name: "nodes", Every: time.Hour, Prompt: "Are all nodes ready?"}}))
+	send(doc, "/checks")
+	assert.Contains(t, lastBlock(doc).Text, "Scheduled checks:\n\n- nodes")
+	send(doc, "/checks run nodes")
+	assert.Equal(t, Block{Text: "✓ Check nodes: OK: all nodes are ready.", Type: CheckBlock}, lastBlock(doc))
+	send(doc, "/checks nodes")
+	assert.Equal(t, "usage: /checks [run name]", lastBlock(doc).Text)
+}
@@ -0,0 +1,322 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Command is a slash command typed into the input box, such as /help.
+type Command struct {
+	// Name is the command without the slash.
+	Name string
+	// Usage shows the arguments, e.g. "/context [name]".
+	Usage       string
+	Description string
+	// Run handles the command. The returned tea.Cmd, if any, is run by the
+	// program, see Document.background for long-running work. An error is
+	// shown as an error block.
+	Run func(doc *Document, args []string) (tea.Cmd, error)
+}
+
+// RegisterCommand adds a slash command. Command names must be unique.
+func (doc *Document) RegisterCommand(cmd Command) error {
+	if _, ok := doc.commands[cmd.Name]; ok {
+		return fmt.Errorf("command /%s is already registered", cmd.Name)
+	}
+	doc.commands[cmd.Name] = cmd
+	return nil
+}
+
+// Commands returns the registered slash commands sorted by name.
+func (doc *Document) Commands() []Command {
+	var commands []Command
+	for _, cmd := range doc.commands {
+		commands = append(commands, cmd)
+	}
+	slices.SortFunc(commands, func(a, b Command) int { return strings.Compare(a.Name, b.Name) })
+	return commands
+}
+
+// background runs fn like a turn: the input is locked until it finishes.
+func (doc *Document) background(fn func()) tea.Cmd {
+	doc.running = true
+	return func() tea.Msg {
+		fn()
+		return turnDoneMsg{}
+	}
+}
+
+// handleCommand runs a slash command. It returns false for input that
+// should go to the model.
+func (doc *Document) handleCommand(userInput string) (tea.Cmd, bool) {
+	if !strings.HasPrefix(userInput, "/") {
+		return nil, false
+	}
+
+	fields := strings.Fields(userInput)
+	cmd, ok := doc.commands[strings.TrimPrefix(fields[0], "/")]
+	if !ok {
+		doc.AddBlock(Block{
+			Text: fmt.Sprintf("Unknown command %s. Type /help to see the available commands.", fields[0]),
+			Type: ErrorBlock,
+		})
+		return nil, true
+	}
+
+	teaCmd, err := cmd.Run(doc, fields[1:])
+	if err != nil {
+		doc.AddBlock(Block{Text: err.Error(), Type: ErrorBlock})
+	}
+	return teaCmd, true
+}
+
+// builtinCommands are registered with every document.
+var builtinCommands = []Command{
+	{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "List the available commands.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			var sb strings.Builder
+			sb.WriteString("| Command | Description |\n|---|---|\n")
+			for _, cmd := range doc.Commands() {
+				fmt.Fprintf(&sb, "| `%s` | %s |\n", cmd.Usage, cmd.Description)
+			}
+			doc.AddBlock(Block{Text: sb.String(), Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "clear",
+		Usage:       "/clear",
+		Description: "Clear the screen and start a new conversation.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			doc.Clear()
+			return nil, nil
+		},
+	},
+	{
+		Name:        "model",
+		Usage:       "/model [name]",
+		Description: "Show the model or switch to another one.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) == 0 {
+				doc.AddBlock(Block{Text: "Current model: " + doc.Model, Type: AgentBlock})
+				return nil, nil
+			}
+			if err := doc.SwitchModel(args[0]); err != nil {
+				return nil, err
+			}
+			doc.AddBlock(Block{Text: "Switched to model " + args[0], Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "export",
+		Usage:       "/export [file]",
+		Description: "Save the conversation as Markdown, or as JSON for a .json file.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			path := fmt.Sprintf("bubblechat-%s.md", time.Now().Format("20060102-150405"))
+			if len(args) > 0 {
+				path = args[0]
+			}
+			file, err := os.Create(path)
+			if err != nil {
+				return nil, err
+			}
+			if filepath.Ext(path) == ".json" {
+				err = doc.ExportJSON(file)
+			} else {
+				err = doc.ExportMarkdown(file)
+			}
+			if err = errors.Join(err, file.Close()); err != nil {
+				return nil, fmt.Errorf("exporting to %s: %w", path, err)
+			}
+			doc.AddBlock(Block{Text: "Exported the conversation to " + path, Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "context",
+		Usage:       "/context [name]",
+		Description: "List the kube contexts or switch to one.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) == 0 {
+				contexts, err := ListKubeContexts()
+				if err != nil {
+					return nil, err
+				}
+				var sb strings.Builder
+				for _, name := range contexts {
+					if name == doc.Cluster.KubeContext {
+						fmt.Fprintf(&sb, "- **%s** (current)\n", name)
+					} else {
+						fmt.Fprintf(&sb, "- %s\n", name)
+					}
+				}
+				doc.AddBlock(Block{Text: sb.String(), Type: AgentBlock})
+				return nil, nil
+			}
+			if err := doc.SwitchContext(args[0]); err != nil {
+				return nil, err
+			}
+			doc.AddBlock(Block{Text: "Switched to kube context " + args[0], Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "namespace",
+		Usage:       "/namespace [name]",
+		Description: "Show the default namespace or change it.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) == 0 {
+				doc.AddBlock(Block{Text: "Current namespace: " + doc.Cluster.Namespace, Type: AgentBlock})
+				return nil, nil
+			}
+			doc.SwitchNamespace(args[0])
+			doc.AddBlock(Block{Text: "Switched to namespace " + args[0], Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "compact",
+		Usage:       "/compact",
+		Description: "Summarize older turns to free up the context window.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			return doc.background(func() { doc.Compact() }), nil
+		},
+	},
+	{
+		Name:        "pin",
+		Usage:       "/pin [note]",
+		Description: "Keep a note across compactions, or list the pinned notes.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) == 0 {
+				pins := doc.Pins()
+				if len(pins) == 0 {
+					doc.AddBlock(Block{Text: "Nothing is pinned. Use `/pin <note>` to keep a note across compactions.", Type: AgentBlock})
+					return nil, nil
+				}
+				doc.AddBlock(Block{Text: "Pinned:\n\n- " + strings.Join(pins, "\n- "), Type: AgentBlock})
+				return nil, nil
+			}
+			note := strings.Join(args, " ")
+			doc.Pin(note)
+			doc.AddBlock(Block{Text: "Pinned: " + note, Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "run",
+		Usage:       "/run [name] [param=value ...]",
+		Description: "List the runbooks, or run one with its parameters.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) > 0 {
+				return doc.runRunbook(args)
+			}
+			if len(doc.runbooks) == 0 {
+				return nil, fmt.Errorf("there are no runbooks, add them to %s", doc.runbookDir)
+			}
+			var sb strings.Builder
+			sb.WriteString("Runbooks:\n")
+			for _, runbook := range doc.runbooks {
+				fmt.Fprintf(&sb, "\n- `%s` %s", runbook.Usage(), runbook.Description)
+			}
+			doc.AddBlock(Block{Text: sb.String(), Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "checks",
+		Usage:       "/checks [run name]",
+		Description: "List the scheduled checks, or run one now.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) == 0 {
+				checks := doc.Checks()
+				if len(checks) == 0 {
+					doc.AddBlock(Block{Text: "No checks are scheduled. Add them under `checks` in the configuration.", Type: AgentBlock})
+					return nil, nil
+				}
+				doc.AddBlock(Block{Text: "Scheduled checks:\n\n- " + strings.Join(checks, "\n- "), Type: AgentBlock})
+				return nil, nil
+			}
+			if args[0] != "run" || len(args) != 2 {
+				return nil, errors.New("usage: /checks [run name]")
+			}
+			return doc.background(func() { doc.RunCheck(args[1]) }), nil
+		},
+	},
+	{
+		Name:        "watch",
+		Usage:       "/watch [pods|events|deployments] [-n ns|-A] [--types=Warning] [--triage] | stop [id]",
+		Description: "Watch the cluster in the background and post notable changes, or list and stop the watches.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			if len(args) == 0 {
+				watches := doc.Watches()
+				if len(watches) == 0 {
+					doc.AddBlock(Block{Text: "Nothing is watched. Use e.g. `/watch pods -n payments` or `/watch events --types=Warning`.", Type: AgentBlock})
+					return nil, nil
+				}
+				doc.AddBlock(Block{Text: "Watching:\n\n- " + strings.Join(watches, "\n- "), Type: AgentBlock})
+				return nil, nil
+			}
+			if args[0] == "stop" {
+				id := 0
+				if len(args) > 1 {
+					var err error
+					if id, err = strconv.Atoi(args[1]); err != nil {
+						return nil, fmt.Errorf("not a watch ID: %s", args[1])
+					}
+				}
+				stopped := doc.StopWatch(id)
+				if len(stopped) == 0 {
+					return nil, errors.New("no such watch, /watch lists them")
+				}
+				for _, spec := range stopped {
+					doc.AddBlock(Block{Text: "Stopped watching " + spec.String(), Type: AgentBlock})
+				}
+				return nil, nil
+			}
+			spec, err := ParseWatchSpec(args, doc.Cluster.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			id, err := doc.StartWatch(spec)
+			if err != nil {
+				return nil, err
+			}
+			doc.AddBlock(Block{Text: fmt.Sprintf("Watching %s (watch %d). `/watch stop %d` stops it.", spec, id, id), Type: AgentBlock})
+			return nil, nil
+		},
+	},
+	{
+		Name:        "quit",
+		Usage:       "/quit",
+		Description: "Leave BubbleChat.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			return tea.Quit, nil
+		},
+	},
+}
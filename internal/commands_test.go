@@ -0,0 +1,135 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDoc returns a document backed by the fake LLM.
+func newTestDoc(t *testing.T, responses ...gollm.ChatResponse) (*Document, *fakeChat) {
+	t.Helper()
+	client, chat := newFakeClient(responses...)
+	return NewDoc(t.Context(), client, &Config{Model: "fake-model"}), chat
+}
+
+// send types input into the document and presses Enter. Background work is
+// run to completion.
+func send(doc *Document, input string) tea.Msg {
+	doc.input.SetValue(input)
+	cmd := doc.HandleSend()
+	if cmd == nil {
+		return nil
+	}
+	msg := cmd()
+	if _, ok := msg.(turnDoneMsg); ok {
+		doc.running = false
+	}
+	return msg
+}
+
+// lastBlock returns the latest block of the document.
+func lastBlock(doc *Document) Block {
+	blocks := doc.Snapshot()
+	return blocks[len(blocks)-1]
+}
+
+// TestCommands runs the built-in commands and a registered one.
+func TestCommands(t *testing.T) {
+	doc, chat := newTestDoc(t, textResponse("hello"))
+
+	var got []string
+	require.NoError(t, doc.RegisterCommand(Command{
+		Name:        "echo",
+		Usage:       "/echo [words]",
+		Description: "Echo the words.",
+		Run: func(doc *Document, args []string) (tea.Cmd, error) {
+			got = args
+			return nil, nil
+		},
+	}))
+	assert.Error(t, doc.RegisterCommand(Command{Name: "help"}))
+
+	send(doc, "/echo a b")
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	send(doc, "/help")
+	assert.Contains(t, lastBlock(doc).Text, "| `/echo [words]` | Echo the words. |")
+	assert.Contains(t, lastBlock(doc).Text, "`/quit`")
+
+	send(doc, "/frobnicate")
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+	assert.Contains(t, lastBlock(doc).Text, "Unknown command /frobnicate")
+	assert.Empty(t, chat.Sent(), "commands never reach the model")
+
+	assert.Equal(t, tea.Quit(), send(doc, "/quit"))
+
+	send(doc, "/clear")
+	assert.Empty(t, doc.Snapshot())
+}
+
+// TestModelCommand switches the model and hands the conversation over.
+func TestModelCommand(t *testing.T) {
+	doc, chat := newTestDoc(t, textResponse("pods are fine"), textResponse("still fine"))
+	send(doc, "how are my pods?")
+
+	send(doc, "/model")
+	assert.Equal(t, "Current model: fake-model", lastBlock(doc).Text)
+
+	send(doc, "/model gemini-2.5-pro")
+	assert.Equal(t, "gemini-2.5-pro", doc.Model)
+
+	send(doc, "and now?")
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	assert.Contains(t, sent[1][0].(string), "The conversation so far, with fake-model")
+	assert.Contains(t, sent[1][0].(string), "model: pods are fine")
+}
+
+// TestExportCommand exports the conversation with its usage.
+func TestExportCommand(t *testing.T) {
+	doc, _ := newTestDoc(t, withUsage(textResponse("all good"), &geminiUsage{PromptTokenCount: 40, CandidatesTokenCount: 2}))
+	send(doc, "check the cluster")
+	dir := t.TempDir()
+
+	md := filepath.Join(dir, "chat.md")
+	send(doc, "/export "+md)
+	data, err := os.ReadFile(md)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "## check the cluster")
+	assert.Contains(t, string(data), "all good")
+	assert.Contains(t, string(data), "40 prompt tokens, 2 completion tokens, 42 in total")
+
+	js := filepath.Join(dir, "chat.json")
+	send(doc, "/export "+js)
+	data, err = os.ReadFile(js)
+	require.NoError(t, err)
+	var export Export
+	require.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, "fake-model", export.Model)
+	assert.Equal(t, 42, export.Usage.TotalTokens)
+	assert.Equal(t, UserBlock, export.Blocks[1].Type)
+
+	send(doc, "/export "+filepath.Join(dir, "missing", "chat.md"))
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+}
@@ -0,0 +1,231 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// DefaultContextLimits are the context windows of common Gemini models in tokens.
+var DefaultContextLimits = map[string]int{
+	"gemini-2.0-flash":      1_048_576,
+	"gemini-2.0-flash-lite": 1_048_576,
+	"gemini-2.5-flash":      1_048_576,
+	"gemini-2.5-pro":        1_048_576,
+}
+
+const (
+	// defaultContextLimit is assumed for models with an unknown context window.
+	defaultContextLimit = 128_000
+	// defaultCompactAt is the fraction of the context window that triggers a compaction.
+	defaultCompactAt = 0.8
+	// keepTurns is how many of the latest turns a compaction keeps verbatim.
+	keepTurns = 2
+	// charsPerToken estimates tokens from text when the model reports no usage.
+	charsPerToken = 4
+)
+
+// summaryPrompt instructs the model that compacts older turns.
+const summaryPrompt = `You summarize a conversation between a user and a Kubernetes troubleshooting assistant.
+Keep the user's goals, the resources, namespaces and contexts involved, what the tools found,
+what was changed and what is still open. Replace long tool output with the facts that matter.
+Answer with the summary only.`
+
+// transcriptEntry is one message of the conversation as the model saw it.
+type transcriptEntry struct {
+	// Role is user, model, tool or summary.
+	Role string
+	Text string
+	// Turn is the number of the user query the entry belongs to, 0 for a summary.
+	Turn int
+}
+
+// record appends a message to the transcript.
+func (h *History) record(role, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transcript = append(h.transcript, transcriptEntry{Role: role, Text: text, Turn: h.turns})
+}
+
+// recordResponse appends the text and tool calls of a model response to the transcript.
+func (h *History) recordResponse(resp gollm.ChatResponse) {
+	if resp == nil || len(resp.Candidates()) == 0 {
+		return
+	}
+	for _, part := range resp.Candidates()[0].Parts() {
+		if calls, ok := part.AsFunctionCalls(); ok {
+			for _, call := range calls {
+				h.record("model", describeCall(call))
+			}
+		} else if text, ok := part.AsText(); ok && text != "" {
+			h.record("model", text)
+		}
+	}
+}
+
+// describeResult renders a tool result for the transcript.
+func describeResult(result gollm.FunctionCallResult) string {
+	data, err := json.Marshal(result.Result)
+	if err != nil {
+		return fmt.Sprintf("Result of %s: %v", result.Name, result.Result)
+	}
+	return fmt.Sprintf("Result of %s: %s", result.Name, data)
+}
+
+// renderTranscript formats transcript entries as plain text.
+func renderTranscript(entries []transcriptEntry) string {
+	var sb strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&sb, "%s: %s\n\n", entry.Role, entry.Text)
+	}
+	return sb.String()
+}
+
+// Pin keeps a note verbatim across compactions, e.g. a finding the model must not lose.
+func (h *History) Pin(note string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pins = append(h.pins, note)
+}
+
+// Pins returns the pinned notes.
+func (h *History) Pins() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.pins...)
+}
+
+// ContextTokens returns the size of the conversation's context and the
+// model's limit. The size is what the model reported for the latest
+// exchange, or an estimate from the text when it reported nothing.
+func (h *History) ContextTokens() (used, limit int) {
+	limit, ok := modelValue(h.Limits, h.Model)
+	if !ok {
+		limit = defaultContextLimit
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.contextTokens > 0 {
+		return h.contextTokens, limit
+	}
+	chars := len(systemPrompt) + len(h.carryOver)
+	for _, entry := range h.transcript {
+		chars += len(entry.Text)
+	}
+	return chars / charsPerToken, limit
+}
+
+// shouldCompact reports whether the context is close enough to the limit to compact it.
+func (h *History) shouldCompact() bool {
+	compactAt := h.CompactAt
+	if compactAt <= 0 {
+		compactAt = defaultCompactAt
+	}
+	used, limit := h.ContextTokens()
+	return float64(used) >= compactAt*float64(limit)
+}
+
+// Compact replaces the older turns of the conversation with a summary written
+// by the model. The latest turns and the pinned notes are kept verbatim. The
+// model continues in a new chat that receives all of it with the next query.
+func (h *History) Compact() error {
+	h.mu.Lock()
+	var old, recent []transcriptEntry
+	for _, entry := range h.transcript {
+		if entry.Turn > h.turns-keepTurns {
+			recent = append(recent, entry)
+		} else {
+			old = append(old, entry)
+		}
+	}
+	h.mu.Unlock()
+
+	if len(old) == 0 {
+		err := errors.New("nothing to compact yet")
+		h.AddBlock(Block{Text: "Not compacting: " + err.Error(), Type: AgentBlock})
+		return err
+	}
+	before, _ := h.ContextTokens()
+
+	summary, err := h.summarize(old)
+	if err != nil {
+		h.AddBlock(Block{Text: fmt.Sprintf("Error compacting the conversation: %v", err), Type: ErrorBlock})
+		return err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("The earlier conversation was compacted. Summary of it:\n\n")
+	sb.WriteString(summary)
+	if pins := h.Pins(); len(pins) > 0 {
+		sb.WriteString("\n\nPinned notes:\n")
+		for _, pin := range pins {
+			fmt.Fprintf(&sb, "- %s\n", pin)
+		}
+	}
+	if len(recent) > 0 {
+		sb.WriteString("\n\nThe latest turns, verbatim:\n\n")
+		sb.WriteString(renderTranscript(recent))
+	}
+
+	chat := h.startChat(systemPrompt + h.Cluster.PromptSection())
+	if err := chat.SetFunctionDefinitions(h.Definitions()); err != nil {
+		h.AddBlock(Block{Text: fmt.Sprintf("Error compacting the conversation: %v", err), Type: ErrorBlock})
+		return err
+	}
+
+	h.mu.Lock()
+	h.Chat = chat
+	h.carryOver = strings.TrimSpace(sb.String())
+	h.transcript = append([]transcriptEntry{{Role: "summary", Text: summary}}, recent...)
+	h.contextTokens = 0
+	h.mu.Unlock()
+
+	after, _ := h.ContextTokens()
+	h.AddBlock(Block{
+		Text: fmt.Sprintf("Compacted the conversation from about %d to about %d tokens, keeping the last %d turns.", before, after, keepTurns),
+		Type: AgentBlock,
+	})
+	return nil
+}
+
+// summarize asks the model for a summary of transcript entries in a separate chat.
+func (h *History) summarize(entries []transcriptEntry) (string, error) {
+	chat := h.startChat(summaryPrompt)
+	resp, err := chat.Send(h.Context, renderTranscript(entries))
+	if err != nil {
+		return "", err
+	}
+	h.account(resp)
+
+	var sb strings.Builder
+	if len(resp.Candidates()) > 0 {
+		for _, part := range resp.Candidates()[0].Parts() {
+			if text, ok := part.AsText(); ok {
+				sb.WriteString(text)
+			}
+		}
+	}
+	summary := strings.TrimSpace(sb.String())
+	if summary == "" {
+		return "", errors.New("the model returned an empty summary")
+	}
+	return summary, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompact summarizes the oldest turn and hands the summary, the pins
+// and the latest turns to the next query.
+func TestCompact(t *testing.T) {
+	h, chat := newFakeHistory(t,
+		textResponse("pods are crashlooping"),
+		textResponse("the image tag is wrong"),
+		textResponse("fixed in the manifest"),
+		textResponse("User investigated crashlooping pods in web."),
+		textResponse("anything else?"),
+	)
+	assert.ErrorContains(t, h.Compact(), "nothing to compact")
+
+	h.ChatLoop("why is web down?")
+	h.ChatLoop("what is wrong with it?")
+	h.ChatLoop("fix it")
+	h.Pin("web runs in namespace shop")
+
+	require.NoError(t, h.Compact())
+	sent := chat.Sent()
+	require.Len(t, sent, 4)
+	summaryRequest := sent[3][0].(string)
+	assert.Contains(t, summaryRequest, "user: why is web down?")
+	assert.Contains(t, summaryRequest, "model: pods are crashlooping")
+	assert.NotContains(t, summaryRequest, "fix it")
+
+	h.ChatLoop("thanks")
+	sent = chat.Sent()
+	query := sent[4][0].(string)
+	assert.Contains(t, query, "User investigated crashlooping pods in web.")
+	assert.Contains(t, query, "- web runs in namespace shop")
+	assert.Contains(t, query, "user: what is wrong with it?")
+	assert.Contains(t, query, "model: fixed in the manifest")
+	assert.NotContains(t, query, "why is web down?")
+	assert.Contains(t, query, "thanks")
+	assert.Contains(t, chat.systemPrompt, "## Environment", "the new chat gets the full system prompt")
+
+	assert.True(t, hasBlockPrefix(h, "Compacted the conversation"))
+}
+
+// hasBlockPrefix reports whether a block of the history starts with prefix.
+func hasBlockPrefix(h *History, prefix string) bool {
+	for _, b := range h.Snapshot() {
+		if strings.HasPrefix(b.Text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAutoCompact compacts before a query once the model reports a context
+// close to the limit.
+func TestAutoCompact(t *testing.T) {
+	h, chat := newFakeHistory(t,
+		withUsage(textResponse("one"), &geminiUsage{TotalTokenCount: 10}),
+		withUsage(textResponse("two"), &geminiUsage{TotalTokenCount: 50}),
+		withUsage(textResponse("three"), &geminiUsage{TotalTokenCount: 90}),
+		textResponse("summary of one"),
+		textResponse("four"),
+	)
+	h.Limits = map[string]int{"fake-model": 100}
+
+	h.ChatLoop("first")
+	h.ChatLoop("second")
+	used, limit := h.ContextTokens()
+	assert.Equal(t, 50, used)
+	assert.Equal(t, 100, limit)
+
+	h.ChatLoop("third")
+	h.ChatLoop("fourth")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 5)
+	assert.Contains(t, sent[3][0].(string), "user: first")
+	assert.Contains(t, sent[4][0].(string), "summary of one")
+	assert.True(t, hasBlockPrefix(h, "Compacted the conversation"))
+}
@@ -0,0 +1,184 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional bubblechat configuration file.
+type Config struct {
+	// Model overrides the default LLM model.
+	Model string `yaml:"model"`
+	// MCPServers are Model Context Protocol servers whose tools are offered to the model.
+	MCPServers []MCPServerConfig `yaml:"mcpServers"`
+	// ContextRules protect kube contexts and gcloud projects against mutating commands.
+	ContextRules []ContextRule `yaml:"contextRules"`
+	// Prices add to and override DefaultPrices, in USD per million tokens by model name.
+	Prices map[string]Price `yaml:"prices"`
+	// ContextLimits add to and override DefaultContextLimits, in tokens by model name.
+	ContextLimits map[string]int `yaml:"contextLimits"`
+	// CompactAt is the fraction of the context window at which older turns are compacted.
+	CompactAt float64 `yaml:"compactAt"`
+	// Redaction configures what is masked in tool output before the model sees it.
+	Redaction RedactionConfig `yaml:"redaction"`
+	// AuditFile is where tool calls are recorded. It defaults to AuditPath().
+	AuditFile string `yaml:"auditLog"`
+	// PromptHistoryFile is where sent prompts are remembered. It defaults to PromptHistoryPath().
+	PromptHistoryFile string `yaml:"promptHistory"`
+	// PromptHistorySize is how many prompts are remembered, 1000 by default.
+	PromptHistorySize int `yaml:"promptHistorySize"`
+	// Workspace is where every session gets a directory for the manifests
+	// the model writes. It defaults to WorkspacePath().
+	Workspace string `yaml:"workspace"`
+	// ArtifactThreshold is the size in bytes above which tool output is stored
+	// as an artifact the model pages through. It defaults to DefaultArtifactThreshold.
+	ArtifactThreshold int `yaml:"artifactThreshold"`
+	// ToolWorkers is how many read-only tool calls run at the same time,
+	// DefaultToolWorkers by default.
+	ToolWorkers int `yaml:"toolWorkers"`
+	// Retry is how failed calls to the model are retried, see DefaultRetry.
+	Retry RetryConfig `yaml:"retry"`
+	// Checks run on a schedule in the first tab of the terminal UI.
+	Checks []CheckConfig `yaml:"checks"`
+	// RunbookDir holds the prompt templates run with /run. It defaults to RunbooksPath().
+	RunbookDir string `yaml:"runbooks"`
+
+	// Tools are registered with every conversation in addition to kubectl and gcloud.
+	// They are not read from the file but discovered at startup, e.g. from MCPServers.
+	Tools []Tool `yaml:"-"`
+	// Cluster is the kube context, namespace and project detected at startup.
+	Cluster ClusterContext `yaml:"-"`
+	// Audit is the opened audit log shared by every conversation.
+	Audit *AuditLog `yaml:"-"`
+	// Prompts is the loaded prompt history of the terminal UI. Without it
+	// prompts are only remembered for the session.
+	Prompts *PromptHistory `yaml:"-"`
+	// Runbooks are the loaded runbooks of the terminal UI, see LoadRunbooks.
+	Runbooks []Runbook `yaml:"-"`
+}
+
+// ConfigPath returns the location of the configuration file. It is taken from
+// BUBBLECHAT_CONFIG if set and defaults to bubblechat/config.yaml in the user's
+// configuration directory.
+func ConfigPath() string {
+	if path := os.Getenv("BUBBLECHAT_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bubblechat", "config.yaml")
+}
+
+// LoadConfig reads the configuration file at path.
+// A missing file is not an error and yields the default configuration.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// AuditPath returns the configured audit log location or the default one.
+func (c *Config) AuditPath() string {
+	if c.AuditFile != "" {
+		return c.AuditFile
+	}
+	return AuditPath()
+}
+
+// WorkspacePath returns the directory holding the session workspaces.
+func (c *Config) WorkspacePath() string {
+	if c.Workspace != "" {
+		return c.Workspace
+	}
+	return WorkspacePath()
+}
+
+// PromptHistoryPath returns where prompts are remembered.
+func (c *Config) PromptHistoryPath() string {
+	if c.PromptHistoryFile != "" {
+		return c.PromptHistoryFile
+	}
+	return PromptHistoryPath()
+}
+
+// RunbooksPath returns the directory of the runbooks.
+func (c *Config) RunbooksPath() string {
+	if c.RunbookDir != "" {
+		return c.RunbookDir
+	}
+	return RunbooksPath()
+}
+
+// NewExecutor creates a tool executor set up according to the configuration.
+// The executor is usable even when some of the tools could not be registered.
+func (c *Config) NewExecutor() (*Executor, error) {
+	e := NewExecutor()
+	e.Cluster = c.Cluster
+	e.Rules = c.ContextRules
+	e.Audit = c.Audit
+	e.Session = newSessionID()
+	e.Workspace = filepath.Join(c.WorkspacePath(), e.Session)
+	e.ArtifactThreshold = c.ArtifactThreshold
+	e.BlockTokenCommands = c.Redaction.BlockTokenCommands
+	redactor, err := NewRedactor(c.Redaction.Patterns)
+	e.Redactor = redactor
+	errs := []error{err}
+	for _, tool := range c.Tools {
+		errs = append(errs, e.RegisterTool(tool))
+	}
+	return e, errors.Join(errs...)
+}
+
+// NewHistory creates a conversation set up according to the configuration.
+func (c *Config) NewHistory(ctx context.Context, client gollm.Client) *History {
+	executor, err := c.NewExecutor()
+	h := newHistory(ctx, client, c.Model, executor)
+	h.Prices = mergeModelValues(DefaultPrices, c.Prices)
+	h.Limits = mergeModelValues(DefaultContextLimits, c.ContextLimits)
+	h.CompactAt = c.CompactAt
+	h.ToolWorkers = c.ToolWorkers
+	h.Retry = c.Retry
+	if err != nil {
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Error setting up tools: %v", err),
+			Type: ErrorBlock,
+		})
+	}
+	return h
+}
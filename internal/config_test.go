@@ -0,0 +1,66 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfig writes a config file into a temporary directory and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// TestLoadConfig reads a config file with MCP servers.
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+model: gemini-2.5-pro
+mcpServers:
+  - name: runbooks
+    command: runbook-mcp
+    args: ["--index", "/srv/runbooks"]
+    env:
+      RUNBOOK_TOKEN: secret
+  - name: tickets
+    url: http://localhost:9000/mcp
+`)
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "gemini-2.5-pro", cfg.Model)
+	require.Len(t, cfg.MCPServers, 2)
+	assert.Equal(t, []string{"--index", "/srv/runbooks"}, cfg.MCPServers[0].Args)
+	assert.Equal(t, "secret", cfg.MCPServers[0].Env["RUNBOOK_TOKEN"])
+	assert.Equal(t, "http://localhost:9000/mcp", cfg.MCPServers[1].URL)
+}
+
+// TestLoadConfigMissing checks that a missing file gives the defaults
+// and a malformed one an error.
+func TestLoadConfigMissing(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "nope.yaml"))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.MCPServers)
+
+	_, err = LoadConfig(writeConfig(t, "mcpServers: {"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxInputHeight is how many lines the input grows to before it scrolls.
+const maxInputHeight = 10
+
+// shiftEnterSequences are how terminals with extended keyboard reporting send
+// Shift+Enter: the kitty protocol and xterm's modifyOtherKeys. Bubble Tea does
+// not decode them, so they are recognized by the name it gives unknown input.
+var shiftEnterSequences = map[string]bool{
+	fmt.Sprintf("?CSI%+v?", []byte("13;2u")):    true,
+	fmt.Sprintf("?CSI%+v?", []byte("27;2;13~")): true,
+}
+
+// editorDoneMsg reports that the external editor exited.
+type editorDoneMsg struct {
+	path string
+	err  error
+}
+
+// newInput creates the multi-line prompt editor. Enter is handled by the
+// document to send the prompt, so newlines are typed with Alt+Enter or Ctrl+J.
+func newInput() textarea.Model {
+	input := textarea.New()
+	input.Placeholder = "Ask about your cluster..."
+	input.ShowLineNumbers = false
+	input.Prompt = "> "
+	input.CharLimit = 0
+	// the document sizes the input, see resizeInput
+	input.MaxHeight = 0
+	input.SetHeight(1)
+	input.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"))
+	input.Focus()
+	return input
+}
+
+// isShiftEnter reports whether msg is a Shift+Enter the terminal sent as an escape sequence.
+func isShiftEnter(msg tea.Msg) bool {
+	s, ok := msg.(fmt.Stringer)
+	return ok && shiftEnterSequences[s.String()]
+}
+
+// resizeInput grows the input with its content up to maxInputHeight lines.
+func (doc *Document) resizeInput() {
+	doc.input.SetHeight(min(max(doc.input.LineCount(), 1), maxInputHeight))
+}
+
+// openEditor edits the prompt in $EDITOR, or vi if it is not set. The
+// program is suspended until the editor exits.
+func (doc *Document) openEditor() tea.Cmd {
+	file, err := os.CreateTemp("", "bubblechat-*.md")
+	if err != nil {
+		return func() tea.Msg { return editorDoneMsg{err: err} }
+	}
+	_, err = file.WriteString(doc.input.Value())
+	if err = errors.Join(err, file.Close()); err != nil {
+		return func() tea.Msg { return editorDoneMsg{path: file.Name(), err: err} }
+	}
+
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorDoneMsg{path: file.Name(), err: err}
+	})
+}
+
+// editorDone puts what was written in the editor into the input.
+func (doc *Document) editorDone(msg editorDoneMsg) {
+	if msg.path != "" {
+		defer os.Remove(msg.path)
+	}
+	if msg.err != nil {
+		doc.AddBlock(Block{Text: fmt.Sprintf("Error running the editor: %v", msg.err), Type: ErrorBlock})
+		return
+	}
+
+	data, err := os.ReadFile(msg.path)
+	if err != nil {
+		doc.AddBlock(Block{Text: fmt.Sprintf("Error reading the prompt from the editor: %v", err), Type: ErrorBlock})
+		return
+	}
+	doc.input.SetValue(strings.TrimRight(string(data), "\n"))
+	doc.resizeInput()
+}
@@ -0,0 +1,69 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// csiSequence stands in for the unknown escape sequences Bubble Tea reports.
+type csiSequence string
+
+func (s csiSequence) String() string { return string(s) }
+
+// TestMultiLineInput types a prompt over several lines and sends it.
+func TestMultiLineInput(t *testing.T) {
+	doc, chat := newTestDoc(t, textResponse("looks valid"))
+
+	doc.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("validate this:")})
+	doc.Update(tea.KeyMsg{Type: tea.KeyEnter, Alt: true})
+	doc.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("kind: Pod\nmetadata:\n  name: web"), Paste: true})
+	doc.Update(csiSequence("?CSI[49 51 59 50 117]?"))
+	doc.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("thanks")})
+	assert.Equal(t, "validate this:\nkind: Pod\nmetadata:\n  name: web\nthanks", doc.input.Value())
+	assert.Equal(t, 5, doc.input.Height())
+
+	_, cmd := doc.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	cmd()
+	assert.Empty(t, doc.input.Value())
+	assert.Equal(t, 1, doc.input.Height())
+
+	sent := chat.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "validate this:\nkind: Pod\nmetadata:\n  name: web\nthanks", sent[0][0])
+}
+
+// TestEditorDone reads the prompt written in the external editor.
+func TestEditorDone(t *testing.T) {
+	doc, _ := newTestDoc(t)
+	path := filepath.Join(t.TempDir(), "prompt.md")
+	require.NoError(t, os.WriteFile(path, []byte("line one\nline two\n"), 0o600))
+
+	doc.Update(editorDoneMsg{path: path})
+	assert.Equal(t, "line one\nline two", doc.input.Value())
+	assert.NoFileExists(t, path)
+
+	doc.Update(editorDoneMsg{err: errors.New("exit status 1")})
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+	assert.Equal(t, "line one\nline two", doc.input.Value(), "a failed edit keeps the input")
+}
@@ -0,0 +1,364 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// ErrDeclined is returned for tool calls that were not approved.
+var ErrDeclined = errors.New("the user declined to run this command")
+
+// ApprovalRequest describes a tool call that needs the user's consent before it runs.
+type ApprovalRequest struct {
+	Call    gollm.FunctionCall
+	Command string
+	// Target is the kube context or gcloud project the call acts on.
+	Target string
+	// Protection of the target. With ConfirmProtection the approver must
+	// have the user type the target's name to approve.
+	Protection Protection
+	// Preview is the diff of what the call would change, if it can be previewed.
+	Preview string
+
+	ctx context.Context
+}
+
+// Context returns the context of the call, which is cancelled when the
+// approval is no longer needed, e.g. because the turn was stopped.
+func (r ApprovalRequest) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// ApprovalFunc decides whether a tool call may run. It may block while the user decides.
+type ApprovalFunc func(req ApprovalRequest) bool
+
+// Tool is a function the model can call in addition to the built-in kubectl and gcloud tools.
+type Tool struct {
+	Definition *gollm.FunctionDefinition
+	// ReadOnly tools run without asking for approval.
+	ReadOnly bool
+	Run      func(ctx context.Context, args map[string]any) (string, error)
+}
+
+// Executor runs the tools offered to the model: kubectl, gcloud and every
+// registered Tool. Conversations and the MCP server share it, so a tool
+// call is subject to the same policy no matter who asked for it.
+type Executor struct {
+	// Approve, when set, is consulted before running any tool call that is not read-only.
+	// A nil Approve runs every tool call.
+	Approve ApprovalFunc
+	// Cluster pins the kube context and namespace of every kubectl command.
+	Cluster ClusterContext
+	// Rules protect kube contexts and gcloud projects against mutating commands.
+	Rules []ContextRule
+	// Audit, when set, records every tool call and its outcome.
+	Audit *AuditLog
+	// Session identifies the conversation in the audit log.
+	Session string
+	// Redactor masks secrets in tool output before it leaves the executor.
+	Redactor *Redactor
+	// BlockTokenCommands refuses commands that print access tokens or credentials.
+	BlockTokenCommands bool
+	// NewKubeAPI connects the k8s_get and k8s_list tools to a kube context.
+	// It defaults to NewKubeAPI.
+	NewKubeAPI func(ClusterContext) (*KubeAPI, error)
+	// WatchSource feeds the /watch command. It defaults to watching
+	// through the Kubernetes API.
+	WatchSource WatchSource
+	// Workspace is the directory write_manifest saves manifests in. It
+	// defaults to a directory for the session under the system's temp directory.
+	Workspace string
+	// WorkDir is the directory read_file, list_dir and grep are confined to.
+	// It defaults to the working directory.
+	WorkDir string
+	// ArtifactThreshold is the size in bytes above which Offload stores
+	// tool output as an artifact. It defaults to DefaultArtifactThreshold.
+	ArtifactThreshold int
+
+	definitions []*gollm.FunctionDefinition
+	tools       map[string]Tool
+	// kubeAPIs are the connections made by NewKubeAPI by kube context.
+	kubeAPIs map[string]*KubeAPI
+	kubeMu   sync.Mutex
+	// artifacts are the outputs stored by Offload, see artifact.go.
+	artifacts  []Artifact
+	artifactMu sync.Mutex
+}
+
+// NewExecutor creates an executor offering the built-in kubectl, gcloud,
+// k8s_get, k8s_list, write_manifest, read_file, list_dir, grep and
+// read_artifact tools.
+func NewExecutor() *Executor {
+	redactor, _ := NewRedactor(nil)
+	e := &Executor{
+		Redactor: redactor,
+		tools:    map[string]Tool{},
+		definitions: []*gollm.FunctionDefinition{
+			{
+				Name:        "gcloud",
+				Description: "Execute a gcloud command with current credentials and project.",
+				Parameters: &gollm.Schema{
+					Type: gollm.TypeObject,
+					Properties: map[string]*gollm.Schema{
+						"command": {
+							Type:        gollm.TypeString,
+							Description: "The gcloud command to execute.",
+						},
+					},
+					Required: []string{"command"},
+				},
+			},
+			{
+				Name:        "kubectl",
+				Description: "Execute a kubectl command with current credentials and context.",
+				Parameters: &gollm.Schema{
+					Type: gollm.TypeObject,
+					Properties: map[string]*gollm.Schema{
+						"command": {
+							Type:        gollm.TypeString,
+							Description: "The kubectl command to execute.",
+						},
+					},
+					Required: []string{"command"},
+				},
+			},
+		},
+	}
+	e.definitions = append(e.definitions, k8sDefinitions()...)
+	e.definitions = append(e.definitions, writeManifestDefinition())
+	e.definitions = append(e.definitions, localFileDefinitions()...)
+	e.definitions = append(e.definitions, readArtifactDefinition())
+	return e
+}
+
+// Definitions returns the function definitions of all tools, built-in ones first.
+func (e *Executor) Definitions() []*gollm.FunctionDefinition {
+	return e.definitions
+}
+
+// RegisterTool adds a tool. Tool names must be unique, including against the built-in tools.
+func (e *Executor) RegisterTool(tool Tool) error {
+	name := tool.Definition.Name
+	for _, def := range e.definitions {
+		if def.Name == name {
+			return fmt.Errorf("tool %q is already registered", name)
+		}
+	}
+
+	e.tools[name] = tool
+	e.definitions = append(e.definitions, tool.Definition)
+	return nil
+}
+
+// IsReadOnly reports whether a tool call may run without approval.
+// write_manifest counts as read-only as it only writes to the workspace,
+// applying the manifest needs approval.
+func (e *Executor) IsReadOnly(fnCall gollm.FunctionCall) bool {
+	switch fnCall.Name {
+	case k8sGetTool, k8sListTool, writeManifestTool, readFileTool, listDirTool, grepTool, readArtifactTool:
+		return true
+	}
+	return IsReadOnlyCall(fnCall) || e.tools[fnCall.Name].ReadOnly
+}
+
+// check applies the approval policy to a tool call and returns the decision
+// for the audit log. Commands printing credentials are refused when those are
+// blocked, and so are Secrets printed in a format that cannot be redacted.
+// Read-only calls always run, mutating calls against
+// read-only contexts never do, and everything else needs the ApprovalFunc's
+// consent. Without an ApprovalFunc only calls against contexts that need a
+// typed confirmation are declined. The preview shown for approval is returned
+// as well.
+func (e *Executor) check(ctx context.Context, fnCall gollm.FunctionCall) (string, string, error) {
+	command, _ := fnCall.Arguments["command"].(string)
+	if e.BlockTokenCommands && IsTokenCommand(fnCall.Name, command) {
+		return DecisionBlocked, "", ErrBlocked
+	}
+	if format, ok := IsUnredactableCommand(fnCall.Name, command); ok {
+		return DecisionBlocked, "", fmt.Errorf("%w: Secret values printed with -o %s cannot be redacted, use -o yaml or -o json", ErrBlocked, format)
+	}
+	if e.IsReadOnly(fnCall) {
+		return DecisionReadOnly, "", nil
+	}
+
+	target := e.Target(fnCall)
+	protection := ProtectionFor(e.Rules, target)
+	reason := target + " is read-only"
+	if flag, ok := targetFlag(fnCall); ok {
+		// the call may act on any cluster or project, so it is guarded like
+		// the most protected one
+		protection = max(protection, strictestProtection(e.Rules))
+		reason = fmt.Sprintf("what %s points at may be read-only", flag)
+	}
+	if protection == ReadOnlyProtection {
+		return DecisionProtected, "", fmt.Errorf("%w: %s", ErrProtected, reason)
+	}
+	if e.Approve == nil {
+		if protection == ConfirmProtection {
+			return DecisionDeclined, "", ErrDeclined
+		}
+		return DecisionUnchecked, "", nil
+	}
+
+	preview, err := e.Preview(ctx, fnCall)
+	if err != nil {
+		// the user decides without the preview
+		preview = fmt.Sprintf("Could not preview the change: %v", err)
+	}
+	approved := e.Approve(ApprovalRequest{
+		Call:       fnCall,
+		Command:    command,
+		Target:     target,
+		Protection: protection,
+		Preview:    preview,
+		ctx:        ctx,
+	})
+	if !approved {
+		return DecisionDeclined, preview, ErrDeclined
+	}
+	return DecisionApproved, preview, nil
+}
+
+// Call runs a tool call, asking for approval first unless it is read-only.
+func (e *Executor) Call(ctx context.Context, fnCall gollm.FunctionCall) (string, error) {
+	output, _, err := e.CallWithPreview(ctx, fnCall)
+	return output, err
+}
+
+// CallWithPreview is Call that also returns the preview of the change shown
+// for approval, if there was one, with its secrets masked.
+func (e *Executor) CallWithPreview(ctx context.Context, fnCall gollm.FunctionCall) (string, string, error) {
+	decision, preview, err := e.check(ctx, fnCall)
+	preview, _ = e.Redact(preview)
+	if err != nil {
+		return "", preview, e.audit(fnCall, decision, time.Now(), "", err)
+	}
+	output, err := e.run(ctx, fnCall, decision)
+	return output, preview, err
+}
+
+// run executes a tool call and records it in the audit log.
+func (e *Executor) run(ctx context.Context, fnCall gollm.FunctionCall, decision string) (string, error) {
+	start := time.Now()
+	output, err := e.execute(ctx, fnCall)
+	return output, e.audit(fnCall, decision, start, output, err)
+}
+
+// audit records a tool call, whether it ran or not, and passes err through.
+// Failing to write the record is reported along with err.
+func (e *Executor) audit(fnCall gollm.FunctionCall, decision string, start time.Time, output string, err error) error {
+	if e.Audit == nil {
+		return err
+	}
+
+	record := AuditRecord{
+		Time:       start,
+		Session:    e.Session,
+		Tool:       fnCall.Name,
+		Argv:       e.argv(fnCall),
+		Decision:   decision,
+		ExitCode:   exitCode(err),
+		DurationMS: time.Since(start).Milliseconds(),
+		OutputHash: outputHash(output),
+	}
+	if fnCall.Name == "kubectl" || fnCall.Name == k8sGetTool || fnCall.Name == k8sListTool {
+		record.KubeContext = e.Target(fnCall)
+	}
+	if auditErr := e.Audit.Append(record); auditErr != nil {
+		return errors.Join(err, fmt.Errorf("writing audit log: %w", auditErr))
+	}
+	return err
+}
+
+// Redact masks secrets in a tool's output and reports what was removed.
+func (e *Executor) Redact(output string) (string, []Redaction) {
+	if e.Redactor == nil {
+		return output, nil
+	}
+	return e.Redactor.Redact(output)
+}
+
+// argv returns the command line a tool call runs: the pinned arguments for
+// kubectl, the arguments for gcloud and the JSON arguments for other tools.
+func (e *Executor) argv(fnCall gollm.FunctionCall) []string {
+	command, _ := fnCall.Arguments["command"].(string)
+	switch fnCall.Name {
+	case "kubectl":
+		args := strings.Fields(strings.TrimPrefix(command, "kubectl "))
+		return append([]string{"kubectl"}, e.Cluster.PinKubectlArgs(args)...)
+	case "gcloud":
+		return append([]string{"gcloud"}, strings.Fields(strings.TrimPrefix(command, "gcloud "))...)
+	default:
+		args, _ := json.Marshal(fnCall.Arguments)
+		return []string{fnCall.Name, string(args)}
+	}
+}
+
+// execute runs a tool call.
+func (e *Executor) execute(ctx context.Context, fnCall gollm.FunctionCall) (string, error) {
+	// Execute the function call based on its name
+	switch fnCall.Name {
+	case "gcloud":
+		command, ok := fnCall.Arguments["command"].(string)
+		if !ok {
+			return "", errors.New("invalid arguments for gcloud function call")
+		}
+		return ExecuteGcloudCommandContext(ctx, command)
+
+	case "kubectl":
+		command, ok := fnCall.Arguments["command"].(string)
+		if !ok {
+			return "", errors.New("invalid arguments for kubectl function call")
+		}
+		return ExecuteKubectlCommandIn(ctx, e.Cluster, command)
+
+	case k8sGetTool, k8sListTool:
+		return e.k8sCall(ctx, fnCall)
+
+	case writeManifestTool:
+		return e.writeManifest(fnCall)
+
+	case readFileTool:
+		return e.readFile(fnCall)
+
+	case listDirTool:
+		return e.listDir(fnCall)
+
+	case grepTool:
+		return e.grep(fnCall)
+
+	case readArtifactTool:
+		return e.readArtifact(fnCall)
+
+	default:
+		tool, ok := e.tools[fnCall.Name]
+		if !ok {
+			return "", fmt.Errorf("unknown function call: %s", fnCall.Name)
+		}
+		return tool.Run(ctx, fnCall.Arguments)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Export is the JSON form of an exported conversation.
+type Export struct {
+	Model    string    `json:"model"`
+	Exported time.Time `json:"exported"`
+	Blocks   []Block   `json:"blocks"`
+	Usage    Usage     `json:"usage"`
+	// Artifacts are the large tool outputs the model paged through.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// export collects what an export contains.
+func (h *History) export() Export {
+	_, session := h.Usage()
+	return Export{
+		Model:     h.Model,
+		Exported:  time.Now(),
+		Blocks:    h.Snapshot(),
+		Usage:     session,
+		Artifacts: h.Artifacts(),
+	}
+}
+
+// ExportJSON writes the conversation and its token usage as JSON.
+func (h *History) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(h.export())
+}
+
+// ExportMarkdown writes the conversation and its token usage as a Markdown document.
+func (h *History) ExportMarkdown(w io.Writer) error {
+	export := h.export()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# BubbleChat conversation\n\nModel: %s, exported %s\n\n", export.Model, export.Exported.Format(time.RFC1123))
+	for _, block := range export.Blocks {
+		switch block.Type {
+		case UserBlock:
+			fmt.Fprintf(&sb, "## %s\n\n", block.Text)
+		case ToolBlock, AttachmentBlock, CheckBlock:
+			fmt.Fprintf(&sb, "> %s\n\n", strings.ReplaceAll(block.Text, "\n", "\n> "))
+		case ManifestBlock:
+			fmt.Fprintf(&sb, "```yaml\n%s\n```\n\n", block.Text)
+		case ErrorBlock:
+			fmt.Fprintf(&sb, "> **Error:** %s\n\n", strings.ReplaceAll(block.Text, "\n", "\n> "))
+		case WatchBlock:
+			fmt.Fprintf(&sb, "> **Watch:** %s\n\n", strings.ReplaceAll(block.Text, "\n", "\n> "))
+		default:
+			fmt.Fprintf(&sb, "%s\n\n", block.Text)
+		}
+	}
+
+	usage := export.Usage
+	fmt.Fprintf(&sb, "---\n\nUsage: %d prompt tokens, %d completion tokens, %d in total", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+	if usage.Cost > 0 {
+		fmt.Fprintf(&sb, ", about $%.4f", usage.Cost)
+	}
+	sb.WriteString(".\n")
+
+	for _, artifact := range export.Artifacts {
+		fmt.Fprintf(&sb, "\n## Artifact %s\n\n%s", artifact.ID, artifact.Tool)
+		if artifact.Command != "" {
+			fmt.Fprintf(&sb, " `%s`", artifact.Command)
+		}
+		// the fence must be longer than any run of backticks in the output
+		fence := "```"
+		for strings.Contains(artifact.Content, fence) {
+			fence += "`"
+		}
+		fmt.Fprintf(&sb, ", %d bytes\n\n%stext\n%s\n%s\n", len(artifact.Content), fence, strings.TrimSuffix(artifact.Content, "\n"), fence)
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// The fake LLM below replays scripted responses so that tests can drive
+// ChatLoop without network access or an API key. The gollm interfaces are
+// embedded so the fakes only implement the methods bubblechat uses.
+
+// fakeClient is a gollm.Client whose chats are always the same fakeChat.
+type fakeClient struct {
+	gollm.Client
+	chat *fakeChat
+}
+
+func (c *fakeClient) StartChat(systemPrompt, model string) gollm.Chat {
+	c.chat.systemPrompt = systemPrompt
+	return c.chat
+}
+
+// fakeTurn is one scripted reply of the fake LLM.
+type fakeTurn struct {
+	resp gollm.ChatResponse
+	err  error
+}
+
+// fakeChat replays its turns in order and records everything it is sent.
+// Once the script runs out it answers with an empty text response.
+type fakeChat struct {
+	gollm.Chat
+
+	mu           sync.Mutex
+	systemPrompt string
+	turns        []fakeTurn
+	sent         [][]any
+	defs         []*gollm.FunctionDefinition
+	// retryable makes the errors of the turns retryable.
+	retryable bool
+}
+
+func (c *fakeChat) Send(ctx context.Context, contents ...any) (gollm.ChatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, contents)
+	if len(c.turns) == 0 {
+		return textResponse(""), nil
+	}
+	turn := c.turns[0]
+	c.turns = c.turns[1:]
+	return turn.resp, turn.err
+}
+
+func (c *fakeChat) SetFunctionDefinitions(defs []*gollm.FunctionDefinition) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defs = defs
+	return nil
+}
+
+func (c *fakeChat) IsRetryableError(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retryable
+}
+
+// Sent returns a copy of everything the chat was sent so far.
+func (c *fakeChat) Sent() [][]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]any(nil), c.sent...)
+}
+
+type fakeResponse struct {
+	gollm.ChatResponse
+	parts []gollm.Part
+	usage any
+}
+
+func (r *fakeResponse) Candidates() []gollm.Candidate {
+	return []gollm.Candidate{&fakeCandidate{parts: r.parts}}
+}
+
+func (r *fakeResponse) UsageMetadata() any {
+	return r.usage
+}
+
+type fakeCandidate struct {
+	gollm.Candidate
+	parts []gollm.Part
+}
+
+func (c *fakeCandidate) Parts() []gollm.Part {
+	return c.parts
+}
+
+type fakePart struct {
+	text  string
+	calls []gollm.FunctionCall
+}
+
+func (p *fakePart) AsText() (string, bool) {
+	return p.text, p.calls == nil
+}
+
+func (p *fakePart) AsFunctionCalls() ([]gollm.FunctionCall, bool) {
+	return p.calls, p.calls != nil
+}
+
+// textResponse is a model reply made of a single text part.
+func textResponse(text string) *fakeResponse {
+	return &fakeResponse{parts: []gollm.Part{&fakePart{text: text}}}
+}
+
+// callResponse is a model reply asking for the given tool calls.
+func callResponse(calls ...gollm.FunctionCall) *fakeResponse {
+	return &fakeResponse{parts: []gollm.Part{&fakePart{calls: calls}}}
+}
+
+// toolCall builds a kubectl or gcloud function call with the given command.
+func toolCall(id, tool, command string) gollm.FunctionCall {
+	return gollm.FunctionCall{
+		ID:        id,
+		Name:      tool,
+		Arguments: map[string]any{"command": command},
+	}
+}
+
+// newFakeClient returns a client whose chat replays the given responses.
+func newFakeClient(responses ...gollm.ChatResponse) (*fakeClient, *fakeChat) {
+	chat := &fakeChat{}
+	for _, resp := range responses {
+		chat.turns = append(chat.turns, fakeTurn{resp: resp})
+	}
+	return &fakeClient{chat: chat}, chat
+}
+
+// newFakeHistory returns a History backed by the fake LLM.
+func newFakeHistory(t *testing.T, responses ...gollm.ChatResponse) (*History, *fakeChat) {
+	t.Helper()
+	client, chat := newFakeClient(responses...)
+	return NewHistory(t.Context(), client, "fake-model"), chat
+}
@@ -15,15 +15,23 @@
 package internal
 
 import (
+	"context"
 	"os/exec"
 	"strings"
 )
 
 // ExecuteGcloudCommand executes a gcloud command and returns the output or an error.
 func ExecuteGcloudCommand(command string) (string, error) {
+	return ExecuteGcloudCommandContext(context.Background(), command)
+}
+
+// ExecuteGcloudCommandContext is ExecuteGcloudCommand that kills gcloud when
+// ctx is cancelled.
+func ExecuteGcloudCommandContext(ctx context.Context, command string) (string, error) {
 	// remove gcloud prefix if it exists
 	command = strings.TrimPrefix(command, "gcloud ")
-	cmd := exec.Command("gcloud", strings.Fields(command)...)
+	cmd := exec.CommandContext(ctx, "gcloud", strings.Fields(command)...)
+	cmd.WaitDelay = commandWaitDelay
 	output, err := cmd.CombinedOutput()
 	return string(output), err
 }
@@ -0,0 +1,174 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreRule is one pattern of a .gitignore file.
+type ignoreRule struct {
+	// base is the directory of the .gitignore file, relative to the root
+	// and slash-separated, "" for the root itself.
+	base    string
+	pattern *regexp.Regexp
+	// anchored patterns contain a slash and match the path below base,
+	// others match the name at any depth.
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// gitignore holds the rules of the .gitignore files from the root down to
+// a directory. Later rules override earlier ones, like in git.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+// withDir returns the rules extended by the .gitignore file of dir, which
+// is relative to root and slash-separated.
+func (g gitignore) withDir(root, dir string) gitignore {
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"))
+	if err != nil {
+		return g
+	}
+
+	rules := append([]ignoreRule(nil), g.rules...)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimRight(line, " ")
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		re, err := regexp.Compile(globRegexp(line))
+		if err != nil {
+			continue
+		}
+		rule.pattern = re
+		rules = append(rules, rule)
+	}
+	return gitignore{rules: rules}
+}
+
+// ignored reports whether the slash-separated path rel, relative to the
+// root, is ignored by the rules.
+func (g gitignore) ignored(rel string, isDir bool) bool {
+	if isGitDir(path.Base(rel)) {
+		return true
+	}
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if rule.base != "" {
+			var ok bool
+			if sub, ok = strings.CutPrefix(rel, rule.base+"/"); !ok {
+				continue
+			}
+		}
+		if !rule.anchored {
+			sub = path.Base(sub)
+		}
+		if rule.pattern.MatchString(sub) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// isGitDir reports whether a file name is that of git's own directory,
+// whatever its case, as case-insensitive file systems allow.
+func isGitDir(name string) bool {
+	return strings.EqualFold(name, ".git")
+}
+
+// gitignoreFor collects the rules that apply to the slash-separated path
+// rel and reports whether it or one of its parent directories is ignored.
+func gitignoreFor(root, rel string, isDir bool) (gitignore, bool) {
+	g := gitignore{}.withDir(root, "")
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		sub := strings.Join(parts[:i+1], "/")
+		last := i == len(parts)-1
+		if g.ignored(sub, isDir || !last) {
+			return g, true
+		}
+		if !last {
+			g = g.withDir(root, sub)
+		}
+	}
+	return g, false
+}
+
+// globRegexp translates a gitignore glob to an anchored regular expression.
+// * and ? do not match slashes, ** matches any number of directories.
+func globRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
@@ -0,0 +1,60 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGitignore matches paths against nested .gitignore files.
+func TestGitignore(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "charts", "web"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".gitignore"),
+		[]byte("# build output\n*.tgz\n!keep.tgz\nbuild/\n/secrets.yaml\ndocs/**/*.pdf\nfile[0-9].txt\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "charts", ".gitignore"), []byte("values-*.yaml\n"), 0o644))
+
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{rel: "chart.tgz", want: true},
+		{rel: "charts/web/chart.tgz", want: true},
+		{rel: "keep.tgz", want: false},
+		{rel: "build", isDir: true, want: true},
+		{rel: "build", want: false},
+		{rel: "build/out.yaml", want: true},
+		{rel: "secrets.yaml", want: true},
+		{rel: "charts/secrets.yaml", want: false},
+		{rel: "docs/a/b/guide.pdf", want: true},
+		{rel: "docs/guide.pdf", want: true},
+		{rel: "file1.txt", want: true},
+		{rel: "fileA.txt", want: false},
+		{rel: "charts/web/values-prod.yaml", want: true},
+		{rel: "values-prod.yaml", want: false},
+		{rel: ".git", isDir: true, want: true},
+		{rel: "charts/web/deployment.yaml", want: false},
+	}
+	for _, tt := range tests {
+		_, got := gitignoreFor(root, tt.rel, tt.isDir)
+		assert.Equal(t, tt.want, got, tt.rel)
+	}
+}
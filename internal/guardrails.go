@@ -0,0 +1,166 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// ErrProtected is returned for mutating tool calls against a read-only context.
+var ErrProtected = errors.New("the context is protected")
+
+// Protection is how strongly a kube context or gcloud project is guarded against changes.
+type Protection int
+
+const (
+	// Unprotected contexts run mutating commands after the usual approval.
+	Unprotected Protection = iota
+	// ConfirmProtection requires typing the context name to approve a mutating command.
+	ConfirmProtection
+	// ReadOnlyProtection refuses every mutating command.
+	ReadOnlyProtection
+)
+
+// String returns the name of the protection level as used in the configuration.
+func (p Protection) String() string {
+	switch p {
+	case ConfirmProtection:
+		return "confirm"
+	case ReadOnlyProtection:
+		return "read-only"
+	default:
+		return "none"
+	}
+}
+
+// MarshalText encodes the protection level by name.
+func (p Protection) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText parses a protection level name from the configuration.
+func (p *Protection) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "none", "":
+		*p = Unprotected
+	case "confirm":
+		*p = ConfirmProtection
+	case "read-only":
+		*p = ReadOnlyProtection
+	default:
+		return fmt.Errorf("unknown protection %q, expected none, confirm or read-only", text)
+	}
+	return nil
+}
+
+// ContextRule protects the kube contexts and gcloud projects whose names
+// match a glob pattern, where * matches any run of characters.
+type ContextRule struct {
+	Match      string     `yaml:"match"`
+	Protection Protection `yaml:"protection"`
+}
+
+// matches reports whether the rule's pattern matches name.
+func (r ContextRule) matches(name string) bool {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, part := range strings.Split(r.Match, "*") {
+		sb.WriteString(regexp.QuoteMeta(part))
+		sb.WriteString(".*")
+	}
+	pattern := strings.TrimSuffix(sb.String(), ".*") + "$"
+	ok, _ := regexp.MatchString(pattern, name)
+	return ok
+}
+
+// ProtectionFor returns the strictest protection of all rules matching name.
+func ProtectionFor(rules []ContextRule, name string) Protection {
+	result := Unprotected
+	if name == "" {
+		return result
+	}
+	for _, rule := range rules {
+		if rule.matches(name) && rule.Protection > result {
+			result = rule.Protection
+		}
+	}
+	return result
+}
+
+// strictestProtection returns the strictest protection of all rules.
+func strictestProtection(rules []ContextRule) Protection {
+	result := Unprotected
+	for _, rule := range rules {
+		result = max(result, rule.Protection)
+	}
+	return result
+}
+
+// targetFlags are the flags that point kubectl or gcloud at a cluster,
+// credentials or configuration of their own, past the kube context or project
+// that Target reports.
+var targetFlags = map[string][]string{
+	"kubectl": {"--kubeconfig", "--cluster", "-s", "--server", "--user", "--token"},
+	"gcloud":  {"--configuration"},
+}
+
+// targetFlag returns the flag of a tool call that chooses its target past the
+// kube context or project, if there is one. What such a call acts on cannot be
+// told.
+func targetFlag(fnCall gollm.FunctionCall) (string, bool) {
+	command, _ := fnCall.Arguments["command"].(string)
+	args := strings.Fields(strings.TrimPrefix(command, fnCall.Name+" "))
+	if i := slices.Index(args, "--"); i >= 0 {
+		args = args[:i]
+	}
+	for _, flag := range targetFlags[fnCall.Name] {
+		if _, ok := flagValue(args, flag); ok {
+			return flag, true
+		}
+	}
+	return "", false
+}
+
+// Target returns what a tool call acts on: the kube context for kubectl,
+// k8s_get and k8s_list and the project for gcloud. Other tools have no target.
+func (e *Executor) Target(fnCall gollm.FunctionCall) string {
+	command, _ := fnCall.Arguments["command"].(string)
+	args := strings.Fields(strings.TrimPrefix(command, fnCall.Name+" "))
+	switch fnCall.Name {
+	case "kubectl":
+		return e.Cluster.EffectiveKubeContext(args)
+	case k8sGetTool, k8sListTool:
+		return e.Cluster.KubeContext
+	case "gcloud":
+		if project, ok := flagValue(args, "--project"); ok {
+			return project
+		}
+		return e.Cluster.Project
+	default:
+		return ""
+	}
+}
+
+// Protection returns the protection level of the current kube context,
+// which is shown in the status bar.
+func (e *Executor) Protection() Protection {
+	return ProtectionFor(e.Rules, e.Cluster.KubeContext)
+}
@@ -0,0 +1,166 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRules = []ContextRule{
+	{Match: "*prod*", Protection: ReadOnlyProtection},
+	{Match: "gke_*_staging", Protection: ConfirmProtection},
+	{Match: "*-prod-eu", Protection: ConfirmProtection},
+}
+
+// TestProtectionFor checks glob matching and that the strictest rule wins.
+func TestProtectionFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		protection Protection
+	}{
+		{"gke_acme_prod", ReadOnlyProtection},
+		{"acme-prod-eu", ReadOnlyProtection},
+		{"gke_acme_staging", ConfirmProtection},
+		{"gke_acme_staging_2", Unprotected},
+		{"kind-dev", Unprotected},
+		{"", Unprotected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.protection, ProtectionFor(testRules, tt.name))
+		})
+	}
+}
+
+// TestLoadContextRules reads protection rules from the config file.
+func TestLoadContextRules(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+contextRules:
+  - match: "*prod*"
+    protection: read-only
+  - match: "*staging*"
+    protection: confirm
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []ContextRule{
+		{Match: "*prod*", Protection: ReadOnlyProtection},
+		{Match: "*staging*", Protection: ConfirmProtection},
+	}, cfg.ContextRules)
+
+	_, err = LoadConfig(writeConfig(t, `
+contextRules:
+  - match: "*prod*"
+    protection: locked
+`))
+	assert.ErrorContains(t, err, "unknown protection")
+}
+
+// TestExecutorGuardrails checks that mutating calls against protected
+// contexts are refused or need the context name to be confirmed.
+func TestExecutorGuardrails(t *testing.T) {
+	executor := NewExecutor()
+	executor.Rules = testRules
+	executor.Cluster = ClusterContext{KubeContext: "gke_acme_prod", Project: "acme-prod-eu"}
+
+	var requests []ApprovalRequest
+	executor.Approve = func(req ApprovalRequest) bool {
+		requests = append(requests, req)
+		return false
+	}
+
+	_, err := executor.Call(t.Context(), toolCall("1", "kubectl", "delete pod api-0"))
+	assert.ErrorIs(t, err, ErrProtected)
+	_, err = executor.Call(t.Context(), toolCall("2", "gcloud", "compute instances delete vm-1"))
+	assert.ErrorIs(t, err, ErrProtected)
+	assert.Empty(t, requests, "read-only contexts are refused without asking")
+
+	_, err = executor.Call(t.Context(), toolCall("3", "kubectl", "--context gke_acme_staging delete pod api-0"))
+	assert.ErrorIs(t, err, ErrDeclined)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "gke_acme_staging", requests[0].Target)
+	assert.Equal(t, ConfirmProtection, requests[0].Protection)
+
+	// without an approver confirm-protected calls cannot be approved
+	executor.Approve = nil
+	_, err = executor.Call(t.Context(), toolCall("4", "kubectl", "--context gke_acme_staging delete pod api-0"))
+	assert.ErrorIs(t, err, ErrDeclined)
+}
+
+// TestExecutorTargetFlags checks that calls pointing kubectl or gcloud past
+// the kube context or project are guarded like the most protected context.
+func TestExecutorTargetFlags(t *testing.T) {
+	executor := NewExecutor()
+	executor.Rules = testRules
+	executor.Cluster = ClusterContext{KubeContext: "kind-dev", Project: "acme-dev"}
+	var requests []ApprovalRequest
+	executor.Approve = func(req ApprovalRequest) bool {
+		requests = append(requests, req)
+		return false
+	}
+
+	for i, command := range []string{
+		"--kubeconfig /home/me/.kube/prod.yaml delete pod api-0",
+		"--cluster=gke_acme_prod delete pod api-0",
+		"-s https://prod.example.com delete pod api-0",
+		"delete pod api-0 --token=abc",
+		"--user prod-admin scale deploy api --replicas=0",
+	} {
+		_, err := executor.Call(t.Context(), toolCall(fmt.Sprint(i), "kubectl", command))
+		assert.ErrorIs(t, err, ErrProtected, command)
+	}
+	_, err := executor.Call(t.Context(), toolCall("5", "gcloud", "compute instances delete vm-1 --configuration prod"))
+	assert.ErrorIs(t, err, ErrProtected)
+	assert.Empty(t, requests)
+
+	// the flag of a command run by kubectl exec is not kubectl's
+	_, err = executor.Call(t.Context(), toolCall("6", "kubectl", "exec api-0 -- curl -s http://localhost"))
+	assert.ErrorIs(t, err, ErrDeclined)
+	assert.Len(t, requests, 1)
+
+	// without a read-only rule the strictest protection is confirm
+	executor.Rules = []ContextRule{{Match: "*staging*", Protection: ConfirmProtection}}
+	_, err = executor.Call(t.Context(), toolCall("7", "kubectl", "--kubeconfig staging.yaml delete pod api-0"))
+	assert.ErrorIs(t, err, ErrDeclined)
+	require.Len(t, requests, 2)
+	assert.Equal(t, ConfirmProtection, requests[1].Protection)
+}
+
+// TestExecuteFunctionCallGuardrails checks that calls run outside a turn are
+// checked like the calls of a turn.
+func TestExecuteFunctionCallGuardrails(t *testing.T) {
+	h, _ := newFakeHistory(t)
+	h.Rules = testRules
+	h.Cluster = ClusterContext{KubeContext: "gke_acme_prod"}
+	_, err := h.ExecuteFunctionCall(toolCall("1", "kubectl", "delete pod api-0"))
+	assert.ErrorIs(t, err, ErrProtected)
+}
+
+// TestExecutorTarget checks what kubectl and gcloud calls act on.
+func TestExecutorTarget(t *testing.T) {
+	executor := NewExecutor()
+	executor.Cluster = ClusterContext{KubeContext: "kind-dev", Project: "acme-dev"}
+
+	assert.Equal(t, "kind-dev", executor.Target(toolCall("1", "kubectl", "get pods")))
+	assert.Equal(t, "prod", executor.Target(toolCall("2", "kubectl", "kubectl --context=prod get pods")))
+	assert.Equal(t, "acme-dev", executor.Target(toolCall("3", "gcloud", "compute instances list")))
+	assert.Equal(t, "acme-prod", executor.Target(toolCall("4", "gcloud", "compute instances list --project acme-prod")))
+	assert.Empty(t, executor.Target(gollm.FunctionCall{Name: "echo"}))
+}
@@ -16,9 +16,12 @@ package internal
 import (
 	"container/list"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"time"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
 )
@@ -37,13 +40,63 @@ const (
 	UserBlock
 	// ToolBlock indicates a message from a tool.
 	ToolBlock
+	// AttachmentBlock indicates a file attached to the user's message.
+	AttachmentBlock
+	// ManifestBlock indicates a manifest the model saved for review. The
+	// first line is a YAML comment with the path of the file.
+	ManifestBlock
+	// WatchBlock indicates a notable change seen by a background watch.
+	WatchBlock
+	// CheckBlock indicates the result of a scheduled check.
+	CheckBlock
 )
 
+// String returns the lower case name of the block type.
+func (t BlockType) String() string {
+	switch t {
+	case ErrorBlock:
+		return "error"
+	case AgentBlock:
+		return "agent"
+	case UserBlock:
+		return "user"
+	case ToolBlock:
+		return "tool"
+	case AttachmentBlock:
+		return "attachment"
+	case ManifestBlock:
+		return "manifest"
+	case WatchBlock:
+		return "watch"
+	case CheckBlock:
+		return "check"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the block type by name so JSON consumers
+// do not depend on the order of the constants.
+func (t BlockType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a block type by name, e.g. when reading an export.
+func (t *BlockType) UnmarshalText(text []byte) error {
+	for _, candidate := range []BlockType{ErrorBlock, AgentBlock, UserBlock, ToolBlock, AttachmentBlock, ManifestBlock, WatchBlock, CheckBlock} {
+		if candidate.String() == string(text) {
+			*t = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown block type %q", text)
+}
+
 // Block represents a single message block in the conversation.
 // It can be a user message, an AI response, an error message, or a tool response.
 type Block struct {
-	Text string
-	Type BlockType
+	Text string    `json:"text"`
+	Type BlockType `json:"type"`
 }
 
 func (b *Block) String() string {
@@ -56,6 +109,14 @@ func (b *Block) String() string {
 		return fmt.Sprintf("User: %s", b.Text)
 	case ToolBlock:
 		return fmt.Sprintf("Tool: %s", b.Text)
+	case AttachmentBlock:
+		return fmt.Sprintf("Attachment: %s", b.Text)
+	case ManifestBlock:
+		return fmt.Sprintf("Manifest: %s", b.Text)
+	case WatchBlock:
+		return fmt.Sprintf("Watch: %s", b.Text)
+	case CheckBlock:
+		return fmt.Sprintf("Check: %s", b.Text)
 	default:
 		return fmt.Sprintf("Unknown Block Type: %s", b.Text)
 	}
@@ -63,103 +124,336 @@ func (b *Block) String() string {
 
 // History represents the conversation history, which is a collection of blocks.
 type History struct {
+	*Executor
 	Blocks  []Block
 	Chat    gollm.Chat
 	Context context.Context
+	// Model is the name of the model the conversation talks to.
+	Model string
+	// Prices are used to estimate the cost of the conversation, in USD per million tokens.
+	Prices map[string]Price
+	// Limits are the context windows of the models, in tokens.
+	Limits map[string]int
+	// CompactAt is the fraction of the context window at which older turns
+	// are compacted. Zero uses defaultCompactAt.
+	CompactAt float64
+	// ToolWorkers is how many read-only tool calls of one step run at the
+	// same time. Zero uses DefaultToolWorkers.
+	ToolWorkers int
+	// Retry is how failed calls to the model are retried. Zero fields use DefaultRetry.
+	Retry RetryConfig
+
+	// OnBlock, when set, is called after every block added to the history.
+	OnBlock func(Block)
+	// OnUsage, when set, is called with the usage so far after every model response.
+	OnUsage func(turn, session Usage)
+	// OnProgress, when set, is called whenever a tool call starts or
+	// finishes, and every second while a failed model call waits for a retry.
+	OnProgress func()
+	// OnTriage, when set, is given the prompt for a problem a watch started
+	// with triage noticed, to be sent to the model when it is free.
+	OnTriage func(prompt string)
+	// OnAlert, when set, is called when a scheduled check flags a problem.
+	OnAlert func(CheckResult)
+
+	// clusterNote tells the model about a context or namespace switch with the next query.
+	clusterNote string
+	// turnUsage and sessionUsage add up the usage of the last turn and of the whole conversation.
+	turnUsage    Usage
+	sessionUsage Usage
+
+	client gollm.Client
+	// transcript and pins are what survives a compaction, see compact.go.
+	transcript    []transcriptEntry
+	turns         int
+	pins          []string
+	contextTokens int
+	// carryOver hands the compacted conversation to the new chat with the next query.
+	carryOver string
+	// observations are the output of commands the user ran, sent with the next query.
+	observations []string
+	// progress are the tool calls of the current step, see toolrun.go.
+	progress []ToolProgress
+	// watches are the running background watches, see watch.go.
+	watches  []*activeWatch
+	watchSeq int
+	// checks are the scheduled checks, see checks.go.
+	checks []*scheduledCheck
+	// retry is the failed model call waiting to be tried again, see retry.go.
+	retry *RetryStatus
+	// cancelTurn stops the running turn, if any.
+	cancelTurn context.CancelFunc
+	mu         sync.Mutex
 }
 
 // NewHistory creates a new conversation history with the given chat client and context.
 func NewHistory(ctx context.Context, client gollm.Client, model string) *History {
+	return newHistory(ctx, client, model, NewExecutor())
+}
+
+// newHistory creates a conversation whose tool calls are run by executor.
+// The executor's cluster context is described to the model in the system prompt.
+func newHistory(ctx context.Context, client gollm.Client, model string, executor *Executor) *History {
 	result := &History{
-		Blocks:  []Block{},
-		Context: ctx,
+		Executor: executor,
+		Blocks:   []Block{},
+		Context:  ctx,
+		Prices:   DefaultPrices,
+		Limits:   DefaultContextLimits,
 	}
 
 	if model == "" {
 		model = "gemini-2.0-flash"
 	}
+	result.Model = model
 
-	llmChat := gollm.NewRetryChat(
-		client.StartChat(systemPrompt, model),
-		gollm.RetryConfig{
-			MaxAttempts:    3,
-			InitialBackoff: 10 * time.Second,
-			MaxBackoff:     60 * time.Second,
-			BackoffFactor:  2,
-			Jitter:         true,
-		},
-	)
-	result.Chat = llmChat
-
-	llmChat.SetFunctionDefinitions([]*gollm.FunctionDefinition{
-		{
-			Name:        "gcloud",
-			Description: "Execute a gcloud command with current credentials and project.",
-			Parameters: &gollm.Schema{
-				Type: gollm.TypeObject,
-				Properties: map[string]*gollm.Schema{
-					"command": {
-						Type:        gollm.TypeString,
-						Description: "The gcloud command to execute.",
-					},
-				},
-				Required: []string{"command"},
-			},
-		},
-		{
-			Name:        "kubectl",
-			Description: "Execute a kubectl command with current credentials and context.",
-			Parameters: &gollm.Schema{
-				Type: gollm.TypeObject,
-				Properties: map[string]*gollm.Schema{
-					"command": {
-						Type:        gollm.TypeString,
-						Description: "The kubectl command to execute.",
-					},
-				},
-				Required: []string{"command"},
-			},
-		},
-	})
+	result.client = client
+	result.Chat = result.startChat(systemPrompt + executor.Cluster.PromptSection())
+	result.Chat.SetFunctionDefinitions(result.Definitions())
 
 	return result
 }
 
+// startChat starts a chat with the conversation's model that retries failed calls.
+func (h *History) startChat(prompt string) gollm.Chat {
+	return &retryChat{Chat: h.client.StartChat(prompt, h.Model), h: h}
+}
+
+// RegisterTool offers an additional tool to the model.
+// Tool names must be unique, including against kubectl and gcloud.
+func (h *History) RegisterTool(tool Tool) error {
+	if err := h.Executor.RegisterTool(tool); err != nil {
+		return err
+	}
+	return h.Chat.SetFunctionDefinitions(h.Definitions())
+}
+
+// SwitchContext pins kubectl commands to another kube context from the kubeconfig.
+// The model is told about the switch with the next query.
+func (h *History) SwitchContext(kubeContext string) error {
+	contexts, err := ListKubeContexts()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(contexts, kubeContext) {
+		return fmt.Errorf("no context %q in the kubeconfig, available: %s", kubeContext, strings.Join(contexts, ", "))
+	}
+
+	h.Cluster.KubeContext = kubeContext
+	h.clusterNote = fmt.Sprintf("The user switched to kube context %s, namespace %s.", h.Cluster.KubeContext, h.Cluster.Namespace)
+	return nil
+}
+
+// SwitchNamespace changes the default namespace of kubectl commands.
+// The model is told about the switch with the next query.
+func (h *History) SwitchNamespace(namespace string) {
+	h.Cluster.Namespace = namespace
+	h.clusterNote = fmt.Sprintf("The user switched to namespace %s in kube context %s.", h.Cluster.Namespace, h.Cluster.KubeContext)
+}
+
+// Clear starts a new conversation with an empty history. The session's
+// usage keeps counting.
+func (h *History) Clear() {
+	chat := h.startChat(systemPrompt + h.Cluster.PromptSection())
+	chat.SetFunctionDefinitions(h.Definitions())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Chat = chat
+	h.Blocks = []Block{}
+	h.transcript = nil
+	h.turns = 0
+	h.pins = nil
+	h.contextTokens = 0
+	h.turnUsage = Usage{}
+	h.carryOver = ""
+	h.clusterNote = ""
+	h.observations = nil
+}
+
+// SwitchModel continues the conversation with another model. The new model
+// is given the conversation so far with the next query.
+func (h *History) SwitchModel(model string) error {
+	if model == "" {
+		return errors.New("no model given")
+	}
+
+	previous := h.Model
+	h.Model = model
+	chat := h.startChat(systemPrompt + h.Cluster.PromptSection())
+	if err := chat.SetFunctionDefinitions(h.Definitions()); err != nil {
+		h.Model = previous
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Chat = chat
+	h.contextTokens = 0
+	if len(h.transcript) > 0 {
+		// the transcript includes the summary of any compaction
+		h.carryOver = strings.TrimSpace(fmt.Sprintf("The conversation so far, with %s:\n\n%s", previous, renderTranscript(h.transcript)))
+		if len(h.pins) > 0 {
+			h.carryOver += "\n\nPinned notes:\n- " + strings.Join(h.pins, "\n- ")
+		}
+	}
+	return nil
+}
+
+// AddBlock appends a block to the history and notifies OnBlock.
 func (h *History) AddBlock(block Block) {
+	h.mu.Lock()
 	h.Blocks = append(h.Blocks, block)
+	h.mu.Unlock()
+
+	if h.OnBlock != nil {
+		h.OnBlock(block)
+	}
 }
 
-func (h *History) ExecuteFunctionCall(fnCall gollm.FunctionCall) (string, error) {
-	// Execute the function call based on its name
-	switch fnCall.Name {
-	case "gcloud":
-		command, ok := fnCall.Arguments["command"].(string)
-		if !ok {
-			return "", errors.New("invalid arguments for gcloud function call")
-		}
-		return ExecuteGcloudCommand(command)
+// Snapshot returns a copy of the blocks that is safe to read while a chat loop is running.
+func (h *History) Snapshot() []Block {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Block(nil), h.Blocks...)
+}
+
+// Usage returns the token usage and cost of the last turn and of the whole conversation.
+func (h *History) Usage() (turn, session Usage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.turnUsage, h.sessionUsage
+}
 
-	case "kubectl":
-		command, ok := fnCall.Arguments["command"].(string)
-		if !ok {
-			return "", errors.New("invalid arguments for kubectl function call")
+// send sends contents to the model, records the exchange in the transcript
+// and accounts for the usage of the response.
+func (h *History) send(ctx context.Context, contents ...any) (gollm.ChatResponse, error) {
+	for _, content := range contents {
+		if result, ok := content.(gollm.FunctionCallResult); ok {
+			h.record("tool", describeResult(result))
 		}
-		return ExecuteKubectlCommand(command)
+	}
+	resp, err := h.Chat.Send(ctx, contents...)
+	if err != nil {
+		return resp, err
+	}
+	h.recordResponse(resp)
+
+	usage := h.account(resp)
+	if usage.TotalTokens > 0 {
+		// the latest exchange is the best measure of the context so far
+		h.mu.Lock()
+		h.contextTokens = usage.TotalTokens
+		h.mu.Unlock()
+	}
+	return resp, nil
+}
 
-	default:
-		return "", fmt.Errorf("unknown function call: %s", fnCall.Name)
+// account adds the usage of a response to the turn and the session.
+func (h *History) account(resp gollm.ChatResponse) Usage {
+	return h.accountTo(resp, true)
+}
+
+// accountTo adds the usage of a response to the session, and to the turn if
+// it is part of the turn.
+func (h *History) accountTo(resp gollm.ChatResponse, turn bool) Usage {
+	usage := usageOf(resp, h.Prices, h.Model)
+	h.mu.Lock()
+	if turn {
+		h.turnUsage = h.turnUsage.Add(usage)
+	}
+	h.sessionUsage = h.sessionUsage.Add(usage)
+	turnUsage, sessionUsage := h.turnUsage, h.sessionUsage
+	h.mu.Unlock()
+
+	if h.OnUsage != nil {
+		h.OnUsage(turnUsage, sessionUsage)
 	}
+	return usage
+}
+
+// describeCall renders a function call for display in a ToolBlock.
+func describeCall(fnCall gollm.FunctionCall) string {
+	if command, ok := fnCall.Arguments["command"].(string); ok {
+		return fmt.Sprintf("Tool: %s, command: %s", fnCall.Name, command)
+	}
+	args, err := json.Marshal(fnCall.Arguments)
+	if err != nil {
+		return fmt.Sprintf("Tool: %s, arguments: %v", fnCall.Name, fnCall.Arguments)
+	}
+	return fmt.Sprintf("Tool: %s, arguments: %s", fnCall.Name, args)
+}
+
+// withPreview adds the diff the user approved or declined to a tool result,
+// so the model can check the change against its intent.
+func withPreview(result map[string]any, preview string) map[string]any {
+	if preview != "" {
+		result["diff"] = preview
+	}
+	return result
+}
+
+// ExecuteFunctionCall runs a tool call requested by the model, subject to
+// the same checks and approval as the calls of a turn.
+func (h *History) ExecuteFunctionCall(fnCall gollm.FunctionCall) (string, error) {
+	return h.Call(h.Context, fnCall)
 }
 
 func (h *History) ChatLoop(query string) {
+	h.chatLoop(query, nil)
+}
+
+// chatLoop runs a turn in which the model may only use the given tools,
+// or all of them when none are given.
+func (h *History) chatLoop(query string, tools []string) {
+
+	if h.shouldCompact() {
+		// a failed compaction is reported and the turn goes on with the full history
+		h.Compact()
+	}
+	if len(tools) > 0 {
+		if err := h.Chat.SetFunctionDefinitions(h.allowedDefinitions(tools)); err != nil {
+			h.AddBlock(Block{Text: fmt.Sprintf("Error: %v", err), Type: ErrorBlock})
+			return
+		}
+		defer h.Chat.SetFunctionDefinitions(h.Definitions())
+	}
+
+	h.mu.Lock()
+	h.turnUsage = Usage{}
+	h.turns++
+	h.mu.Unlock()
+	h.record("user", query)
+
+	if observations := h.takeObservations(); observations != "" {
+		// recorded so that the output survives a compaction
+		h.record("user", observations)
+		query = fmt.Sprintf("[%s]\n\n%s", observations, query)
+	}
+	if h.clusterNote != "" {
+		query = fmt.Sprintf("[%s]\n\n%s", h.clusterNote, query)
+		h.clusterNote = ""
+	}
+	if h.carryOver != "" {
+		query = fmt.Sprintf("[%s]\n\n%s", h.carryOver, query)
+		h.carryOver = ""
+	}
 
 	// Add the user's query to the conversation history
-	resp, err := h.Chat.Send(h.Context, query)
+	ctx, cancel := context.WithCancel(h.Context)
+	h.mu.Lock()
+	h.cancelTurn = cancel
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.cancelTurn = nil
+		h.mu.Unlock()
+		cancel()
+	}()
+
+	resp, err := h.send(ctx, query)
 	if err != nil {
-		h.AddBlock(Block{
-			Text: fmt.Sprintf("Error: %v", err),
-			Type: ErrorBlock,
-		})
+		h.sendFailed(ctx, err)
 		return
 	}
 
@@ -200,7 +494,7 @@ func (h *History) ChatLoop(query string) {
 			if success {
 				for _, fncall := range fncalls {
 					h.AddBlock(Block{
-						Text: fmt.Sprintf("Tool: %s, command: %s", fncall.Name, fncall.Arguments["command"]),
+						Text: describeCall(fncall),
 						Type: ToolBlock,
 					})
 					queue.PushBack(fncall)
@@ -222,38 +516,53 @@ func (h *History) ChatLoop(query string) {
 			}
 		}
 
-		// Process the function calls in the queue
-		for queue.Len() > 0 {
-			element := queue.Front()
-			queue.Remove(element)
-			fnCall := element.Value.(gollm.FunctionCall)
-
-			// Execute the function call
-			result, err := h.ExecuteFunctionCall(fnCall)
-			if err != nil {
-				h.AddBlock(Block{
-					Text: fmt.Sprintf("Error executing %s: %v", fnCall.Name, err),
-					Type: ErrorBlock,
-				})
-				continue
-			}
-
-			fnResult := gollm.FunctionCallResult{
-				ID:     fnCall.ID,
-				Name:   fnCall.Name,
-				Result: map[string]any{"output": result},
-			}
-
-			resp, err = h.Chat.Send(h.Context, fnResult)
-			if err != nil {
-				h.AddBlock(Block{
-					Text: fmt.Sprintf("Error: %v", err),
-					Type: ErrorBlock,
-				})
-				return
-			}
+		// Run the function calls and send their results together
+		var calls []gollm.FunctionCall
+		for element := queue.Front(); element != nil; element = element.Next() {
+			calls = append(calls, element.Value.(gollm.FunctionCall))
+		}
+		results := h.runCalls(ctx, calls, tools)
+		if ctx.Err() != nil {
+			h.sendFailed(ctx, ctx.Err())
+			return
 		}
+		if len(results) == 0 {
+			return
+		}
+		contents := make([]any, len(results))
+		for i, result := range results {
+			contents[i] = result
+		}
+		resp, err = h.send(ctx, contents...)
+		if err != nil {
+			h.sendFailed(ctx, err)
+			return
+		}
+	}
 
+}
+
+// sendFailed reports why the turn ended without an answer from the model.
+func (h *History) sendFailed(ctx context.Context, err error) {
+	if ctx.Err() != nil && h.Context.Err() == nil {
+		h.AddBlock(Block{Text: "Stopped the turn.", Type: ErrorBlock})
+		return
 	}
+	h.AddBlock(Block{
+		Text: fmt.Sprintf("Error: %v", err),
+		Type: ErrorBlock,
+	})
+}
 
+// CancelTurn stops the running turn, also while it waits for a retry or an
+// approval. Tool calls already running are stopped and the others are not
+// started. It returns false when no turn is running.
+func (h *History) CancelTurn() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancelTurn == nil {
+		return false
+	}
+	h.cancelTurn()
+	return true
 }
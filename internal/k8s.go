@@ -0,0 +1,284 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Names of the tools reading the Kubernetes API directly.
+const (
+	k8sGetTool  = "k8s_get"
+	k8sListTool = "k8s_list"
+)
+
+// KubeAPI is what the k8s_get and k8s_list tools need from a cluster.
+type KubeAPI struct {
+	Dynamic dynamic.Interface
+	// Mapper finds the resource of a kind and whether it is namespaced.
+	Mapper meta.RESTMapper
+}
+
+// NewKubeAPI connects to the kube context of cluster using the kubeconfig,
+// the same way kubectl does.
+func NewKubeAPI(cluster ClusterContext) (*KubeAPI, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.KubeContext}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	disc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KubeAPI{
+		Dynamic: client,
+		Mapper:  restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disc)),
+	}, nil
+}
+
+// kubeAPI returns the connection to the current kube context, reusing it
+// while the context stays the same.
+func (e *Executor) kubeAPI() (*KubeAPI, error) {
+	return e.kubeAPIFor(e.Cluster)
+}
+
+// kubeAPIFor returns the connection to the kube context of cluster, reusing
+// an earlier one.
+func (e *Executor) kubeAPIFor(cluster ClusterContext) (*KubeAPI, error) {
+	e.kubeMu.Lock()
+	defer e.kubeMu.Unlock()
+	if api, ok := e.kubeAPIs[cluster.KubeContext]; ok {
+		return api, nil
+	}
+	newAPI := e.NewKubeAPI
+	if newAPI == nil {
+		newAPI = NewKubeAPI
+	}
+	api, err := newAPI(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to kube context %q: %w", cluster.KubeContext, err)
+	}
+	if e.kubeAPIs == nil {
+		e.kubeAPIs = map[string]*KubeAPI{}
+	}
+	e.kubeAPIs[cluster.KubeContext] = api
+	return api, nil
+}
+
+// k8sDefinitions describe the k8s_get and k8s_list tools.
+func k8sDefinitions() []*gollm.FunctionDefinition {
+	common := func() map[string]*gollm.Schema {
+		return map[string]*gollm.Schema{
+			"group": {
+				Type:        gollm.TypeString,
+				Description: "API group of the kind, empty for the core group, e.g. apps.",
+			},
+			"version": {
+				Type:        gollm.TypeString,
+				Description: "API version, e.g. v1. The preferred version is used when empty.",
+			},
+			"kind": {
+				Type:        gollm.TypeString,
+				Description: "Kind of the objects, e.g. Deployment.",
+			},
+			"namespace": {
+				Type:        gollm.TypeString,
+				Description: "Namespace of the objects, the current namespace when empty. Ignored for cluster-scoped kinds.",
+			},
+			"jsonpath": {
+				Type:        gollm.TypeString,
+				Description: "kubectl-style JSONPath selecting what to return, e.g. {.status.conditions}. The whole object is returned when empty.",
+			},
+		}
+	}
+
+	get := common()
+	get["name"] = &gollm.Schema{
+		Type:        gollm.TypeString,
+		Description: "Name of the object.",
+	}
+	list := common()
+	list["labelSelector"] = &gollm.Schema{
+		Type:        gollm.TypeString,
+		Description: "Label selector, e.g. app=web,tier!=cache.",
+	}
+	list["fieldSelector"] = &gollm.Schema{
+		Type:        gollm.TypeString,
+		Description: "Field selector, e.g. status.phase=Running.",
+	}
+	list["allNamespaces"] = &gollm.Schema{
+		Type:        gollm.TypeBoolean,
+		Description: "List the objects of all namespaces.",
+	}
+
+	return []*gollm.FunctionDefinition{
+		{
+			Name:        k8sGetTool,
+			Description: "Get one Kubernetes object from the API of the current kube context as compact JSON. Prefer it over kubectl get for structured data.",
+			Parameters: &gollm.Schema{
+				Type:       gollm.TypeObject,
+				Properties: get,
+				Required:   []string{"kind", "name"},
+			},
+		},
+		{
+			Name:        k8sListTool,
+			Description: "List Kubernetes objects from the API of the current kube context as compact JSON. Prefer it over kubectl get for structured data.",
+			Parameters: &gollm.Schema{
+				Type:       gollm.TypeObject,
+				Properties: list,
+				Required:   []string{"kind"},
+			},
+		},
+	}
+}
+
+// k8sCall runs a k8s_get or k8s_list call.
+func (e *Executor) k8sCall(ctx context.Context, fnCall gollm.FunctionCall) (string, error) {
+	args := fnCall.Arguments
+	str := func(key string) string {
+		s, _ := args[key].(string)
+		return strings.TrimSpace(s)
+	}
+	kind := str("kind")
+	if kind == "" {
+		return "", errors.New("kind is required")
+	}
+
+	api, err := e.kubeAPI()
+	if err != nil {
+		return "", err
+	}
+	gk := schema.GroupKind{Group: str("group"), Kind: kind}
+	var versions []string
+	if version := str("version"); version != "" {
+		versions = append(versions, version)
+	}
+	mapping, err := api.Mapper.RESTMapping(gk, versions...)
+	if err != nil {
+		return "", err
+	}
+
+	var resource dynamic.ResourceInterface = api.Dynamic.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := str("namespace")
+		if namespace == "" {
+			namespace = e.Cluster.Namespace
+		}
+		allNamespaces, _ := args["allNamespaces"].(bool)
+		if !allNamespaces || fnCall.Name == k8sGetTool {
+			resource = api.Dynamic.Resource(mapping.Resource).Namespace(namespace)
+		}
+	}
+
+	var content map[string]any
+	switch fnCall.Name {
+	case k8sGetTool:
+		name := str("name")
+		if name == "" {
+			return "", errors.New("name is required")
+		}
+		obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		content = compactObject(obj)
+	default:
+		list, err := resource.List(ctx, metav1.ListOptions{
+			LabelSelector: str("labelSelector"),
+			FieldSelector: str("fieldSelector"),
+		})
+		if err != nil {
+			return "", err
+		}
+		items := make([]any, 0, len(list.Items))
+		for i := range list.Items {
+			items = append(items, compactObject(&list.Items[i]))
+		}
+		content = map[string]any{"items": items}
+	}
+
+	if path := str("jsonpath"); path != "" {
+		return projectJSONPath(content, path)
+	}
+	out, err := json.Marshal(content)
+	return string(out), err
+}
+
+// compactObject drops the metadata that only adds noise for the model and
+// masks the values of Secrets, which a JSONPath projection would otherwise
+// return without their kind for the redactor to notice.
+func compactObject(obj *unstructured.Unstructured) map[string]any {
+	if obj.GetKind() == "Secret" && obj.GroupVersionKind().Group == "" {
+		for _, field := range []string{"data", "stringData"} {
+			data, _ := obj.Object[field].(map[string]any)
+			for key := range data {
+				data[key] = redacted
+			}
+		}
+	}
+	obj.SetManagedFields(nil)
+	unstructured.RemoveNestedField(obj.Object, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+	if len(obj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+	return obj.Object
+}
+
+// projectJSONPath selects from content with a kubectl-style JSONPath
+// expression and returns the results as a JSON array.
+func projectJSONPath(content map[string]any, path string) (string, error) {
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+	j := jsonpath.New("projection").AllowMissingKeys(true)
+	if err := j.Parse(path); err != nil {
+		return "", fmt.Errorf("invalid jsonpath %s: %w", path, err)
+	}
+	results, err := j.FindResults(content)
+	if err != nil {
+		return "", err
+	}
+	values := []any{}
+	for _, result := range results {
+		for _, value := range result {
+			values = append(values, value.Interface())
+		}
+	}
+	out, err := json.Marshal(values)
+	return string(out), err
+}
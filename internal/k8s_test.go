@@ -0,0 +1,167 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// fakeObject builds an unstructured object for the fake dynamic client.
+func fakeObject(apiVersion, kind, namespace, name string, labels map[string]string, fields map[string]any) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]any{}}
+	for k, v := range fields {
+		obj.Object[k] = v
+	}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	obj.SetAnnotations(map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"})
+	return obj
+}
+
+// newFakeKubeAPI serves deployments, pods, secrets and nodes from the fake dynamic client.
+func newFakeKubeAPI(ClusterContext) (*KubeAPI, error) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	nodes := schema.GroupVersionResource{Version: "v1", Resource: "nodes"}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}, {Group: "apps", Version: "v1"}})
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Node"}, meta.RESTScopeRoot)
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			deployments: "DeploymentList",
+			pods:        "PodList",
+			secrets:     "SecretList",
+			nodes:       "NodeList",
+		},
+		fakeObject("apps/v1", "Deployment", "web", "frontend", nil, map[string]any{
+			"spec": map[string]any{"replicas": int64(3)},
+		}),
+		fakeObject("v1", "Pod", "web", "frontend-1", map[string]string{"app": "frontend"}, nil),
+		fakeObject("v1", "Pod", "web", "cache-1", map[string]string{"app": "cache"}, nil),
+		fakeObject("v1", "Pod", "payments", "api-1", map[string]string{"app": "api"}, nil),
+		fakeObject("v1", "Secret", "web", "db", nil, map[string]any{
+			"data":       map[string]any{"password": "aHVudGVyMg=="},
+			"stringData": map[string]any{"user": "admin"},
+		}),
+		fakeObject("v1", "Node", "", "node-1", nil, nil),
+	)
+	return &KubeAPI{Dynamic: client, Mapper: mapper}, nil
+}
+
+// k8sCall builds a k8s_get or k8s_list call.
+func k8sCall(tool string, args map[string]any) gollm.FunctionCall {
+	return gollm.FunctionCall{ID: "1", Name: tool, Arguments: args}
+}
+
+// TestK8sTools reads objects through the dynamic client.
+func TestK8sTools(t *testing.T) {
+	executor := NewExecutor()
+	executor.Cluster = ClusterContext{KubeContext: "kind-dev", Namespace: "web"}
+	executor.NewKubeAPI = newFakeKubeAPI
+	// read-only tools never ask
+	executor.Approve = func(ApprovalRequest) bool { return false }
+
+	tests := []struct {
+		name    string
+		call    gollm.FunctionCall
+		want    string
+		wantErr string
+	}{
+		{
+			name: "get in the current namespace",
+			call: k8sCall(k8sGetTool, map[string]any{"group": "apps", "kind": "Deployment", "name": "frontend", "jsonpath": ".spec.replicas"}),
+			want: `[3]`,
+		},
+		{
+			name: "get without noise",
+			call: k8sCall(k8sGetTool, map[string]any{"kind": "Node", "name": "node-1"}),
+			want: `{"apiVersion":"v1","kind":"Node","metadata":{"name":"node-1"}}`,
+		},
+		{
+			name: "list with a label selector",
+			call: k8sCall(k8sListTool, map[string]any{"kind": "Pod", "version": "v1", "labelSelector": "app=frontend", "jsonpath": "{.items[*].metadata.name}"}),
+			want: `["frontend-1"]`,
+		},
+		{
+			name: "list another namespace",
+			call: k8sCall(k8sListTool, map[string]any{"kind": "Pod", "namespace": "payments", "jsonpath": "{.items[*].metadata.name}"}),
+			want: `["api-1"]`,
+		},
+		{
+			name: "list all namespaces",
+			call: k8sCall(k8sListTool, map[string]any{"kind": "Pod", "allNamespaces": true, "jsonpath": "{.items[*].metadata.name}"}),
+			want: `["api-1","cache-1","frontend-1"]`,
+		},
+		{
+			name: "secret data through a jsonpath",
+			call: k8sCall(k8sGetTool, map[string]any{"kind": "Secret", "name": "db", "jsonpath": "{.data}"}),
+			want: `[{"password":"[REDACTED]"}]`,
+		},
+		{
+			name: "listed secrets",
+			call: k8sCall(k8sListTool, map[string]any{"kind": "Secret", "jsonpath": "{.items[*]['data','stringData']}"}),
+			want: `[{"password":"[REDACTED]"},{"user":"[REDACTED]"}]`,
+		},
+		{
+			name:    "missing object",
+			call:    k8sCall(k8sGetTool, map[string]any{"kind": "Pod", "name": "nope"}),
+			wantErr: `pods "nope" not found`,
+		},
+		{
+			name:    "unknown kind",
+			call:    k8sCall(k8sListTool, map[string]any{"kind": "Gadget"}),
+			wantErr: "no matches for",
+		},
+		{
+			name:    "no name",
+			call:    k8sCall(k8sGetTool, map[string]any{"kind": "Pod"}),
+			wantErr: "name is required",
+		},
+		{
+			name:    "bad jsonpath",
+			call:    k8sCall(k8sGetTool, map[string]any{"kind": "Node", "name": "node-1", "jsonpath": "{.metadata"}),
+			wantErr: "invalid jsonpath",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executor.Call(t.Context(), tt.call)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.Equal(t, "kind-dev", executor.Target(k8sCall(k8sListTool, nil)))
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// ClusterContext is the Kubernetes context, namespace and gcloud project
+// that bubblechat works against.
+type ClusterContext struct {
+	KubeContext string
+	Namespace   string
+	Project     string
+}
+
+// DetectClusterContext reads the current kubeconfig context and namespace and the
+// active gcloud project. Values that cannot be determined are left empty.
+func DetectClusterContext() ClusterContext {
+	var c ClusterContext
+	if out, err := exec.Command("kubectl", "config", "current-context").Output(); err == nil {
+		c.KubeContext = strings.TrimSpace(string(out))
+	}
+	if out, err := exec.Command("kubectl", "config", "view", "--minify", "-o", "jsonpath={..namespace}").Output(); err == nil {
+		c.Namespace = strings.TrimSpace(string(out))
+	}
+	if c.Namespace == "" && c.KubeContext != "" {
+		c.Namespace = "default"
+	}
+	if out, err := exec.Command("gcloud", "config", "get-value", "project").Output(); err == nil {
+		project := strings.TrimSpace(string(out))
+		if project != "(unset)" {
+			c.Project = project
+		}
+	}
+	return c
+}
+
+// ListKubeContexts returns the names of all contexts in the kubeconfig.
+func ListKubeContexts() ([]string, error) {
+	out, err := exec.Command("kubectl", "config", "get-contexts", "-o", "name").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing kube contexts: %w", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// String renders the context for the status bar.
+func (c ClusterContext) String() string {
+	kubeContext := c.KubeContext
+	if kubeContext == "" {
+		kubeContext = "no kube context"
+	}
+	parts := []string{"⎈ " + kubeContext}
+	if c.Namespace != "" {
+		parts = append(parts, "ns: "+c.Namespace)
+	}
+	if c.Project != "" {
+		parts = append(parts, "project: "+c.Project)
+	}
+	return strings.Join(parts, "  ")
+}
+
+// PromptSection describes the context for the model.
+func (c ClusterContext) PromptSection() string {
+	var sb strings.Builder
+	sb.WriteString("\n## Environment:\n")
+	if c.KubeContext == "" {
+		sb.WriteString("- No Kubernetes context is configured.\n")
+	} else {
+		fmt.Fprintf(&sb, "- Kubernetes context: %s. Every kubectl command runs against this context.\n", c.KubeContext)
+		fmt.Fprintf(&sb, "- Default namespace: %s.\n", c.Namespace)
+	}
+	if c.Project != "" {
+		fmt.Fprintf(&sb, "- gcloud project: %s.\n", c.Project)
+	}
+	return sb.String()
+}
+
+// flagValue returns the value of a command line flag given as "--flag value",
+// "--flag=value" or, for short flags, "-fvalue", and whether the flag is
+// present at all.
+func flagValue(args []string, names ...string) (string, bool) {
+	for i, arg := range args {
+		for _, name := range names {
+			if len(name) == 2 && name[0] == '-' && len(arg) > 2 && strings.HasPrefix(arg, name) {
+				return strings.TrimPrefix(arg[2:], "="), true
+			}
+			if arg == name {
+				if i+1 < len(args) {
+					return args[i+1], true
+				}
+				return "", true
+			}
+			if value, ok := strings.CutPrefix(arg, name+"="); ok {
+				return value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// EffectiveKubeContext returns the context a kubectl command will run against:
+// an explicit --context flag wins over the pinned context.
+func (c ClusterContext) EffectiveKubeContext(args []string) string {
+	if i := slices.Index(args, "--"); i >= 0 {
+		args = args[:i]
+	}
+	if value, ok := flagValue(args, "--context"); ok {
+		return value
+	}
+	return c.KubeContext
+}
+
+// PinKubectlArgs adds --context and --namespace to kubectl arguments that do
+// not choose them explicitly, so a kubeconfig change made outside of bubblechat
+// cannot redirect the commands to another cluster. Commands reading objects
+// from manifests with -f or -k only get the context, since kubectl refuses a
+// --namespace that differs from the one in the manifest.
+func (c ClusterContext) PinKubectlArgs(args []string) []string {
+	verb, _ := kubectlSubcommand(args)
+	if verb == "config" {
+		return args
+	}
+
+	// everything after "--" belongs to the command run by kubectl exec or run
+	own := args
+	if i := slices.Index(args, "--"); i >= 0 {
+		own = args[:i]
+	}
+
+	var flags []string
+	if _, ok := flagValue(own, "--context"); !ok && c.KubeContext != "" {
+		flags = append(flags, "--context", c.KubeContext)
+	}
+	_, namespaced := flagValue(own, "-n", "--namespace")
+	_, all := flagValue(own, "-A", "--all-namespaces")
+	// for kubectl logs -f is --follow
+	_, manifests := flagValue(own, "-f", "--filename", "-k", "--kustomize")
+	manifests = manifests && verb != "logs"
+	if !namespaced && !all && !manifests && c.Namespace != "" {
+		flags = append(flags, "--namespace", c.Namespace)
+	}
+
+	pinned := append([]string(nil), own...)
+	pinned = append(pinned, flags...)
+	return append(pinned, args[len(own):]...)
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPinKubectlArgs checks that --context and --namespace are only added
+// when the command does not choose them itself.
+func TestPinKubectlArgs(t *testing.T) {
+	cluster := ClusterContext{KubeContext: "staging", Namespace: "payments"}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"get pods", "get pods --context staging --namespace payments"},
+		{"get pods -n kube-system", "get pods -n kube-system --context staging"},
+		{"get pods --namespace=web", "get pods --namespace=web --context staging"},
+		{"get pods -A", "get pods -A --context staging"},
+		{"get pods -nweb", "get pods -nweb --context staging"},
+		{"apply -f web.yaml", "apply -f web.yaml --context staging"},
+		{"delete --filename=web.yaml", "delete --filename=web.yaml --context staging"},
+		{"apply -k overlays/prod", "apply -k overlays/prod --context staging"},
+		{"logs -f api-0", "logs -f api-0 --context staging --namespace payments"},
+		{"--context prod get pods", "--context prod get pods --namespace payments"},
+		{"exec api-0 -- ls -n", "exec api-0 --context staging --namespace payments -- ls -n"},
+		{"config get-contexts", "config get-contexts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got := cluster.PinKubectlArgs(strings.Fields(tt.command))
+			assert.Equal(t, tt.want, strings.Join(got, " "))
+		})
+	}
+
+	assert.Equal(t, []string{"get", "pods"}, ClusterContext{}.PinKubectlArgs([]string{"get", "pods"}))
+}
+
+// TestEffectiveKubeContext checks which context a command targets.
+func TestEffectiveKubeContext(t *testing.T) {
+	cluster := ClusterContext{KubeContext: "staging"}
+	assert.Equal(t, "staging", cluster.EffectiveKubeContext(strings.Fields("get pods")))
+	assert.Equal(t, "prod", cluster.EffectiveKubeContext(strings.Fields("get pods --context=prod")))
+	assert.Equal(t, "staging", cluster.EffectiveKubeContext(strings.Fields("exec x -- tool --context=prod")))
+}
+
+// TestClusterContextPrompt checks that the model learns about the context
+// in the system prompt and about switches with the next query.
+func TestClusterContextPrompt(t *testing.T) {
+	cfg := &Config{Cluster: ClusterContext{KubeContext: "staging", Namespace: "payments", Project: "acme"}}
+	client, chat := newFakeClient(textResponse("ok"), textResponse("ok"))
+	h := cfg.NewHistory(t.Context(), client)
+
+	assert.Contains(t, chat.systemPrompt, "Kubernetes context: staging")
+	assert.Contains(t, chat.systemPrompt, "gcloud project: acme")
+	assert.Equal(t, "⎈ staging  ns: payments  project: acme", h.Cluster.String())
+
+	h.SwitchNamespace("web")
+	h.ChatLoop("list pods")
+	h.ChatLoop("and again")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, "[The user switched to namespace web in kube context staging.]\n\nlist pods", sent[0][0])
+	assert.Equal(t, "and again", sent[1][0])
+}
@@ -15,15 +15,30 @@
 package internal
 
 import (
+	"context"
 	"os/exec"
 	"strings"
+	"time"
 )
 
+// commandWaitDelay is how long a cancelled kubectl or gcloud may take to
+// close its output before it is given up on, e.g. when a credential plugin
+// it started still holds it.
+const commandWaitDelay = time.Second
+
 // ExecuteKubectlCommand executes a kubectl command and returns the output or an error.
 func ExecuteKubectlCommand(command string) (string, error) {
+	return ExecuteKubectlCommandIn(context.Background(), ClusterContext{}, command)
+}
+
+// ExecuteKubectlCommandIn executes a kubectl command pinned to the kube context
+// and namespace of cluster, unless the command chooses them itself. kubectl
+// is killed when ctx is cancelled, e.g. to end a logs -f.
+func ExecuteKubectlCommandIn(ctx context.Context, cluster ClusterContext, command string) (string, error) {
 	// remove kubectl prefix if it exists
 	command = strings.TrimPrefix(command, "kubectl ")
-	cmd := exec.Command("kubectl", strings.Fields(command)...)
+	cmd := exec.CommandContext(ctx, "kubectl", cluster.PinKubectlArgs(strings.Fields(command))...)
+	cmd.WaitDelay = commandWaitDelay
 	output, err := cmd.CombinedOutput()
 	return string(output), err
 }
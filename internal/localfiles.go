@@ -0,0 +1,379 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// Names of the tools reading files in the working directory.
+const (
+	readFileTool = "read_file"
+	listDirTool  = "list_dir"
+	grepTool     = "grep"
+)
+
+// Caps keeping the file tools' output small enough for the model.
+const (
+	// maxReadFileBytes is how much of a file read_file returns.
+	maxReadFileBytes = 64 * 1024
+	// maxListEntries is how many entries list_dir returns.
+	maxListEntries = 500
+	// maxGrepMatches is how many matching lines grep returns.
+	maxGrepMatches = 200
+	// maxGrepFileBytes is the size above which grep skips a file.
+	maxGrepFileBytes = 1024 * 1024
+	// maxGrepLineLength is how much of a matching line grep returns.
+	maxGrepLineLength = 200
+)
+
+// localFileDefinitions describe the read_file, list_dir and grep tools.
+func localFileDefinitions() []*gollm.FunctionDefinition {
+	pathSchema := func(description string) *gollm.Schema {
+		return &gollm.Schema{Type: gollm.TypeString, Description: description}
+	}
+	return []*gollm.FunctionDefinition{
+		{
+			Name:        readFileTool,
+			Description: "Read a text file in the working directory, such as a Helm chart, kustomization or Terraform file, to compare it with the cluster.",
+			Parameters: &gollm.Schema{
+				Type: gollm.TypeObject,
+				Properties: map[string]*gollm.Schema{
+					"path": pathSchema("Path of the file relative to the working directory."),
+				},
+				Required: []string{"path"},
+			},
+		},
+		{
+			Name:        listDirTool,
+			Description: "List a directory in the working directory. Directories end with a slash, files ignored by git are left out.",
+			Parameters: &gollm.Schema{
+				Type: gollm.TypeObject,
+				Properties: map[string]*gollm.Schema{
+					"path":      pathSchema("Path of the directory relative to the working directory, . by default."),
+					"recursive": {Type: gollm.TypeBoolean, Description: "List the subdirectories as well."},
+				},
+			},
+		},
+		{
+			Name:        grepTool,
+			Description: "Search the text files in the working directory for a regular expression. Returns path:line: text for every match.",
+			Parameters: &gollm.Schema{
+				Type: gollm.TypeObject,
+				Properties: map[string]*gollm.Schema{
+					"pattern": pathSchema("Regular expression in Go syntax, e.g. image: .*nginx."),
+					"path":    pathSchema("File or directory to search, . by default."),
+					"glob":    pathSchema("Only search files whose name matches this glob, e.g. *.yaml."),
+				},
+				Required: []string{"pattern"},
+			},
+		},
+	}
+}
+
+// workDir returns the directory the file tools are confined to.
+func (e *Executor) workDir() string {
+	if e.WorkDir != "" {
+		return e.WorkDir
+	}
+	return "."
+}
+
+// localFile is a path given to a file tool, resolved inside the working directory.
+type localFile struct {
+	// path is the resolved path, root the resolved working directory.
+	path string
+	root string
+	// rel is path relative to root, slash-separated.
+	rel  string
+	info fs.FileInfo
+}
+
+// localPath resolves a path given to a file tool. It must be inside the
+// working directory and not ignored by git.
+func (e *Executor) localPath(name string) (localFile, error) {
+	if name == "" {
+		name = "."
+	}
+	root, err := confine(e.workDir(), ".")
+	if err != nil {
+		return localFile{}, err
+	}
+	resolved, err := confine(root, name)
+	if err != nil {
+		return localFile{}, err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return localFile{}, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return localFile{}, err
+	}
+	rel = filepath.ToSlash(rel)
+	// both the path as given and where its links lead must be visible, like
+	// for list_dir and grep, which skip links
+	given := name
+	if !filepath.IsAbs(given) {
+		given = filepath.Join(root, given)
+	}
+	for _, check := range []string{given, resolved} {
+		if err := hiddenFile(root, check, info.IsDir()); err != nil {
+			return localFile{}, fmt.Errorf("%s %w", name, err)
+		}
+	}
+	return localFile{path: resolved, root: root, rel: rel, info: info}, nil
+}
+
+// hiddenFile returns why the file tools may not see path below root: it is
+// in the .git directory or ignored by .gitignore. It returns nil for paths
+// that can be seen.
+func hiddenFile(root, path string, isDir bool) error {
+	rel, err := filepath.Rel(root, filepath.Clean(path))
+	if err != nil || rel == "." || !within(root, filepath.Clean(path)) {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+	if slices.ContainsFunc(strings.Split(rel, "/"), isGitDir) {
+		return errors.New("is inside .git")
+	}
+	if _, ignored := gitignoreFor(root, rel, isDir); ignored {
+		return errors.New("is ignored by .gitignore")
+	}
+	return nil
+}
+
+// readFile runs a read_file call.
+func (e *Executor) readFile(fnCall gollm.FunctionCall) (string, error) {
+	name, _ := fnCall.Arguments["path"].(string)
+	local, err := e.localPath(name)
+	if err != nil {
+		return "", err
+	}
+	if local.info.IsDir() {
+		return "", fmt.Errorf("%s is a directory, use list_dir", name)
+	}
+	file, err := os.Open(local.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info := local.info
+
+	data, err := io.ReadAll(io.LimitReader(file, maxReadFileBytes))
+	if err != nil {
+		return "", err
+	}
+	n := len(data)
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("%s is not a text file", name)
+	}
+	if info.Size() > maxReadFileBytes {
+		return fmt.Sprintf("%s\n[truncated: showing %d of %d bytes]", data, n, info.Size()), nil
+	}
+	return string(data), nil
+}
+
+// listDir runs a list_dir call.
+func (e *Executor) listDir(fnCall gollm.FunctionCall) (string, error) {
+	name, _ := fnCall.Arguments["path"].(string)
+	recursive, _ := fnCall.Arguments["recursive"].(bool)
+	local, err := e.localPath(name)
+	if err != nil {
+		return "", err
+	}
+	if !local.info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", name)
+	}
+
+	var entries []string
+	truncated := false
+	err = walk(local, func(entryRel string, entry fs.DirEntry) error {
+		if len(entries) == maxListEntries {
+			truncated = true
+			return fs.SkipAll
+		}
+		shown := entryRel
+		if local.rel != "." {
+			shown = strings.TrimPrefix(entryRel, local.rel+"/")
+		}
+		if entry.IsDir() {
+			shown += "/"
+			if !recursive {
+				entries = append(entries, shown)
+				return fs.SkipDir
+			}
+		}
+		entries = append(entries, shown)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if truncated {
+		entries = append(entries, fmt.Sprintf("[truncated after %d entries]", maxListEntries))
+	}
+	if len(entries) == 0 {
+		return "The directory is empty.", nil
+	}
+	return strings.Join(entries, "\n"), nil
+}
+
+// grep runs a grep call.
+func (e *Executor) grep(fnCall gollm.FunctionCall) (string, error) {
+	pattern, _ := fnCall.Arguments["pattern"].(string)
+	name, _ := fnCall.Arguments["path"].(string)
+	glob, _ := fnCall.Arguments["glob"].(string)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return "", fmt.Errorf("invalid glob: %w", err)
+	}
+
+	local, err := e.localPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	var matches []string
+	search := func(fileRel, file string) error {
+		if glob != "" {
+			if ok, _ := path.Match(glob, path.Base(fileRel)); !ok {
+				return nil
+			}
+		}
+		found, err := grepFile(file, fileRel, re, maxGrepMatches-len(matches))
+		matches = append(matches, found...)
+		if len(matches) >= maxGrepMatches {
+			return fs.SkipAll
+		}
+		return err
+	}
+	if !local.info.IsDir() {
+		err = search(local.rel, local.path)
+	} else {
+		err = walk(local, func(entryRel string, entry fs.DirEntry) error {
+			if entry.IsDir() {
+				return nil
+			}
+			return search(entryRel, filepath.Join(local.root, filepath.FromSlash(entryRel)))
+		})
+	}
+	if err != nil && !errors.Is(err, fs.SkipAll) {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "No matches.", nil
+	}
+	if len(matches) >= maxGrepMatches {
+		matches = append(matches, fmt.Sprintf("[stopped after %d matches]", maxGrepMatches))
+	}
+	return strings.Join(matches, "\n"), nil
+}
+
+// grepFile returns up to limit lines of a text file matching re. Large and
+// binary files are skipped.
+func grepFile(file, rel string, re *regexp.Regexp, limit int) ([]string, error) {
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxGrepFileBytes {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 {
+		return nil, nil
+	}
+
+	var matches []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, maxGrepFileBytes)
+	for n := 1; scanner.Scan() && len(matches) < limit; n++ {
+		line := scanner.Text()
+		if !re.MatchString(line) {
+			continue
+		}
+		if len(line) > maxGrepLineLength {
+			line = line[:maxGrepLineLength] + "..."
+		}
+		matches = append(matches, fmt.Sprintf("%s:%d: %s", rel, n, line))
+	}
+	return matches, nil
+}
+
+// walk visits the entries below the directory dir in lexical order.
+// Entries ignored by git and symbolic links are skipped. fn is given the
+// path of every entry relative to the root and may return fs.SkipDir or
+// fs.SkipAll.
+func walk(dir localFile, fn func(rel string, entry fs.DirEntry) error) error {
+	ignore := gitignore{}.withDir(dir.root, "")
+	if dir.rel != "." {
+		ignore, _ = gitignoreFor(dir.root, dir.rel, true)
+		ignore = ignore.withDir(dir.root, dir.rel)
+	}
+	err := walkDir(dir.root, dir.path, dir.rel, ignore, fn)
+	if errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walkDir is walk for one directory with the rules that apply in it.
+func walkDir(root, dir, rel string, ignore gitignore, fn func(rel string, entry fs.DirEntry) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSymlink != 0 {
+			continue
+		}
+		entryRel := entry.Name()
+		if rel != "." {
+			entryRel = rel + "/" + entry.Name()
+		}
+		if ignore.ignored(entryRel, entry.IsDir()) {
+			continue
+		}
+		err := fn(entryRel, entry)
+		if errors.Is(err, fs.SkipDir) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			sub := filepath.Join(dir, entry.Name())
+			if err := walkDir(root, sub, entryRel, ignore.withDir(root, entryRel), fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
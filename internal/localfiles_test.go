@@ -0,0 +1,221 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// localFilesTree creates a small repository next to a file outside of it
+// and returns an executor confined to the repository.
+func localFilesTree(t *testing.T) *Executor {
+	t.Helper()
+	dir := t.TempDir()
+	root := filepath.Join(dir, "repo")
+	files := map[string]string{
+		"outside.txt":                         "image: nginx:outside\n",
+		"repo/.gitignore":                     "*.tgz\nbuild/\n",
+		"repo/.git/config":                    "image: nginx:git\n",
+		"repo/README.md":                      "# Charts\n",
+		"repo/chart.tgz":                      "image: nginx:packed\n",
+		"repo/build/out.yaml":                 "image: nginx:built\n",
+		"repo/charts/web/values.yaml":         "image: nginx:1.27\nreplicas: 3\n",
+		"repo/charts/web/deployment.yaml":     "kind: Deployment\nimage: {{ .Values.image }}\n",
+		"repo/charts/web/.gitignore":          "values-*.yaml\n",
+		"repo/charts/web/values-prod.yaml":    "image: nginx:prod\n",
+		"repo/charts/web/templates/NOTES.txt": "nothing to see\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	require.NoError(t, os.Symlink(filepath.Join(dir, "outside.txt"), filepath.Join(root, "link.txt")))
+	require.NoError(t, os.Symlink(filepath.Join(root, ".git", "config"), filepath.Join(root, "git.txt")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "README.md"), filepath.Join(root, "readme.tgz")))
+
+	executor := NewExecutor()
+	executor.WorkDir = root
+	// the file tools never ask
+	executor.Approve = func(ApprovalRequest) bool { return false }
+	return executor
+}
+
+// fileCall builds a read_file, list_dir or grep call.
+func fileCall(tool string, args map[string]any) gollm.FunctionCall {
+	return gollm.FunctionCall{ID: "1", Name: tool, Arguments: args}
+}
+
+// TestLocalFileTools reads, lists and searches the working directory only.
+func TestLocalFileTools(t *testing.T) {
+	executor := localFilesTree(t)
+
+	tests := []struct {
+		name    string
+		call    gollm.FunctionCall
+		want    string
+		wantErr string
+	}{
+		{
+			name: "read a file",
+			call: fileCall(readFileTool, map[string]any{"path": "charts/web/values.yaml"}),
+			want: "image: nginx:1.27\nreplicas: 3\n",
+		},
+		{
+			name:    "read outside",
+			call:    fileCall(readFileTool, map[string]any{"path": "../outside.txt"}),
+			wantErr: "outside",
+		},
+		{
+			name:    "read through a symlink",
+			call:    fileCall(readFileTool, map[string]any{"path": "link.txt"}),
+			wantErr: "outside",
+		},
+		{
+			name:    "read an ignored file",
+			call:    fileCall(readFileTool, map[string]any{"path": "charts/web/values-prod.yaml"}),
+			wantErr: "ignored by .gitignore",
+		},
+		{
+			name:    "read in an ignored directory",
+			call:    fileCall(readFileTool, map[string]any{"path": "build/out.yaml"}),
+			wantErr: "ignored by .gitignore",
+		},
+		{
+			name:    "read in .git",
+			call:    fileCall(readFileTool, map[string]any{"path": ".git/config"}),
+			wantErr: "inside .git",
+		},
+		{
+			name:    "read in .git through a symlink",
+			call:    fileCall(readFileTool, map[string]any{"path": "git.txt"}),
+			wantErr: "inside .git",
+		},
+		{
+			name:    "read an ignored symlink",
+			call:    fileCall(readFileTool, map[string]any{"path": "readme.tgz"}),
+			wantErr: "ignored by .gitignore",
+		},
+		{
+			name:    "list .git",
+			call:    fileCall(listDirTool, map[string]any{"path": ".git"}),
+			wantErr: "inside .git",
+		},
+		{
+			name:    "grep in .git",
+			call:    fileCall(grepTool, map[string]any{"pattern": `nginx`, "path": ".git"}),
+			wantErr: "inside .git",
+		},
+		{
+			name:    "read a directory",
+			call:    fileCall(readFileTool, map[string]any{"path": "charts"}),
+			wantErr: "use list_dir",
+		},
+		{
+			name: "list the root",
+			call: fileCall(listDirTool, map[string]any{}),
+			want: ".gitignore\nREADME.md\ncharts/",
+		},
+		{
+			name: "list recursively",
+			call: fileCall(listDirTool, map[string]any{"path": "charts/web", "recursive": true}),
+			want: ".gitignore\ndeployment.yaml\ntemplates/\ntemplates/NOTES.txt\nvalues.yaml",
+		},
+		{
+			name:    "list an ignored directory",
+			call:    fileCall(listDirTool, map[string]any{"path": "build"}),
+			wantErr: "ignored by .gitignore",
+		},
+		{
+			name: "grep everything",
+			call: fileCall(grepTool, map[string]any{"pattern": `image: nginx`}),
+			want: "charts/web/values.yaml:1: image: nginx:1.27",
+		},
+		{
+			name: "grep with a glob",
+			call: fileCall(grepTool, map[string]any{"pattern": `image`, "glob": "deploy*.yaml"}),
+			want: "charts/web/deployment.yaml:2: image: {{ .Values.image }}",
+		},
+		{
+			name: "grep one file",
+			call: fileCall(grepTool, map[string]any{"pattern": `^replicas`, "path": "charts/web/values.yaml"}),
+			want: "charts/web/values.yaml:2: replicas: 3",
+		},
+		{
+			name: "grep without matches",
+			call: fileCall(grepTool, map[string]any{"pattern": `kind: Service`}),
+			want: "No matches.",
+		},
+		{
+			name:    "grep a bad pattern",
+			call:    fileCall(grepTool, map[string]any{"pattern": `(`}),
+			wantErr: "invalid pattern",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executor.Call(t.Context(), tt.call)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestLocalFileCaps truncates large files, listings and searches.
+func TestLocalFileCaps(t *testing.T) {
+	executor := localFilesTree(t)
+	root := executor.WorkDir
+
+	big := strings.Repeat("a", maxReadFileBytes+10)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "big.txt"), []byte(big), 0o644))
+	got, err := executor.Call(t.Context(), fileCall(readFileTool, map[string]any{"path": "big.txt"}))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(got, big[:maxReadFileBytes]+"\n"))
+	assert.Contains(t, got, fmt.Sprintf("[truncated: showing %d of %d bytes]", maxReadFileBytes, len(big)))
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "binary.bin"), []byte("image\x00"), 0o644))
+	_, err = executor.Call(t.Context(), fileCall(readFileTool, map[string]any{"path": "binary.bin"}))
+	assert.ErrorContains(t, err, "not a text file")
+
+	many := filepath.Join(root, "many")
+	require.NoError(t, os.Mkdir(many, 0o755))
+	for i := range maxListEntries + 1 {
+		require.NoError(t, os.WriteFile(filepath.Join(many, fmt.Sprintf("f%03d.txt", i)), []byte("match "+strings.Repeat("x", maxGrepLineLength)+"\n"), 0o644))
+	}
+	got, err = executor.Call(t.Context(), fileCall(listDirTool, map[string]any{"path": "many"}))
+	require.NoError(t, err)
+	lines := strings.Split(got, "\n")
+	assert.Len(t, lines, maxListEntries+1)
+	assert.Equal(t, fmt.Sprintf("[truncated after %d entries]", maxListEntries), lines[maxListEntries])
+
+	got, err = executor.Call(t.Context(), fileCall(grepTool, map[string]any{"pattern": "^match", "path": "many"}))
+	require.NoError(t, err)
+	lines = strings.Split(got, "\n")
+	assert.Len(t, lines, maxGrepMatches+1)
+	assert.Equal(t, "many/f000.txt:1: match "+strings.Repeat("x", maxGrepLineLength-len("match "))+"...", lines[0])
+	assert.Equal(t, fmt.Sprintf("[stopped after %d matches]", maxGrepMatches), lines[maxGrepMatches])
+}
@@ -0,0 +1,160 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/alecthomas/chroma/v2/quick"
+	"gopkg.in/yaml.v3"
+)
+
+// writeManifestTool is the name of the tool saving manifests into the workspace.
+const writeManifestTool = "write_manifest"
+
+// ErrInvalidManifest is returned for manifests that were not saved because
+// they are not valid Kubernetes YAML. The model is told why so it can fix them.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// writeManifestDefinition describes the write_manifest tool.
+func writeManifestDefinition() *gollm.FunctionDefinition {
+	return &gollm.FunctionDefinition{
+		Name: writeManifestTool,
+		Description: "Save Kubernetes YAML into a file in the session workspace, for the user to review. " +
+			"Use it instead of piping YAML into kubectl, then apply the returned path with kubectl apply -f.",
+		Parameters: &gollm.Schema{
+			Type: gollm.TypeObject,
+			Properties: map[string]*gollm.Schema{
+				"name": {
+					Type:        gollm.TypeString,
+					Description: "File name ending in .yaml, e.g. web-deployment.yaml. Existing files are overwritten.",
+				},
+				"content": {
+					Type:        gollm.TypeString,
+					Description: "The manifest. Several objects are separated by ---.",
+				},
+			},
+			Required: []string{"name", "content"},
+		},
+	}
+}
+
+// WorkspacePath returns the default directory holding the session workspaces, next to the config file.
+func WorkspacePath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "workspace")
+}
+
+// workspace returns the directory manifests are saved in.
+func (e *Executor) workspace() string {
+	if e.Workspace != "" {
+		return e.Workspace
+	}
+	return filepath.Join(os.TempDir(), "bubblechat-"+e.Session)
+}
+
+// ManifestPath returns where a write_manifest call saves its file.
+func (e *Executor) ManifestPath(fnCall gollm.FunctionCall) string {
+	name, _ := fnCall.Arguments["name"].(string)
+	return filepath.Join(e.workspace(), name)
+}
+
+// writeManifest validates and saves a manifest into the workspace.
+func (e *Executor) writeManifest(fnCall gollm.FunctionCall) (string, error) {
+	name, _ := fnCall.Arguments["name"].(string)
+	content, _ := fnCall.Arguments["content"].(string)
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("%w: the name must be a plain file name, not %q", ErrInvalidManifest, name)
+	}
+	if ext := filepath.Ext(name); ext != ".yaml" && ext != ".yml" {
+		return "", fmt.Errorf("%w: the name must end in .yaml, not %q", ErrInvalidManifest, name)
+	}
+	objects, err := validateManifest(content)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+
+	if err := os.MkdirAll(e.workspace(), 0o700); err != nil {
+		return "", err
+	}
+	path := e.ManifestPath(fnCall)
+	if err := os.WriteFile(path, []byte(strings.TrimRight(content, "\n")+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Saved %s with %s. It is not applied yet, run kubectl apply -f %s once the user agrees.", path, strings.Join(objects, ", "), path), nil
+}
+
+// validateManifest checks that every document of a manifest is a Kubernetes
+// object with an apiVersion, a kind and a name, and returns them as kind/name.
+func validateManifest(content string) ([]string, error) {
+	var objects []string
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for i := 1; ; i++ {
+		var doc struct {
+			APIVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+			Metadata   struct {
+				Name         string `yaml:"name"`
+				GenerateName string `yaml:"generateName"`
+			} `yaml:"metadata"`
+		}
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+		if len(node.Content) == 0 || node.Content[0].Tag == "!!null" {
+			// an empty document, e.g. after a trailing ---
+			continue
+		}
+		if err := node.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("document %d is not a Kubernetes object: %w", i, err)
+		}
+		name := doc.Metadata.Name
+		if name == "" {
+			name = doc.Metadata.GenerateName
+		}
+		switch {
+		case doc.APIVersion == "":
+			return nil, fmt.Errorf("document %d has no apiVersion", i)
+		case doc.Kind == "":
+			return nil, fmt.Errorf("document %d has no kind", i)
+		case name == "":
+			return nil, fmt.Errorf("document %d (%s) has no metadata.name", i, doc.Kind)
+		}
+		objects = append(objects, doc.Kind+"/"+name)
+	}
+	if len(objects) == 0 {
+		return nil, errors.New("the manifest is empty")
+	}
+	return objects, nil
+}
+
+// highlightYAML colors YAML for the terminal, leaving it as is when that fails.
+func highlightYAML(text string) string {
+	var sb strings.Builder
+	if err := quick.Highlight(&sb, text, "yaml", "terminal256", "monokai"); err != nil {
+		return text
+	}
+	return sb.String()
+}
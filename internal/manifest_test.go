@@ -0,0 +1,126 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const webManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+`
+
+// TestValidateManifest accepts Kubernetes objects only.
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+		wantErr string
+	}{
+		{content: webManifest, want: []string{"Deployment/web", "Service/web"}},
+		{content: "apiVersion: v1\nkind: Pod\nmetadata:\n  generateName: job-\n", want: []string{"Pod/job-"}},
+		{content: "kind: Pod\nmetadata:\n  name: a\n", wantErr: "document 1 has no apiVersion"},
+		{content: webManifest + "apiVersion: v1\nmetadata:\n  name: a\n", wantErr: "document 3 has no kind"},
+		{content: "apiVersion: v1\nkind: Pod\n", wantErr: "document 1 (Pod) has no metadata.name"},
+		{content: "- a\n- b\n", wantErr: "document 1 is not a Kubernetes object"},
+		{content: "apiVersion: v1\n  kind: [", wantErr: "document 1:"},
+		{content: "", wantErr: "the manifest is empty"},
+	}
+	for _, tt := range tests {
+		got, err := validateManifest(tt.content)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.content)
+			continue
+		}
+		require.NoError(t, err, tt.content)
+		assert.Equal(t, tt.want, got)
+	}
+}
+
+// writeManifestCall builds a write_manifest call.
+func writeManifestCall(id, name, content string) gollm.FunctionCall {
+	return gollm.FunctionCall{ID: id, Name: writeManifestTool, Arguments: map[string]any{"name": name, "content": content}}
+}
+
+// TestWriteManifest saves manifests into the workspace and shows them.
+func TestWriteManifest(t *testing.T) {
+	h, chat := newFakeHistory(t,
+		callResponse(writeManifestCall("1", "../web.yaml", webManifest)),
+		callResponse(writeManifestCall("2", "web.yaml", webManifest)),
+		textResponse("Saved it, apply when ready."),
+	)
+	h.Workspace = filepath.Join(t.TempDir(), "session")
+	// writing a manifest never asks
+	h.Approve = func(ApprovalRequest) bool { return false }
+
+	h.ChatLoop("create a web deployment")
+
+	path := filepath.Join(h.Workspace, "web.yaml")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, webManifest, string(data))
+
+	sent := chat.Sent()
+	require.Len(t, sent, 3)
+	invalid := sent[1][0].(gollm.FunctionCallResult)
+	assert.Contains(t, invalid.Result["error"], "the name must be a plain file name")
+	saved := sent[2][0].(gollm.FunctionCallResult)
+	assert.Contains(t, saved.Result["output"], "Saved "+path+" with Deployment/web, Service/web")
+	assert.Contains(t, saved.Result["output"], "kubectl apply -f "+path)
+
+	var manifests []Block
+	for _, block := range h.Snapshot() {
+		if block.Type == ManifestBlock {
+			manifests = append(manifests, block)
+		}
+	}
+	require.Len(t, manifests, 1)
+	assert.True(t, strings.HasPrefix(manifests[0].Text, "# "+path+"\napiVersion: apps/v1\n"), manifests[0].Text)
+	assert.NotEqual(t, manifests[0].Text, highlightYAML(manifests[0].Text), "the manifest is highlighted")
+}
+
+// TestApplyManifestOtherNamespace applies a saved manifest whose objects are
+// in another namespace than the pinned one, which kubectl refuses when
+// --namespace is given.
+func TestApplyManifestOtherNamespace(t *testing.T) {
+	fakeKubectl(t, `case "$*" in
+*--namespace*) echo 'error: the namespace from the provided object "web" does not match the namespace "payments"' >&2; exit 1;;
+esac
+echo "deployment.apps/web configured args: $*"`)
+	path := filepath.Join(t.TempDir(), "web.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(webManifest, "name: web", "name: web\n  namespace: web")), 0o644))
+
+	executor := NewExecutor()
+	executor.Cluster = ClusterContext{KubeContext: "staging", Namespace: "payments"}
+	executor.Approve = func(ApprovalRequest) bool { return true }
+	output, err := executor.Call(t.Context(), toolCall("1", "kubectl", "apply -f "+path))
+	require.NoError(t, err)
+	assert.Contains(t, output, "args: apply -f "+path+" --context staging")
+}
@@ -0,0 +1,210 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// mcpVersion is the version bubblechat reports to MCP clients and servers.
+const mcpVersion = "0.1.0"
+
+// MCPServerConfig describes an external Model Context Protocol server.
+// Either Command, for a server speaking MCP over stdio, or URL, for a
+// streamable HTTP server, must be set.
+type MCPServerConfig struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	URL     string            `yaml:"url"`
+	// ReadOnlyTools are the tools of the server that run without approval.
+	// The read-only hints of the server are not trusted on their own.
+	ReadOnlyTools []string `yaml:"readOnlyTools"`
+}
+
+// MCPClients holds the connections to the configured MCP servers
+// and the tools they offer.
+type MCPClients struct {
+	Tools   []Tool
+	clients []*client.Client
+}
+
+// ConnectMCPServers connects to every configured server and discovers its tools.
+// Connections that were established are returned even when another one fails,
+// so the caller can carry on with the tools that are available.
+func ConnectMCPServers(ctx context.Context, servers []MCPServerConfig) (*MCPClients, error) {
+	result := &MCPClients{}
+	var errs []error
+	for _, server := range servers {
+		c, err := connectMCPServer(ctx, server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("MCP server %s: %w", server.Name, err))
+			continue
+		}
+		result.clients = append(result.clients, c)
+
+		tools, err := c.ListTools(ctx, mcp.ListToolsRequest{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("MCP server %s: listing tools: %w", server.Name, err))
+			continue
+		}
+		for _, tool := range tools.Tools {
+			result.Tools = append(result.Tools, mcpTool(c, tool, slices.Contains(server.ReadOnlyTools, tool.Name)))
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// Close shuts down all MCP connections, stopping stdio servers.
+func (m *MCPClients) Close() error {
+	var errs []error
+	for _, c := range m.clients {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func connectMCPServer(ctx context.Context, server MCPServerConfig) (*client.Client, error) {
+	var c *client.Client
+	var err error
+	switch {
+	case server.Command != "":
+		var env []string
+		for k, v := range server.Env {
+			env = append(env, k+"="+v)
+		}
+		// the stdio client starts the process itself
+		c, err = client.NewStdioMCPClient(server.Command, env, server.Args...)
+	case server.URL != "":
+		c, err = client.NewStreamableHttpClient(server.URL)
+		if err == nil {
+			err = c.Start(ctx)
+		}
+	default:
+		err = errors.New("either command or url must be set")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	init := mcp.InitializeRequest{}
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	init.Params.ClientInfo = mcp.Implementation{
+		Name:    "bubblechat",
+		Version: mcpVersion,
+	}
+	if _, err := c.Initialize(ctx, init); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initializing: %w", err)
+	}
+	return c, nil
+}
+
+// mcpTool wraps a tool discovered on an MCP server so the model can call it.
+// Only tools configured as read-only run without approval.
+func mcpTool(c *client.Client, tool mcp.Tool, readOnly bool) Tool {
+	name := tool.Name
+	return Tool{
+		Definition: &gollm.FunctionDefinition{
+			Name:        name,
+			Description: tool.Description,
+			Parameters: mcpSchema(map[string]any{
+				"type":       tool.InputSchema.Type,
+				"properties": tool.InputSchema.Properties,
+				"required":   toAnySlice(tool.InputSchema.Required),
+			}),
+		},
+		ReadOnly: readOnly,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			req := mcp.CallToolRequest{}
+			req.Params.Name = name
+			req.Params.Arguments = args
+			res, err := c.CallTool(ctx, req)
+			if err != nil {
+				return "", err
+			}
+
+			var sb strings.Builder
+			for _, content := range res.Content {
+				if text, ok := mcp.AsTextContent(content); ok {
+					sb.WriteString(text.Text)
+					sb.WriteString("\n")
+				}
+			}
+			output := strings.TrimSuffix(sb.String(), "\n")
+			if res.IsError {
+				return output, fmt.Errorf("%s failed: %s", name, output)
+			}
+			return output, nil
+		},
+	}
+}
+
+func toAnySlice(values []string) []any {
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+// mcpSchema converts a JSON schema as published by MCP servers
+// into the subset understood by gollm.
+func mcpSchema(schema map[string]any) *gollm.Schema {
+	result := &gollm.Schema{}
+	if t, ok := schema["type"].(string); ok {
+		result.Type = gollm.SchemaType(t)
+	}
+	if result.Type == "" {
+		result.Type = gollm.TypeObject
+	}
+	if d, ok := schema["description"].(string); ok {
+		result.Description = d
+	}
+
+	if props, ok := schema["properties"].(map[string]any); ok {
+		result.Properties = map[string]*gollm.Schema{}
+		for name, prop := range props {
+			if p, ok := prop.(map[string]any); ok {
+				result.Properties[name] = mcpSchema(p)
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		result.Items = mcpSchema(items)
+	}
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				result.Required = append(result.Required, name)
+			}
+		}
+	}
+
+	// gollm has no number type, integers are the closest match
+	if result.Type == "number" {
+		result.Type = gollm.TypeInteger
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMCPServerEnv makes the test binary act as a stdio MCP server,
+// so the tests have a real server process to talk to.
+const testMCPServerEnv = "BUBBLECHAT_TEST_MCP_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMCPServerEnv) == "1" {
+		serveTestMCPServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// serveTestMCPServer offers a read-only runbook search and a ticket tool that always fails.
+func serveTestMCPServer() {
+	s := server.NewMCPServer("runbooks", "0.0.1")
+	s.AddTool(
+		mcp.NewTool("runbook_search",
+			mcp.WithDescription("Search the team runbooks."),
+			mcp.WithString("query", mcp.Required(), mcp.Description("What to search for.")),
+			mcp.WithReadOnlyHintAnnotation(true),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			query, err := req.RequireString("query")
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return mcp.NewToolResultText("runbook for " + query), nil
+		},
+	)
+	s.AddTool(
+		mcp.NewTool("ticket_close",
+			mcp.WithDescription("Close a ticket."),
+			mcp.WithString("id", mcp.Required()),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultError("ticket not found"), nil
+		},
+	)
+	server.ServeStdio(s)
+}
+
+func connectTestMCPServer(t *testing.T, readOnlyTools ...string) *MCPClients {
+	t.Helper()
+	clients, err := ConnectMCPServers(t.Context(), []MCPServerConfig{{
+		Name:          "runbooks",
+		Command:       os.Args[0],
+		Env:           map[string]string{testMCPServerEnv: "1"},
+		ReadOnlyTools: readOnlyTools,
+	}})
+	require.NoError(t, err)
+	t.Cleanup(func() { clients.Close() })
+	return clients
+}
+
+// TestMCPToolDiscovery checks that tools and their schemas are discovered.
+func TestMCPToolDiscovery(t *testing.T) {
+	clients := connectTestMCPServer(t, "runbook_search")
+	require.Len(t, clients.Tools, 2)
+
+	tools := map[string]Tool{}
+	for _, tool := range clients.Tools {
+		tools[tool.Definition.Name] = tool
+	}
+
+	search := tools["runbook_search"]
+	require.NotNil(t, search.Definition)
+	assert.True(t, search.ReadOnly)
+	assert.Equal(t, gollm.TypeObject, search.Definition.Parameters.Type)
+	assert.Equal(t, gollm.TypeString, search.Definition.Parameters.Properties["query"].Type)
+	assert.Equal(t, []string{"query"}, search.Definition.Parameters.Required)
+
+	assert.False(t, tools["ticket_close"].ReadOnly)
+	_, err := tools["ticket_close"].Run(t.Context(), map[string]any{"id": "42"})
+	assert.ErrorContains(t, err, "ticket not found")
+
+	// the read-only hint of the server alone does not spare the approval
+	clients = connectTestMCPServer(t)
+	for _, tool := range clients.Tools {
+		assert.False(t, tool.ReadOnly, tool.Definition.Name)
+	}
+}
+
+// TestMCPToolCall routes a call from the model to the MCP server.
+func TestMCPToolCall(t *testing.T) {
+	clients := connectTestMCPServer(t)
+
+	h, chat := newFakeHistory(t,
+		callResponse(gollm.FunctionCall{
+			ID:        "call-1",
+			Name:      "runbook_search",
+			Arguments: map[string]any{"query": "crashloop"},
+		}),
+		textResponse("Found it."),
+	)
+	for _, tool := range clients.Tools {
+		require.NoError(t, h.RegisterTool(tool))
+	}
+	// kubectl, gcloud, k8s_get, k8s_list, write_manifest, read_file,
+	// list_dir, grep, read_artifact and the two MCP tools
+	assert.Len(t, chat.defs, 11)
+	assert.Error(t, h.RegisterTool(clients.Tools[0]))
+
+	h.ChatLoop("how do I fix a crashloop?")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	result, ok := sent[1][0].(gollm.FunctionCallResult)
+	require.True(t, ok)
+	assert.Equal(t, "runbook for crashloop", result.Result["output"])
+	assert.Equal(t, ToolBlock, h.Blocks[0].Type)
+	assert.Contains(t, h.Blocks[0].Text, `"query":"crashloop"`)
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ServeMCP exposes the executor's tools as an MCP server on stdin and stdout
+// until the context is cancelled or the client disconnects.
+func ServeMCP(ctx context.Context, executor *Executor, timeout time.Duration) error {
+	stdio := server.NewStdioServer(NewMCPServer(executor, timeout))
+	return stdio.Listen(ctx, os.Stdin, os.Stdout)
+}
+
+// NewMCPServer creates an MCP server offering every tool of the executor.
+// Calls go through Executor.Call, so the executor's approval policy applies.
+// A call still running after timeout is stopped; zero means no limit.
+func NewMCPServer(executor *Executor, timeout time.Duration) *server.MCPServer {
+	s := server.NewMCPServer("bubblechat", mcpVersion, server.WithToolCapabilities(false))
+	for _, def := range executor.Definitions() {
+		// the hints follow the approval policy: tools that always run are
+		// read-only, kubectl and gcloud depend on the command and are not
+		readOnly := executor.IsReadOnly(gollm.FunctionCall{Name: def.Name})
+		s.AddTool(mcpServerTool(def, readOnly), mcpHandler(executor, def.Name, timeout))
+	}
+	return s
+}
+
+// mcpServerTool describes a tool definition in MCP terms.
+func mcpServerTool(def *gollm.FunctionDefinition, readOnly bool) mcp.Tool {
+	schema, _ := json.Marshal(schemaJSON(def.Parameters))
+	tool := mcp.NewToolWithRawSchema(def.Name, def.Description, schema)
+	tool.Annotations.ReadOnlyHint = &readOnly
+	destructive := !readOnly
+	tool.Annotations.DestructiveHint = &destructive
+	return tool
+}
+
+func mcpHandler(executor *Executor, name string, timeout time.Duration) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		output, err := executor.Call(ctx, gollm.FunctionCall{
+			Name:      name,
+			Arguments: req.GetArguments(),
+		})
+		// the client's model is just as much a third party as ours
+		output, _ = executor.Redact(output)
+		switch {
+		case errors.Is(err, ErrDeclined):
+			return mcp.NewToolResultError("bubblechat's policy does not allow this call, only read-only commands may run"), nil
+		case errors.Is(err, ErrProtected), errors.Is(err, ErrBlocked):
+			return mcp.NewToolResultError(err.Error()), nil
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return mcp.NewToolResultError(strings.TrimSpace(fmt.Sprintf("%s was stopped after %v\n%s", name, timeout, output))), nil
+		case err != nil:
+			return mcp.NewToolResultError(strings.TrimSpace(fmt.Sprintf("%v\n%s", err, output))), nil
+		default:
+			return mcp.NewToolResultText(output), nil
+		}
+	}
+}
+
+// schemaJSON converts a gollm schema into a JSON schema document.
+func schemaJSON(schema *gollm.Schema) map[string]any {
+	if schema == nil {
+		return map[string]any{"type": "object"}
+	}
+
+	result := map[string]any{"type": string(schema.Type)}
+	if schema.Description != "" {
+		result["description"] = schema.Description
+	}
+	if len(schema.Properties) > 0 {
+		props := map[string]any{}
+		for name, prop := range schema.Properties {
+			props[name] = schemaJSON(prop)
+		}
+		result["properties"] = props
+	}
+	if schema.Items != nil {
+		result["items"] = schemaJSON(schema.Items)
+	}
+	if len(schema.Required) > 0 {
+		result["required"] = schema.Required
+	}
+	return result
+}
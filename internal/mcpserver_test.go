@@ -0,0 +1,139 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMCPClient connects an in-process MCP client to bubblechat's MCP server.
+func newTestMCPClient(t *testing.T, executor *Executor, timeout time.Duration) *client.Client {
+	t.Helper()
+	c, err := client.NewInProcessClient(NewMCPServer(executor, timeout))
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	require.NoError(t, c.Start(t.Context()))
+
+	init := mcp.InitializeRequest{}
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	_, err = c.Initialize(t.Context(), init)
+	require.NoError(t, err)
+	return c
+}
+
+func callMCPTool(t *testing.T, c *client.Client, name string, args map[string]any) *mcp.CallToolResult {
+	t.Helper()
+	req := mcp.CallToolRequest{}
+	req.Params.Name = name
+	req.Params.Arguments = args
+	res, err := c.CallTool(t.Context(), req)
+	require.NoError(t, err)
+	return res
+}
+
+func resultText(res *mcp.CallToolResult) string {
+	for _, content := range res.Content {
+		if text, ok := mcp.AsTextContent(content); ok {
+			return text.Text
+		}
+	}
+	return ""
+}
+
+// TestMCPServerTools lists the exposed tools and calls a registered one.
+func TestMCPServerTools(t *testing.T) {
+	executor := NewExecutor()
+	require.NoError(t, executor.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{
+			Name:        "echo",
+			Description: "Echo the input.",
+			Parameters: &gollm.Schema{
+				Type: gollm.TypeObject,
+				Properties: map[string]*gollm.Schema{
+					"text": {Type: gollm.TypeString},
+				},
+				Required: []string{"text"},
+			},
+		},
+		ReadOnly: true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return args["text"].(string), nil
+		},
+	}))
+	c := newTestMCPClient(t, executor, 0)
+
+	tools, err := c.ListTools(t.Context(), mcp.ListToolsRequest{})
+	require.NoError(t, err)
+	names := map[string]mcp.Tool{}
+	for _, tool := range tools.Tools {
+		names[tool.Name] = tool
+	}
+	require.Contains(t, names, "kubectl")
+	require.Contains(t, names, "gcloud")
+	require.Contains(t, names, "echo")
+	assert.True(t, *names["echo"].Annotations.ReadOnlyHint)
+	assert.False(t, *names["kubectl"].Annotations.ReadOnlyHint)
+	assert.True(t, *names["kubectl"].Annotations.DestructiveHint)
+	require.Contains(t, names, k8sGetTool)
+	assert.True(t, *names[k8sGetTool].Annotations.ReadOnlyHint)
+	assert.False(t, *names[k8sGetTool].Annotations.DestructiveHint)
+
+	res := callMCPTool(t, c, "echo", map[string]any{"text": "hello"})
+	assert.False(t, res.IsError)
+	assert.Equal(t, "hello", resultText(res))
+}
+
+// TestMCPServerPolicy checks that mutating commands are refused
+// when the executor's policy does not approve them.
+func TestMCPServerPolicy(t *testing.T) {
+	executor := NewExecutor()
+	executor.Approve = func(req ApprovalRequest) bool {
+		assert.Equal(t, "delete pod api-0", req.Command)
+		return false
+	}
+	c := newTestMCPClient(t, executor, 0)
+
+	res := callMCPTool(t, c, "kubectl", map[string]any{"command": "delete pod api-0"})
+	assert.True(t, res.IsError)
+	assert.Contains(t, resultText(res), "policy")
+}
+
+// TestMCPServerTimeout stops a call that runs longer than the timeout.
+func TestMCPServerTimeout(t *testing.T) {
+	executor := NewExecutor()
+	require.NoError(t, executor.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "wait"},
+		ReadOnly:   true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	}))
+	c := newTestMCPClient(t, executor, 50*time.Millisecond)
+
+	start := time.Now()
+	res := callMCPTool(t, c, "wait", nil)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.True(t, res.IsError)
+	assert.Equal(t, "wait was stopped after 50ms", resultText(res))
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// RunCommand runs a kubectl or gcloud command the user typed after "!". It
+// goes through the same approval, protection and audit as the model's tool
+// calls. The output is shown, and handed to the model with the next query
+// so it can reason over it without running the command again.
+func (h *History) RunCommand(command string) {
+	command = strings.TrimSpace(command)
+	tool, args, _ := strings.Cut(command, " ")
+	if tool != "kubectl" && tool != "gcloud" {
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Only kubectl and gcloud commands can be run with !, not %q.", tool),
+			Type: ErrorBlock,
+		})
+		return
+	}
+
+	fnCall := gollm.FunctionCall{
+		Name:      tool,
+		Arguments: map[string]any{"command": strings.TrimSpace(args)},
+	}
+	result, err := h.Call(h.Context, fnCall)
+	if errors.Is(err, ErrDeclined) || errors.Is(err, ErrProtected) || errors.Is(err, ErrBlocked) {
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Did not run %s: %v", command, err),
+			Type: ErrorBlock,
+		})
+		return
+	}
+
+	// the output is shown as the model will see it
+	output, redactions := h.Redact(result)
+	text := fmt.Sprintf("$ %s\n%s", command, strings.TrimRight(output, "\n"))
+	observation := text
+	if err != nil {
+		text += fmt.Sprintf("\n\nError: %v", err)
+		observation += fmt.Sprintf("\n(failed: %v)", err)
+	}
+	h.AddBlock(Block{Text: text, Type: ToolBlock})
+	if len(redactions) > 0 {
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Redacted from the %s output before sending it to the model: %s", tool, DescribeRedactions(redactions)),
+			Type: ToolBlock,
+		})
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observations = append(h.observations, observation)
+}
+
+// takeObservations returns the pending output of commands the user ran,
+// worded for the model, and forgets it.
+func (h *History) takeObservations() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.observations) == 0 {
+		return ""
+	}
+	observations := "The user ran these commands themselves, with this output:\n\n" + strings.Join(h.observations, "\n\n")
+	h.observations = nil
+	return observations
+}
@@ -0,0 +1,91 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKubectl puts a kubectl on the PATH that runs the given shell script.
+func fakeKubectl(t *testing.T, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake kubectl is a shell script")
+	}
+	dir := t.TempDir()
+	script = "#!/bin/sh\n" + script
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// TestRunCommand runs a command typed after ! and hands its output to the model.
+func TestRunCommand(t *testing.T) {
+	fakeKubectl(t, `echo "NAME READY args: $*"`)
+	doc, chat := newTestDoc(t, textResponse("one pod, and it is ready"))
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := OpenAuditLog(auditPath)
+	require.NoError(t, err)
+	defer audit.Close()
+	doc.Audit = audit
+
+	send(doc, "!kubectl get pods -n payments")
+	block := lastBlock(doc)
+	assert.Equal(t, ToolBlock, block.Type)
+	assert.Equal(t, "$ kubectl get pods -n payments\nNAME READY args: get pods -n payments", block.Text)
+	assert.Empty(t, chat.Sent(), "the command does not start a turn")
+
+	data, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	var record AuditRecord
+	require.NoError(t, json.Unmarshal(data, &record))
+	assert.Equal(t, DecisionReadOnly, record.Decision)
+	assert.Equal(t, []string{"kubectl", "get", "pods", "-n", "payments"}, record.Argv)
+
+	send(doc, "is it healthy?")
+	sent := chat.Sent()
+	require.Len(t, sent, 1)
+	query := sent[0][0].(string)
+	assert.Contains(t, query, "The user ran these commands themselves")
+	assert.Contains(t, query, "$ kubectl get pods -n payments\nNAME READY")
+	assert.True(t, strings.HasSuffix(query, "is it healthy?"))
+
+	send(doc, "and now?")
+	assert.Empty(t, doc.observations, "the output is only sent once")
+}
+
+// TestRunCommandRejected shows why a command did not run.
+func TestRunCommandRejected(t *testing.T) {
+	doc, chat := newTestDoc(t)
+	doc.Approve = func(ApprovalRequest) bool { return false }
+
+	send(doc, "!kubectl delete pod api-0")
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+	assert.Contains(t, lastBlock(doc).Text, "Did not run kubectl delete pod api-0")
+
+	send(doc, "!rm -rf /")
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+	assert.Contains(t, lastBlock(doc).Text, `not "rm"`)
+
+	assert.Empty(t, doc.observations)
+	assert.Empty(t, chat.Sent())
+}
@@ -0,0 +1,222 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// kubectlReadOnly lists the kubectl subcommands that never change cluster state.
+// Subcommands that are only read-only with a specific sub-verb are listed as
+// "verb subverb".
+var kubectlReadOnly = map[string]bool{
+	"get":                    true,
+	"describe":               true,
+	"logs":                   true,
+	"top":                    true,
+	"explain":                true,
+	"events":                 true,
+	"diff":                   true,
+	"version":                true,
+	"api-resources":          true,
+	"api-versions":           true,
+	"cluster-info":           true,
+	"auth can-i":             true,
+	"auth whoami":            true,
+	"rollout status":         true,
+	"rollout history":        true,
+	"config view":            true,
+	"config current-context": true,
+	"config get-contexts":    true,
+	"config get-clusters":    true,
+}
+
+// kubectlValueFlags are the global kubectl flags that take a value, so the
+// token following them is not mistaken for the subcommand.
+var kubectlValueFlags = map[string]bool{
+	"-n":                      true,
+	"--namespace":             true,
+	"--context":               true,
+	"--cluster":               true,
+	"--user":                  true,
+	"--kubeconfig":            true,
+	"-s":                      true,
+	"--server":                true,
+	"--as":                    true,
+	"--as-group":              true,
+	"--as-uid":                true,
+	"--request-timeout":       true,
+	"--token":                 true,
+	"--cache-dir":             true,
+	"--certificate-authority": true,
+	"--client-certificate":    true,
+	"--client-key":            true,
+	"--tls-server-name":       true,
+	"--username":              true,
+	"--password":              true,
+	"-v":                      true,
+	"--v":                     true,
+}
+
+// kubectlBoolFlags are the global kubectl flags that take no value.
+var kubectlBoolFlags = map[string]bool{
+	"--insecure-skip-tls-verify": true,
+	"--match-server-version":     true,
+	"--warnings-as-errors":       true,
+	"--disable-compression":      true,
+}
+
+// gcloudReadOnlyVerbs are the gcloud command verbs that only read state.
+var gcloudReadOnlyVerbs = map[string]bool{
+	"list":           true,
+	"describe":       true,
+	"get-value":      true,
+	"get-iam-policy": true,
+	"info":           true,
+	"version":        true,
+	"read":           true,
+}
+
+// gcloudMutatingVerbs are gcloud command verbs that change state. A
+// read-only verb after one of them is a resource name, as in
+// "instances delete list".
+var gcloudMutatingVerbs = map[string]bool{
+	"create": true, "delete": true, "update": true, "patch": true, "set": true,
+	"unset": true, "add": true, "remove": true, "deploy": true, "start": true,
+	"stop": true, "reset": true, "resize": true, "import": true, "export": true,
+	"enable": true, "disable": true, "apply": true, "run": true, "submit": true,
+	"cancel": true, "rollback": true, "ssh": true, "scp": true, "restore": true,
+	"upgrade": true, "move": true, "attach": true, "detach": true, "activate": true,
+	"revoke": true, "login": true, "add-iam-policy-binding": true,
+	"remove-iam-policy-binding": true, "set-iam-policy": true,
+}
+
+// gcloudValueFlags are the global gcloud flags that may come before the
+// command with a separate value.
+var gcloudValueFlags = map[string]bool{
+	"--project":                     true,
+	"--account":                     true,
+	"--configuration":               true,
+	"--billing-project":             true,
+	"--impersonate-service-account": true,
+	"--verbosity":                   true,
+	"--format":                      true,
+}
+
+// gcloudBoolFlags are the global gcloud flags that take no value.
+var gcloudBoolFlags = map[string]bool{
+	"-q":      true,
+	"--quiet": true,
+}
+
+// skipFlag checks a flag before the command and returns how many more
+// arguments it takes. Flags that are not known fail the check, since their
+// value could be mistaken for the command.
+func skipFlag(arg string, valueFlags, boolFlags map[string]bool) (int, bool) {
+	name, _, hasValue := strings.Cut(arg, "=")
+	switch {
+	case boolFlags[name]:
+		return 0, true
+	case valueFlags[name]:
+		if hasValue {
+			return 0, true
+		}
+		return 1, true
+	case !strings.HasPrefix(arg, "--") && len(arg) > 2 && valueFlags[arg[:2]]:
+		// a short flag with its value attached, e.g. -npayments
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// kubectlSubcommand returns the kubectl subcommand of the given arguments
+// and the word following it, such as "rollout" and "status". It returns no
+// subcommand when an unknown flag comes before it, since the flag's value
+// could be taken for the subcommand.
+func kubectlSubcommand(args []string) (string, string) {
+	var words []string
+	for i := 0; i < len(args) && len(words) < 2; i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			words = append(words, arg)
+			continue
+		}
+		skip, ok := skipFlag(arg, kubectlValueFlags, kubectlBoolFlags)
+		if !ok && len(words) == 0 {
+			return "", ""
+		}
+		i += skip
+	}
+	switch len(words) {
+	case 0:
+		return "", ""
+	case 1:
+		return words[0], ""
+	default:
+		return words[0], words[1]
+	}
+}
+
+// gcloudReadOnly reports whether gcloud arguments only read state. The
+// command path runs up to the first flag, and its first verb decides.
+// Unknown flags before the command fail the check.
+func gcloudReadOnly(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			skip, ok := skipFlag(arg, gcloudValueFlags, gcloudBoolFlags)
+			if !ok {
+				return false
+			}
+			i += skip
+			continue
+		}
+		switch {
+		case gcloudMutatingVerbs[arg]:
+			return false
+		case gcloudReadOnlyVerbs[arg]:
+			return true
+		}
+	}
+	return false
+}
+
+// IsReadOnlyCommand reports whether the command for the given tool only
+// reads state. Unknown tools and commands are treated as mutating.
+func IsReadOnlyCommand(tool, command string) bool {
+	args := strings.Fields(strings.TrimPrefix(strings.TrimSpace(command), tool+" "))
+	switch tool {
+	case "kubectl":
+		verb, sub := kubectlSubcommand(args)
+		return kubectlReadOnly[verb] || kubectlReadOnly[verb+" "+sub]
+	case "gcloud":
+		return gcloudReadOnly(args)
+	default:
+		return false
+	}
+}
+
+// IsReadOnlyCall reports whether a function call requested by the model only
+// reads state and can run without asking the user for approval.
+func IsReadOnlyCall(fnCall gollm.FunctionCall) bool {
+	command, ok := fnCall.Arguments["command"].(string)
+	if !ok {
+		return false
+	}
+	return IsReadOnlyCommand(fnCall.Name, command)
+}
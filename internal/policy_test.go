@@ -0,0 +1,67 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsReadOnlyCommand checks the classification of kubectl and gcloud
+// commands into read-only ones and ones that need approval.
+func TestIsReadOnlyCommand(t *testing.T) {
+	tests := []struct {
+		tool     string
+		command  string
+		readOnly bool
+	}{
+		{"kubectl", "get pods", true},
+		{"kubectl", "kubectl get pods -A", true},
+		{"kubectl", "-n payments describe deploy api", true},
+		{"kubectl", "--context prod logs api-0", true},
+		{"kubectl", "rollout status deploy/api", true},
+		{"kubectl", "config view", true},
+		{"kubectl", "delete pod api-0", false},
+		{"kubectl", "apply -f deploy.yaml", false},
+		{"kubectl", "rollout restart deploy/api", false},
+		{"kubectl", "config use-context prod", false},
+		{"kubectl", "", false},
+		{"kubectl", "-npayments get pods", true},
+		{"kubectl", "--namespace=payments get pods", true},
+		{"kubectl", "--token abc get pods", true},
+		{"kubectl", "get -l app=api pods", true},
+		{"kubectl", "--token get delete ns prod", false},
+		{"kubectl", "--cache-dir get delete ns prod", false},
+		{"kubectl", "-v get delete ns prod", false},
+		{"kubectl", "--selector get delete ns prod", false},
+		{"kubectl", "--unknown-flag get delete ns prod", false},
+		{"gcloud", "container clusters list", true},
+		{"gcloud", "gcloud config get-value project", true},
+		{"gcloud", "compute instances delete vm-1", false},
+		{"gcloud", "--project prod compute instances list", true},
+		{"gcloud", "compute instances describe delete --zone x", true},
+		{"gcloud", "compute instances delete info --zone x", false},
+		{"gcloud", "sql instances delete list", false},
+		{"gcloud", "--flatten list sql instances delete db", false},
+		{"helm", "list", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tool+" "+tt.command, func(t *testing.T) {
+			assert.Equal(t, tt.readOnly, IsReadOnlyCommand(tt.tool, tt.command))
+		})
+	}
+}
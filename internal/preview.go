@@ -0,0 +1,132 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// maxPreviewLines is how much of a diff is shown and sent to the model.
+const maxPreviewLines = 100
+
+// previewedKubectl lists the mutating kubectl subcommands whose changes are
+// previewed before asking for approval.
+var previewedKubectl = map[string]bool{
+	"apply":     true,
+	"patch":     true,
+	"scale":     true,
+	"set image": true,
+}
+
+// Preview shows what a mutating kubectl call would change, as a diff against
+// the live objects. apply is previewed with kubectl diff, patch, scale and set
+// image with a server-side dry run whose result is diffed. Other calls have no
+// preview and return "".
+func (e *Executor) Preview(ctx context.Context, fnCall gollm.FunctionCall) (string, error) {
+	command, _ := fnCall.Arguments["command"].(string)
+	if fnCall.Name != "kubectl" {
+		return "", nil
+	}
+	args := strings.Fields(strings.TrimPrefix(strings.TrimSpace(command), "kubectl "))
+	verb, sub := kubectlSubcommand(args)
+	if !previewedKubectl[verb] && !previewedKubectl[verb+" "+sub] {
+		return "", nil
+	}
+
+	var diff string
+	var err error
+	if verb == "apply" {
+		diff, err = e.kubectlDiff(ctx, replaceArg(args, "apply", "diff"), "")
+	} else {
+		var object string
+		object, err = runKubectl(ctx, e.Cluster.PinKubectlArgs(dryRunArgs(args)), "")
+		if err == nil {
+			diff, err = e.kubectlDiff(ctx, []string{"diff", "-f", "-"}, object)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("previewing %s: %w", command, err)
+	}
+	if strings.TrimSpace(diff) == "" {
+		return "No changes.", nil
+	}
+	return truncateLines(diff, maxPreviewLines), nil
+}
+
+// kubectlDiff runs kubectl diff, which exits with 1 when there are differences.
+func (e *Executor) kubectlDiff(ctx context.Context, args []string, stdin string) (string, error) {
+	diff, err := runKubectl(ctx, e.Cluster.PinKubectlArgs(args), stdin)
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return diff, nil
+	}
+	return diff, err
+}
+
+// runKubectl runs kubectl and returns its standard output. Standard error
+// is only included in the error.
+func runKubectl(ctx context.Context, args []string, stdin string) (string, error) {
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), err
+}
+
+// dryRunArgs turns a mutating command into a server-side dry run printing
+// the resulting object as YAML.
+func dryRunArgs(args []string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "--output":
+			i++
+		case strings.HasPrefix(arg, "-o=") || strings.HasPrefix(arg, "--output=") || strings.HasPrefix(arg, "--dry-run"):
+		default:
+			out = append(out, arg)
+		}
+	}
+	return append(out, "--dry-run=server", "-o", "yaml")
+}
+
+// replaceArg returns args with the first from replaced by to.
+func replaceArg(args []string, from, to string) []string {
+	out := slices.Clone(args)
+	if i := slices.Index(out, from); i >= 0 {
+		out[i] = to
+	}
+	return out
+}
+
+// truncateLines keeps the first n lines of text and says how many were left out.
+func truncateLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) <= n {
+		return strings.Join(lines, "\n")
+	}
+	return strings.Join(lines[:n], "\n") + fmt.Sprintf("\n... %d more lines", len(lines)-n)
+}
@@ -0,0 +1,125 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// previewKubectl is a fake kubectl that logs its calls to $KUBECTL_LOG. It
+// answers dry runs with an object and diffs with a change of replicas.
+const previewKubectl = `echo "$*" >> "$KUBECTL_LOG"
+case "$*" in
+*missing.yaml*) echo "error: missing.yaml does not exist" >&2; exit 2;;
+*--dry-run=server*) printf 'kind: Deployment\nspec:\n  replicas: 3\n';;
+"diff -f -") cat >> "$KUBECTL_LOG"; printf -- '--- live\n+++ merged\n@@ -1 +1 @@\n-  replicas: 1\n+  replicas: 3\n'; exit 1;;
+"diff -f same.yaml") exit 0;;
+"diff -f db.yaml") printf -- '-  password: hunter1\n+  password: hunter2\n'; exit 1;;
+diff*) printf -- '-  image: web:1\n+  image: web:2\n'; exit 1;;
+esac
+`
+
+// TestPreview previews mutating kubectl commands with a diff.
+func TestPreview(t *testing.T) {
+	fakeKubectl(t, previewKubectl)
+	tests := []struct {
+		command string
+		want    string
+		calls   string
+		wantErr string
+	}{
+		{
+			command: "kubectl apply -f web.yaml",
+			want:    "-  image: web:1\n+  image: web:2",
+			calls:   "diff -f web.yaml\n",
+		},
+		{
+			command: "scale deployment web --replicas=3 -o name",
+			want:    "--- live\n+++ merged\n@@ -1 +1 @@\n-  replicas: 1\n+  replicas: 3",
+			calls:   "scale deployment web --replicas=3 --dry-run=server -o yaml\ndiff -f -\nkind: Deployment\nspec:\n  replicas: 3\n",
+		},
+		{
+			command: "set image deployment/web app=web:2",
+			want:    "--- live\n+++ merged\n@@ -1 +1 @@\n-  replicas: 1\n+  replicas: 3",
+			calls:   "set image deployment/web app=web:2 --dry-run=server -o yaml\ndiff -f -\nkind: Deployment\nspec:\n  replicas: 3\n",
+		},
+		{command: "apply -f same.yaml", want: "No changes.", calls: "diff -f same.yaml\n"},
+		{command: "apply -f missing.yaml", wantErr: "missing.yaml does not exist", calls: "diff -f missing.yaml\n"},
+		{command: "delete pod web-0"},
+		{command: "set env deployment/web A=b"},
+	}
+	for _, tt := range tests {
+		log := filepath.Join(t.TempDir(), "kubectl.log")
+		t.Setenv("KUBECTL_LOG", log)
+
+		got, err := NewExecutor().Preview(t.Context(), toolCall("1", "kubectl", tt.command))
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.command)
+		} else {
+			require.NoError(t, err, tt.command)
+			assert.Equal(t, tt.want, got, tt.command)
+		}
+		calls, _ := os.ReadFile(log)
+		assert.Equal(t, tt.calls, string(calls), tt.command)
+	}
+}
+
+// TestPreviewInApproval shows the diff for approval and sends it to the model.
+func TestPreviewInApproval(t *testing.T) {
+	fakeKubectl(t, previewKubectl)
+	t.Setenv("KUBECTL_LOG", filepath.Join(t.TempDir(), "kubectl.log"))
+	h, chat := newFakeHistory(t,
+		callResponse(toolCall("1", "kubectl", "scale deployment web --replicas=3")),
+		textResponse("Left it alone."),
+	)
+	var req ApprovalRequest
+	h.Approve = func(r ApprovalRequest) bool {
+		req = r
+		return false
+	}
+
+	h.ChatLoop("scale web to 3")
+	assert.Contains(t, req.Preview, "+  replicas: 3")
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	result := sent[1][0].(gollm.FunctionCallResult)
+	assert.Equal(t, req.Preview, result.Result["diff"])
+	assert.Contains(t, result.Result["error"], "declined")
+}
+
+// TestPreviewRedacted masks secrets in the diff sent to the model.
+func TestPreviewRedacted(t *testing.T) {
+	fakeKubectl(t, previewKubectl)
+	t.Setenv("KUBECTL_LOG", filepath.Join(t.TempDir(), "kubectl.log"))
+	executor := NewExecutor()
+	executor.Approve = func(ApprovalRequest) bool { return false }
+
+	_, preview, err := executor.CallWithPreview(t.Context(), toolCall("1", "kubectl", "apply -f db.yaml"))
+	assert.ErrorIs(t, err, ErrDeclined)
+	assert.Equal(t, "-  password: [REDACTED]\n+  password: [REDACTED]", preview)
+}
+
+// TestTruncateLines keeps long diffs short.
+func TestTruncateLines(t *testing.T) {
+	assert.Equal(t, "a\nb", truncateLines("a\nb\n", 2))
+	assert.Equal(t, "a\nb\n... 2 more lines", truncateLines(strings.Join([]string{"a", "b", "c", "d"}, "\n"), 2))
+}
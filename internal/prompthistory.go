@@ -0,0 +1,254 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultPromptHistorySize is how many prompts are kept when the configuration does not say.
+const defaultPromptHistorySize = 1000
+
+// PromptHistory remembers the prompts sent from the input box across sessions.
+// The file holds one JSON string per line, oldest first, so that multi-line
+// prompts survive.
+type PromptHistory struct {
+	path    string
+	size    int
+	entries []string
+}
+
+// PromptHistoryPath returns the default location of the prompt history, next to the config file.
+func PromptHistoryPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "history.jsonl")
+}
+
+// LoadPromptHistory reads the prompt history at path, keeping at most size
+// entries. A missing file starts an empty history, and an empty path keeps
+// the history in memory only.
+func LoadPromptHistory(path string, size int) (*PromptHistory, error) {
+	if size <= 0 {
+		size = defaultPromptHistorySize
+	}
+	h := &PromptHistory{path: path, size: size}
+	if path == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry string
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("reading prompt history %s: %w", path, err)
+		}
+		h.entries = append(h.entries, entry)
+	}
+	if len(h.entries) > size {
+		h.entries = h.entries[len(h.entries)-size:]
+	}
+	return h, scanner.Err()
+}
+
+// Entries returns the prompts, oldest first.
+func (h *PromptHistory) Entries() []string {
+	return h.entries
+}
+
+// Add records a prompt as the newest entry. An earlier copy of the same
+// prompt is dropped, and the oldest entries go once the history is full.
+func (h *PromptHistory) Add(prompt string) error {
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return nil
+	}
+	h.entries = slices.DeleteFunc(h.entries, func(entry string) bool { return entry == prompt })
+	h.entries = append(h.entries, prompt)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+	return h.save()
+}
+
+// save rewrites the history file.
+func (h *PromptHistory) save() error {
+	if h.path == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, entry := range h.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o700); err != nil {
+		return err
+	}
+	// write a new file and rename it so a crash never leaves half a history
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// Search returns the index of the newest entry before index before that
+// contains query, like a shell's reverse-i-search.
+func (h *PromptHistory) Search(query string, before int) (int, bool) {
+	for i := min(before, len(h.entries)) - 1; i >= 0; i-- {
+		if strings.Contains(h.entries[i], query) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// promptSearch is the state of a Ctrl+R search through the prompt history.
+type promptSearch struct {
+	query string
+	// match is the index of the shown entry, valid if found is set.
+	match int
+	found bool
+	// original is the input before the search, restored when it is cancelled.
+	original string
+}
+
+// recallPrompt replaces the input with an older (Up) or newer (Down) prompt
+// from the history. Like in a shell, the prompt being typed is kept and comes
+// back after the newest entry. It returns false when the key should move the
+// cursor instead, which it does unless the cursor is on the first line for Up
+// or the last line for Down.
+func (doc *Document) recallPrompt(older bool) bool {
+	entries := doc.prompts.Entries()
+	doc.recall = min(doc.recall, len(entries))
+	if older {
+		if doc.input.Line() > 0 || doc.recall == 0 {
+			return false
+		}
+		if doc.recall == len(entries) {
+			doc.draft = doc.input.Value()
+		}
+		doc.recall--
+		doc.input.SetValue(entries[doc.recall])
+	} else {
+		if doc.input.Line() < doc.input.LineCount()-1 || doc.recall >= len(entries) {
+			return false
+		}
+		doc.recall++
+		if doc.recall == len(entries) {
+			doc.input.SetValue(doc.draft)
+		} else {
+			doc.input.SetValue(entries[doc.recall])
+		}
+	}
+	doc.resizeInput()
+	return true
+}
+
+// rememberPrompt adds a sent prompt to the history and starts the next prompt afresh.
+func (doc *Document) rememberPrompt(prompt string) {
+	if err := doc.prompts.Add(prompt); err != nil {
+		doc.AddBlock(Block{Text: fmt.Sprintf("Error saving the prompt history: %v", err), Type: ErrorBlock})
+	}
+	doc.recall = len(doc.prompts.Entries())
+	doc.draft = ""
+}
+
+// searchPrompts handles a key while the reverse search is active, or Ctrl+R
+// starting it. Typing narrows the search, Ctrl+R again goes to an older
+// match, Enter or any other key keeps the match in the input, and Esc or
+// Ctrl+G go back to what was typed before. It returns false for keys the
+// input should handle after the search ended.
+func (doc *Document) searchPrompts(msg tea.KeyMsg) bool {
+	search := doc.search
+	if search == nil {
+		if msg.Type != tea.KeyCtrlR {
+			return false
+		}
+		doc.search = &promptSearch{original: doc.input.Value()}
+		doc.findPrompt(len(doc.prompts.Entries()))
+		return true
+	}
+
+	switch msg.Type {
+	case tea.KeyCtrlR:
+		before := len(doc.prompts.Entries())
+		if search.found {
+			before = search.match
+		}
+		doc.findPrompt(before)
+	case tea.KeyRunes, tea.KeySpace:
+		search.query += string(msg.Runes)
+		doc.findPrompt(len(doc.prompts.Entries()))
+	case tea.KeyBackspace:
+		runes := []rune(search.query)
+		search.query = string(runes[:max(len(runes)-1, 0)])
+		doc.findPrompt(len(doc.prompts.Entries()))
+	case tea.KeyEsc, tea.KeyCtrlG:
+		doc.input.SetValue(search.original)
+		doc.resizeInput()
+		doc.search = nil
+	case tea.KeyEnter:
+		doc.search = nil
+	default:
+		doc.search = nil
+		return false
+	}
+	return true
+}
+
+// findPrompt shows the newest prompt before index before that matches the
+// search. The input keeps the last match when there is no other.
+func (doc *Document) findPrompt(before int) {
+	search := doc.search
+	match, found := doc.prompts.Search(search.query, before)
+	if !found {
+		search.found = search.found && strings.Contains(doc.prompts.Entries()[search.match], search.query)
+		return
+	}
+	search.match, search.found = match, true
+	doc.recall = match
+	doc.input.SetValue(doc.prompts.Entries()[match])
+	doc.resizeInput()
+}
+
+// searchPrompt renders the reverse search line shown in place of the input.
+func (doc *Document) searchPrompt() string {
+	label := "reverse-i-search"
+	if !doc.search.found && doc.search.query != "" {
+		label = "failing reverse-i-search"
+	}
+	return fmt.Sprintf("(%s)`%s': %s", label, doc.search.query, doc.input.Value())
+}
@@ -0,0 +1,107 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPromptHistory keeps prompts across loads, without duplicates and within its size.
+func TestPromptHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bubblechat", "history.jsonl")
+	h, err := LoadPromptHistory(path, 3)
+	require.NoError(t, err)
+	assert.Empty(t, h.Entries())
+
+	for _, prompt := range []string{"get pods", "why is\nit crashing?", "get pods", "  ", "logs", "events"} {
+		require.NoError(t, h.Add(prompt))
+	}
+	assert.Equal(t, []string{"get pods", "logs", "events"}, h.Entries())
+
+	h, err = LoadPromptHistory(path, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"logs", "events"}, h.Entries())
+
+	require.NoError(t, h.Add("get pods"))
+	assert.Equal(t, []string{"events", "get pods"}, h.Entries())
+	tests := []struct {
+		query  string
+		before int
+		want   int
+		found  bool
+	}{
+		{"e", 2, 1, true},
+		{"e", 1, 0, true},
+		{"vent", 2, 0, true},
+		{"e", 0, 0, false},
+		{"nodes", 2, 0, false},
+	}
+	for _, tt := range tests {
+		got, found := h.Search(tt.query, tt.before)
+		assert.Equal(t, tt.found, found, "%q before %d", tt.query, tt.before)
+		assert.Equal(t, tt.want, got, "%q before %d", tt.query, tt.before)
+	}
+}
+
+// press sends a key press to the document.
+func press(doc *Document, msg tea.KeyMsg) {
+	doc.Update(msg)
+}
+
+// TestRecallPrompts walks the history with Up and Down and searches it with Ctrl+R.
+func TestRecallPrompts(t *testing.T) {
+	doc, _ := newTestDoc(t)
+	for _, prompt := range []string{"/model", "/namespace", "/pin two\nlines"} {
+		send(doc, prompt)
+	}
+
+	doc.input.SetValue("draft")
+	press(doc, tea.KeyMsg{Type: tea.KeyUp})
+	assert.Equal(t, "/pin two\nlines", doc.input.Value())
+	// Up moves to the first line of a multi-line prompt before recalling
+	press(doc, tea.KeyMsg{Type: tea.KeyUp})
+	assert.Equal(t, "/pin two\nlines", doc.input.Value())
+	press(doc, tea.KeyMsg{Type: tea.KeyUp})
+	assert.Equal(t, "/namespace", doc.input.Value())
+	press(doc, tea.KeyMsg{Type: tea.KeyUp})
+	press(doc, tea.KeyMsg{Type: tea.KeyUp})
+	assert.Equal(t, "/model", doc.input.Value())
+	press(doc, tea.KeyMsg{Type: tea.KeyDown})
+	press(doc, tea.KeyMsg{Type: tea.KeyDown})
+	press(doc, tea.KeyMsg{Type: tea.KeyDown})
+	assert.Equal(t, "draft", doc.input.Value())
+
+	press(doc, tea.KeyMsg{Type: tea.KeyCtrlR})
+	press(doc, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	assert.Equal(t, "/namespace", doc.input.Value())
+	press(doc, tea.KeyMsg{Type: tea.KeyCtrlR})
+	assert.Equal(t, "/model", doc.input.Value())
+	press(doc, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	assert.Contains(t, doc.View(), "(failing reverse-i-search)`mx': /model")
+	press(doc, tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Nil(t, doc.search)
+	assert.Equal(t, "draft", doc.input.Value())
+
+	press(doc, tea.KeyMsg{Type: tea.KeyCtrlR})
+	press(doc, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("pin")})
+	press(doc, tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Nil(t, doc.search)
+	assert.Equal(t, "/pin two\nlines", doc.input.Value(), "Enter keeps the match for editing")
+}
@@ -0,0 +1,271 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ErrBlocked is returned for commands that print credentials when those are blocked.
+var ErrBlocked = errors.New("the command prints credentials")
+
+// redacted replaces every secret removed from tool output.
+const redacted = "[REDACTED]"
+
+// RedactionPattern is a user-defined secret pattern from the configuration.
+// The whole match is masked, or only the first capture group if there is one.
+type RedactionPattern struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// RedactionConfig configures what is removed from tool output before it is sent to the model.
+type RedactionConfig struct {
+	// Patterns are masked in addition to the built-in ones.
+	Patterns []RedactionPattern `yaml:"patterns"`
+	// BlockTokenCommands refuses commands that print access tokens or credentials.
+	BlockTokenCommands bool `yaml:"blockTokenCommands"`
+}
+
+// Redaction counts what a rule removed from an output.
+type Redaction struct {
+	Rule  string
+	Count int
+}
+
+type redactionRule struct {
+	name string
+	// apply masks the secrets in text and returns how many it masked.
+	apply func(text string) (string, int)
+	// secretsOnly rules only apply to output containing Kubernetes Secrets.
+	secretsOnly bool
+}
+
+// regexpRule masks matches of re, or only their first capture group if there is one.
+func regexpRule(name string, re *regexp.Regexp) redactionRule {
+	return redactionRule{name: name, apply: func(text string) (string, int) {
+		count := 0
+		text = re.ReplaceAllStringFunc(text, func(match string) string {
+			count++
+			sub := re.FindStringSubmatchIndex(match)
+			if len(sub) < 4 || sub[2] < 0 {
+				return redacted
+			}
+			return match[:sub[2]] + redacted + match[sub[3]:]
+		})
+		return text, count
+	}}
+}
+
+// builtinRedactions are always applied.
+var builtinRedactions = []redactionRule{
+	{name: "Secret data", apply: redactYAMLData, secretsOnly: true},
+	{name: "Secret data", apply: redactJSONData, secretsOnly: true},
+	// client-key-data and the like of kubectl config view --raw
+	regexpRule("kubeconfig data", regexp.MustCompile(`\b[\w-]+-data"?[ \t]*:[ \t]*"?([A-Za-z0-9+/]{16,}=*)`)),
+	regexpRule("private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)),
+	regexpRule("bearer token", regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`)),
+	regexpRule("JWT", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)),
+	regexpRule("Google access token", regexp.MustCompile(`\bya29\.[0-9A-Za-z\-_]+`)),
+	regexpRule("credential", regexp.MustCompile(`(?i)\b[\w.-]*(?:password|passwd|secret|token|api_?key)["']?[ \t]*[:=][ \t]*["']?([^\s"'<\[][^\s"']*)`)),
+}
+
+var (
+	// secretKind detects Kubernetes Secrets in YAML and JSON output.
+	secretKind = regexp.MustCompile(`"?kind"?:\s*"?Secret\b`)
+	// yamlDataKey starts the data of a Secret in YAML.
+	yamlDataKey = regexp.MustCompile(`^(\s*)(?:data|stringData):\s*$`)
+	// yamlEntry is a key and value in a YAML mapping.
+	yamlEntry = regexp.MustCompile(`^(\s*[^\s:]+:)\s*\S`)
+	// jsonData is the data of a Secret in JSON, possibly escaped inside a string
+	// such as the last-applied-configuration annotation.
+	jsonData = regexp.MustCompile(`\\?"(?:data|stringData)\\?"\s*:\s*\{[^{}]*\}`)
+	// jsonEntry is a key and string value in a JSON object.
+	jsonEntry = regexp.MustCompile(`(\\?"[^"\\]+\\?"\s*:\s*)(\\?")[^"\\]*(\\?")`)
+)
+
+// redactYAMLData masks the values under data and stringData keys, keeping the keys.
+func redactYAMLData(text string) (string, int) {
+	lines := strings.Split(text, "\n")
+	count := 0
+	for i := 0; i < len(lines); i++ {
+		m := yamlDataKey.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		indent := len(m[1])
+		for i+1 < len(lines) && indentOf(lines[i+1]) > indent {
+			i++
+			if entry := yamlEntry.FindStringSubmatch(lines[i]); entry != nil {
+				lines[i] = entry[1] + " " + redacted
+				count++
+			} else {
+				// continuation of a multi-line value
+				lines[i] = strings.Repeat(" ", indentOf(lines[i])) + redacted
+			}
+		}
+	}
+	return strings.Join(lines, "\n"), count
+}
+
+// indentOf returns the indentation of a line, or 0 for a blank line.
+func indentOf(line string) int {
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" {
+		return 0
+	}
+	return len(line) - len(trimmed)
+}
+
+// redactJSONData masks the values of data and stringData objects, keeping the keys.
+func redactJSONData(text string) (string, int) {
+	count := 0
+	text = jsonData.ReplaceAllStringFunc(text, func(data string) string {
+		return jsonEntry.ReplaceAllStringFunc(data, func(entry string) string {
+			m := jsonEntry.FindStringSubmatch(entry)
+			count++
+			return m[1] + m[2] + redacted + m[3]
+		})
+	})
+	return text, count
+}
+
+// Redactor masks secrets in tool output.
+type Redactor struct {
+	rules []redactionRule
+}
+
+// NewRedactor creates a redactor applying the built-in rules and the given patterns.
+func NewRedactor(patterns []RedactionPattern) (*Redactor, error) {
+	r := &Redactor{rules: slices.Clone(builtinRedactions)}
+	var errs []error
+	for _, p := range patterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("redaction pattern %q: %w", p.Name, err))
+			continue
+		}
+		name := p.Name
+		if name == "" {
+			name = p.Pattern
+		}
+		r.rules = append(r.rules, regexpRule(name, re))
+	}
+	return r, errors.Join(errs...)
+}
+
+// Redact masks secrets in text and reports what was removed.
+func (r *Redactor) Redact(text string) (string, []Redaction) {
+	var redactions []Redaction
+	secrets := secretKind.MatchString(text)
+	for _, rule := range r.rules {
+		if rule.secretsOnly && !secrets {
+			continue
+		}
+		var count int
+		text, count = rule.apply(text)
+		if count == 0 {
+			continue
+		}
+		if i := slices.IndexFunc(redactions, func(r Redaction) bool { return r.Rule == rule.name }); i >= 0 {
+			redactions[i].Count += count
+		} else {
+			redactions = append(redactions, Redaction{Rule: rule.name, Count: count})
+		}
+	}
+	return text, redactions
+}
+
+// DescribeRedactions summarizes redactions for the user, e.g. "2 × bearer token, 1 × private key".
+func DescribeRedactions(redactions []Redaction) string {
+	parts := make([]string, 0, len(redactions))
+	for _, r := range redactions {
+		parts = append(parts, fmt.Sprintf("%d × %s", r.Count, r.Rule))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// templateFormats are the kubectl output formats printing fields picked by a
+// template, without the kind that tells the redactor it is looking at a Secret.
+var templateFormats = []string{
+	"jsonpath", "jsonpath-as-json", "jsonpath-file",
+	"go-template", "go-template-file", "template", "templatefile",
+	"custom-columns", "custom-columns-file",
+}
+
+// IsUnredactableCommand reports whether a command reads Secrets in a format
+// whose values cannot be told apart from other output, such as kubectl get
+// secret -o jsonpath, and returns the format.
+func IsUnredactableCommand(tool, command string) (string, bool) {
+	if tool != "kubectl" {
+		return "", false
+	}
+	args := strings.Fields(strings.TrimPrefix(strings.TrimSpace(command), tool+" "))
+	if i := slices.Index(args, "--"); i >= 0 {
+		args = args[:i]
+	}
+	if verb, _ := kubectlSubcommand(args); verb != "get" {
+		return "", false
+	}
+	output, _ := flagValue(args, "-o", "--output")
+	format, _, _ := strings.Cut(output, "=")
+	if _, ok := flagValue(args, "--template"); ok && format == "" {
+		format = "go-template"
+	}
+	if !slices.Contains(templateFormats, format) {
+		return "", false
+	}
+	// any word naming Secrets counts, even one that may be an object's name,
+	// since the words after get are not parsed
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		for _, resource := range strings.Split(strings.ToLower(arg), ",") {
+			resource, _, _ = strings.Cut(resource, "/")
+			resource, _, _ = strings.Cut(resource, ".")
+			if resource == "secret" || resource == "secrets" {
+				return format, true
+			}
+		}
+	}
+	return "", false
+}
+
+// IsTokenCommand reports whether a command prints access tokens or other credentials.
+func IsTokenCommand(tool, command string) bool {
+	args := strings.Fields(strings.TrimPrefix(strings.TrimSpace(command), tool+" "))
+	switch tool {
+	case "kubectl":
+		verb, sub := kubectlSubcommand(args)
+		switch {
+		case verb == "create" && sub == "token":
+			return true
+		case verb == "config" && sub == "view":
+			return slices.Contains(args, "--raw")
+		}
+		return false
+	case "gcloud":
+		return slices.ContainsFunc(args, func(arg string) bool {
+			return arg == "print-access-token" || arg == "print-identity-token" || arg == "config-helper"
+		})
+	default:
+		return false
+	}
+}
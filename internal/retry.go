@@ -0,0 +1,188 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// RetryConfig is how often and how patiently failed calls to the model are
+// retried. Zero fields use the values of DefaultRetry.
+type RetryConfig struct {
+	// MaxAttempts is how many times a call is tried in all, 1 disables retries.
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	// BackoffFactor multiplies the wait after every failed attempt.
+	BackoffFactor float64 `yaml:"backoffFactor"`
+}
+
+// DefaultRetry is the retry policy unless configured otherwise.
+var DefaultRetry = RetryConfig{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Second,
+	MaxBackoff:     60 * time.Second,
+	BackoffFactor:  2,
+}
+
+// withDefaults fills in the zero fields from DefaultRetry.
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultRetry.MaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = DefaultRetry.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultRetry.MaxBackoff
+	}
+	if c.BackoffFactor < 1 {
+		c.BackoffFactor = DefaultRetry.BackoffFactor
+	}
+	return c
+}
+
+// backoff returns how long to wait before the given attempt, counting from
+// 2 for the first retry. Up to a fifth is added at random so that several
+// conversations do not retry in lockstep.
+func (c RetryConfig) backoff(attempt int) time.Duration {
+	delay := float64(c.InitialBackoff)
+	for i := 2; i < attempt; i++ {
+		delay *= c.BackoffFactor
+	}
+	delay = min(delay, float64(c.MaxBackoff))
+	return time.Duration(delay + rand.Float64()*delay/5)
+}
+
+// RetryStatus is a failed call to the model waiting to be tried again.
+type RetryStatus struct {
+	// Attempt is the attempt waited for, out of MaxAttempts.
+	Attempt     int
+	MaxAttempts int
+	// Err is why the previous attempt failed.
+	Err error
+	// At is when the next attempt starts.
+	At time.Time
+}
+
+// String describes the retry with a countdown, e.g. "Retrying in 8s
+// (attempt 2 of 3) after: 503 Service Unavailable".
+func (s RetryStatus) String() string {
+	wait := max(time.Until(s.At).Round(time.Second), 0)
+	return fmt.Sprintf("Retrying in %s (attempt %d of %d) after: %v", wait, s.Attempt, s.MaxAttempts, s.Err)
+}
+
+// retryChat is a chat that retries failed calls to the model according to
+// the history's retry policy, showing each retry. Cancelling the context
+// stops it at once, and errors the model reports as not retryable are
+// returned right away with a hint.
+type retryChat struct {
+	gollm.Chat
+	h *History
+}
+
+// Send sends contents to the model, retrying when that fails.
+func (c *retryChat) Send(ctx context.Context, contents ...any) (gollm.ChatResponse, error) {
+	config := c.h.Retry.withDefaults()
+	for attempt := 1; ; attempt++ {
+		resp, err := c.Chat.Send(ctx, contents...)
+		switch {
+		case err == nil:
+			return resp, nil
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case !c.Chat.IsRetryableError(err):
+			return nil, notRetryable(err)
+		case attempt == config.MaxAttempts:
+			if attempt == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		status := RetryStatus{Attempt: attempt + 1, MaxAttempts: config.MaxAttempts, Err: err, At: time.Now().Add(config.backoff(attempt + 1))}
+		c.h.AddBlock(Block{Text: fmt.Sprintf("The model call failed: %v. Retrying (attempt %d of %d).", err, status.Attempt, status.MaxAttempts), Type: ErrorBlock})
+		if err := c.h.waitRetry(ctx, status); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitRetry shows the retry until it is due, updating the countdown every
+// second, and returns early with the context's error when it is cancelled.
+func (h *History) waitRetry(ctx context.Context, status RetryStatus) error {
+	h.setRetry(&status)
+	defer h.setRetry(nil)
+
+	timer := time.NewTimer(time.Until(status.At))
+	defer timer.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case <-ticker.C:
+			if h.OnProgress != nil {
+				h.OnProgress()
+			}
+		}
+	}
+}
+
+// setRetry records the retry being waited for and notifies OnProgress.
+func (h *History) setRetry(status *RetryStatus) {
+	h.mu.Lock()
+	h.retry = status
+	h.mu.Unlock()
+	if h.OnProgress != nil {
+		h.OnProgress()
+	}
+}
+
+// Retrying returns the retry being waited for, or nil.
+func (h *History) Retrying() *RetryStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.retry == nil {
+		return nil
+	}
+	status := *h.retry
+	return &status
+}
+
+// notRetryable explains an error the model will answer the same way however
+// often the call is retried, such as a bad API key or an unknown model.
+func notRetryable(err error) error {
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "api key"), strings.Contains(message, "api_key"),
+		strings.Contains(message, "unauthenticated"), strings.Contains(message, "permission_denied"),
+		strings.Contains(message, "401"), strings.Contains(message, "403"):
+		return fmt.Errorf("%w. Retrying will not help, check the API key, e.g. GEMINI_API_KEY", err)
+	case strings.Contains(message, "not found"), strings.Contains(message, "not_found"), strings.Contains(message, "404"):
+		return fmt.Errorf("%w. Retrying will not help, check the model name or switch with /model", err)
+	default:
+		return fmt.Errorf("%w (not retried)", err)
+	}
+}
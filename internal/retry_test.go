@@ -0,0 +1,137 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingHistory returns a history whose model fails with the given errors
+// before it answers.
+func failingHistory(t *testing.T, retryable bool, errs ...error) (*History, *fakeChat) {
+	t.Helper()
+	h, chat := newFakeHistory(t)
+	for _, err := range errs {
+		chat.turns = append(chat.turns, fakeTurn{err: err})
+	}
+	chat.turns = append(chat.turns, fakeTurn{resp: textResponse("All pods are running.")})
+	chat.retryable = retryable
+	return h, chat
+}
+
+// TestRetryBackoff grows the wait between attempts up to the maximum.
+func TestRetryBackoff(t *testing.T) {
+	config := RetryConfig{MaxAttempts: 5, InitialBackoff: time.Second}.withDefaults()
+	assert.Equal(t, 60*time.Second, config.MaxBackoff)
+	for _, tt := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 2, want: time.Second},
+		{attempt: 3, want: 2 * time.Second},
+		{attempt: 5, want: 8 * time.Second},
+		{attempt: 9, want: 60 * time.Second},
+	} {
+		backoff := config.backoff(tt.attempt)
+		assert.GreaterOrEqual(t, backoff, tt.want, "attempt %d", tt.attempt)
+		assert.LessOrEqual(t, backoff, tt.want+tt.want/5, "attempt %d", tt.attempt)
+	}
+
+	status := RetryStatus{Attempt: 2, MaxAttempts: 3, Err: errors.New("503 Service Unavailable"), At: time.Now().Add(8*time.Second + 100*time.Millisecond)}
+	assert.Equal(t, "Retrying in 8s (attempt 2 of 3) after: 503 Service Unavailable", status.String())
+}
+
+// TestRetry retries a failed call, showing the retry while it waits.
+func TestRetry(t *testing.T) {
+	h, chat := failingHistory(t, true, errors.New("503 Service Unavailable"))
+	h.Retry = RetryConfig{InitialBackoff: 10 * time.Millisecond}
+	var mu sync.Mutex
+	var shown []RetryStatus
+	h.OnProgress = func() {
+		if status := h.Retrying(); status != nil {
+			mu.Lock()
+			shown = append(shown, *status)
+			mu.Unlock()
+		}
+	}
+
+	h.ChatLoop("are the pods running?")
+	blocks := h.Snapshot()
+	require.Len(t, blocks, 2)
+	assert.Equal(t, Block{Text: "The model call failed: 503 Service Unavailable. Retrying (attempt 2 of 3).", Type: ErrorBlock}, blocks[0])
+	assert.Equal(t, Block{Text: "All pods are running.", Type: AgentBlock}, blocks[1])
+	assert.Len(t, chat.Sent(), 2)
+	require.NotEmpty(t, shown)
+	assert.Equal(t, 2, shown[0].Attempt)
+	assert.Nil(t, h.Retrying())
+}
+
+// TestRetryGivesUp stops after the configured number of attempts.
+func TestRetryGivesUp(t *testing.T) {
+	h, chat := failingHistory(t, true, errors.New("429 Too Many Requests"), errors.New("429 Too Many Requests"))
+	h.Retry = RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	h.ChatLoop("are the pods running?")
+	assert.Len(t, chat.Sent(), 2)
+	assert.Equal(t, "Error: gave up after 2 attempts: 429 Too Many Requests", lastBlockOf(h).Text)
+}
+
+// TestNotRetryable fails at once on errors retrying does not help with.
+func TestNotRetryable(t *testing.T) {
+	for _, tt := range []struct {
+		err  string
+		want string
+	}{
+		{err: "API key not valid. Please pass a valid API key.", want: "check the API key"},
+		{err: "models/gemini-9 is not found for API version v1beta", want: "check the model name or switch with /model"},
+		{err: "400 invalid argument", want: "400 invalid argument (not retried)"},
+	} {
+		h, chat := failingHistory(t, false, errors.New(tt.err))
+		h.ChatLoop("are the pods running?")
+		assert.Len(t, chat.Sent(), 1)
+		blocks := h.Snapshot()
+		require.Len(t, blocks, 1)
+		assert.Equal(t, ErrorBlock, blocks[0].Type)
+		assert.Contains(t, blocks[0].Text, tt.want)
+	}
+}
+
+// TestCancelTurn stops a turn waiting for a retry right away.
+func TestCancelTurn(t *testing.T) {
+	h, chat := failingHistory(t, true, errors.New("503 Service Unavailable"))
+	h.Retry = RetryConfig{InitialBackoff: time.Hour}
+	assert.False(t, h.CancelTurn())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ChatLoop("are the pods running?")
+	}()
+	require.Eventually(t, func() bool { return h.Retrying() != nil }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, h.CancelTurn())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the turn did not stop")
+	}
+	assert.Equal(t, Block{Text: "Stopped the turn.", Type: ErrorBlock}, lastBlockOf(h))
+	assert.Len(t, chat.Sent(), 1)
+	assert.Nil(t, h.Retrying())
+}
@@ -0,0 +1,341 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	tea "github.com/charmbracelet/bubbletea"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotAllowed is returned for tool calls a runbook does not allow.
+var ErrNotAllowed = errors.New("the tool is not allowed in this runbook")
+
+// Runbook is a saved prompt template for an investigation that is repeated,
+// such as triaging a crash-looping pod. The prompt is a Go text/template
+// filled in with the parameters given to /run.
+type Runbook struct {
+	// Name is what /run is given. It defaults to the file name without extension.
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Params      []RunbookParam `yaml:"params"`
+	// Tools, when set, are the only tools the model may use in the run.
+	Tools []string `yaml:"tools"`
+	// Prompt is the template. In Markdown files it is the text after the front matter.
+	Prompt string `yaml:"prompt"`
+	// Path is the file the runbook was loaded from.
+	Path string `yaml:"-"`
+
+	template *template.Template
+}
+
+// RunbookParam is a parameter of a runbook, used as {{.name}} in its prompt.
+type RunbookParam struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Default is used when the parameter is not given. Parameters without
+	// a default are required.
+	Default string `yaml:"default"`
+}
+
+// RunbooksPath returns the default directory of the runbooks, next to the
+// configuration file.
+func RunbooksPath() string {
+	return filepath.Join(filepath.Dir(ConfigPath()), "runbooks")
+}
+
+// LoadRunbooks reads the runbooks in dir: YAML files, and Markdown files
+// whose front matter holds everything but the prompt. A missing directory
+// has no runbooks. Runbooks that cannot be loaded are reported in the error,
+// the others are returned anyway, sorted by name.
+func LoadRunbooks(dir string) ([]Runbook, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var runbooks []Runbook
+	var errs []error
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || !slices.Contains([]string{".yaml", ".yml", ".md"}, ext) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		runbook, err := loadRunbook(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("runbook %s: %w", path, err))
+			continue
+		}
+		if i := slices.IndexFunc(runbooks, func(r Runbook) bool { return r.Name == runbook.Name }); i >= 0 {
+			errs = append(errs, fmt.Errorf("runbook %s: %s is already defined in %s", path, runbook.Name, runbooks[i].Path))
+			continue
+		}
+		runbooks = append(runbooks, runbook)
+	}
+	slices.SortFunc(runbooks, func(a, b Runbook) int { return strings.Compare(a.Name, b.Name) })
+	return runbooks, errors.Join(errs...)
+}
+
+// loadRunbook reads a runbook file and parses its template.
+func loadRunbook(path string) (Runbook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Runbook{}, err
+	}
+	var runbook Runbook
+	if filepath.Ext(path) == ".md" {
+		frontMatter, body, err := splitFrontMatter(data)
+		if err != nil {
+			return Runbook{}, err
+		}
+		if err := yaml.Unmarshal(frontMatter, &runbook); err != nil {
+			return Runbook{}, err
+		}
+		runbook.Prompt = string(body)
+	} else if err := yaml.Unmarshal(data, &runbook); err != nil {
+		return Runbook{}, err
+	}
+
+	runbook.Path = path
+	if runbook.Name == "" {
+		runbook.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if strings.ContainsAny(runbook.Name, " \t") {
+		return Runbook{}, fmt.Errorf("the name %q contains spaces", runbook.Name)
+	}
+	runbook.Prompt = strings.TrimSpace(runbook.Prompt)
+	if runbook.Prompt == "" {
+		return Runbook{}, errors.New("the prompt is empty")
+	}
+	runbook.template, err = template.New(runbook.Name).Option("missingkey=error").Parse(runbook.Prompt)
+	if err != nil {
+		return Runbook{}, err
+	}
+	return runbook, nil
+}
+
+// splitFrontMatter splits a Markdown file into the YAML between the leading
+// --- lines and the text after them.
+func splitFrontMatter(data []byte) ([]byte, []byte, error) {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	rest, ok := bytes.CutPrefix(data, []byte("---\n"))
+	if !ok {
+		return nil, data, nil
+	}
+	frontMatter, body, ok := bytes.Cut(rest, []byte("\n---\n"))
+	if !ok {
+		return nil, nil, errors.New("the front matter is not closed with ---")
+	}
+	return frontMatter, body, nil
+}
+
+// Usage describes how to run the runbook, e.g. "/run triage-pod name=… [ns=default]".
+func (r Runbook) Usage() string {
+	usage := "/run " + r.Name
+	for _, param := range r.Params {
+		if param.Default == "" {
+			usage += fmt.Sprintf(" %s=…", param.Name)
+		} else {
+			usage += fmt.Sprintf(" [%s=%s]", param.Name, param.Default)
+		}
+	}
+	return usage
+}
+
+// Missing returns the required parameters args leaves out or empty.
+func (r Runbook) Missing(args map[string]string) []RunbookParam {
+	var missing []RunbookParam
+	for _, param := range r.Params {
+		if args[param.Name] == "" && param.Default == "" {
+			missing = append(missing, param)
+		}
+	}
+	return missing
+}
+
+// Render fills in the prompt with args and the defaults of the parameters
+// not given or empty. Unknown and missing parameters are errors.
+func (r Runbook) Render(args map[string]string) (string, error) {
+	data := map[string]string{}
+	for _, param := range r.Params {
+		data[param.Name] = param.Default
+	}
+	for name, value := range args {
+		if _, ok := data[name]; !ok {
+			return "", fmt.Errorf("%s has no parameter %s, see %s", r.Name, name, r.Usage())
+		}
+		if value != "" {
+			data[name] = value
+		}
+	}
+	if missing := r.Missing(args); len(missing) > 0 {
+		names := make([]string, len(missing))
+		for i, param := range missing {
+			names[i] = param.Name
+		}
+		return "", fmt.Errorf("%s needs %s, see %s", r.Name, strings.Join(names, ", "), r.Usage())
+	}
+
+	var sb strings.Builder
+	if err := r.template.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// parseRunArgs parses the name=value arguments of /run.
+func parseRunArgs(args []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not a name=value parameter", arg)
+		}
+		parsed[name] = value
+	}
+	return parsed, nil
+}
+
+// runbook returns the runbook of the document with the given name.
+func (doc *Document) runbook(name string) (Runbook, bool) {
+	i := slices.IndexFunc(doc.runbooks, func(r Runbook) bool { return r.Name == name })
+	if i < 0 {
+		return Runbook{}, false
+	}
+	return doc.runbooks[i], true
+}
+
+// runRunbook handles /run with a runbook name and its parameters. When a
+// required parameter is missing, the command is given back in the input
+// with the parameter to fill in.
+func (doc *Document) runRunbook(args []string) (tea.Cmd, error) {
+	runbook, ok := doc.runbook(args[0])
+	if !ok {
+		return nil, fmt.Errorf("there is no runbook %s, /run lists them", args[0])
+	}
+	params, err := parseRunArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	if missing := runbook.Missing(params); len(missing) > 0 {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s needs:\n", runbook.Name)
+		for _, param := range missing {
+			fmt.Fprintf(&sb, "\n- `%s` %s", param.Name, param.Description)
+		}
+		doc.AddBlock(Block{Text: sb.String(), Type: AgentBlock})
+		given := []string{"/run", runbook.Name}
+		for _, arg := range args[1:] {
+			if !strings.HasSuffix(arg, "=") {
+				given = append(given, arg)
+			}
+		}
+		doc.input.SetValue(strings.Join(append(given, missing[0].Name+"="), " "))
+		doc.resizeInput()
+		return nil, nil
+	}
+	prompt, err := runbook.Render(params)
+	if err != nil {
+		return nil, err
+	}
+	for _, tool := range runbook.Tools {
+		if !slices.ContainsFunc(doc.Definitions(), func(def *gollm.FunctionDefinition) bool { return def.Name == tool }) {
+			return nil, fmt.Errorf("runbook %s allows the unknown tool %s", runbook.Name, tool)
+		}
+	}
+
+	doc.AddBlock(Block{Text: prompt, Type: UserBlock})
+	return doc.background(func() { doc.ChatLoopWithTools(prompt, runbook.Tools) }), nil
+}
+
+// completeRunbook completes the runbook name or a parameter name of a /run
+// command before the cursor. It returns false for other input.
+func (doc *Document) completeRunbook() bool {
+	value := doc.input.Value()
+	if !strings.HasPrefix(value, "/run ") || strings.Contains(value, "\n") {
+		return false
+	}
+	info := doc.input.LineInfo()
+	before := string([]rune(value)[:min(info.StartColumn+info.CharOffset, len([]rune(value)))])
+	fields := strings.Fields(before)
+	word := ""
+	if !strings.HasSuffix(before, " ") {
+		word = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+
+	var candidates []string
+	switch {
+	case len(fields) == 1:
+		for _, runbook := range doc.runbooks {
+			candidates = append(candidates, runbook.Name)
+		}
+	case strings.Contains(word, "="):
+		return true
+	default:
+		runbook, ok := doc.runbook(fields[1])
+		if !ok {
+			return true
+		}
+		given, _ := parseRunArgs(fields[2:])
+		for _, param := range runbook.Params {
+			if _, ok := given[param.Name]; !ok {
+				candidates = append(candidates, param.Name+"=")
+			}
+		}
+	}
+	completion, matching := completeFrom(word, candidates)
+	doc.input.InsertString(completion)
+	doc.completions = matching
+	return true
+}
+
+// ChatLoopWithTools is ChatLoop in which the model may only use the given
+// tools, as a runbook demands. No tools means all of them. The restriction
+// only applies to this turn.
+func (h *History) ChatLoopWithTools(query string, tools []string) {
+	h.chatLoop(query, tools)
+}
+
+// toolAllowed reports whether a turn restricted to tools may use the named
+// tool. No tools means all of them.
+func toolAllowed(tools []string, name string) bool {
+	return len(tools) == 0 || slices.Contains(tools, name)
+}
+
+// allowedDefinitions returns the definitions of the tools a turn restricted
+// to tools may use.
+func (h *History) allowedDefinitions(tools []string) []*gollm.FunctionDefinition {
+	var defs []*gollm.FunctionDefinition
+	for _, def := range h.Definitions() {
+		if toolAllowed(tools, def.Name) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
@@ -0,0 +1,209 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const triagePodRunbook = `---
+description: Find out why a pod keeps crashing.
+params:
+  - name: pod
+    description: name of the pod
+  - name: namespace
+    default: default
+tools: [k8s_get, k8s_list]
+---
+Pod {{.pod}} in namespace {{.namespace}} is crash-looping. Find out why.
+`
+
+const certExpiryRunbook = `name: cert-expiry
+description: Check when the certificate of an ingress expires.
+params:
+  - name: ingress
+prompt: When does the TLS certificate of ingress {{.ingress}} expire?
+`
+
+// writeRunbooks writes runbook files to a new directory.
+func writeRunbooks(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	return dir
+}
+
+// TestLoadRunbooks loads YAML and Markdown runbooks, reporting the broken ones.
+func TestLoadRunbooks(t *testing.T) {
+	dir := writeRunbooks(t, map[string]string{
+		"triage-pod.md":   triagePodRunbook,
+		"certs.yaml":      certExpiryRunbook,
+		"notes.txt":       "not a runbook",
+		"broken.yml":      "prompt: '{{.pod'",
+		"empty.md":        "---\ndescription: nothing\n---\n",
+		"unclosed.md":     "---\ndescription: nothing\n",
+		"cert-copy.yaml":  certExpiryRunbook,
+		"with space.yaml": "prompt: hello",
+	})
+	runbooks, err := LoadRunbooks(dir)
+	require.Len(t, runbooks, 2)
+	assert.Equal(t, "cert-expiry", runbooks[0].Name)
+	assert.Equal(t, "triage-pod", runbooks[1].Name)
+	assert.Equal(t, []string{"k8s_get", "k8s_list"}, runbooks[1].Tools)
+	assert.Equal(t, "/run triage-pod pod=… [namespace=default]", runbooks[1].Usage())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken.yml: template: broken")
+	assert.Contains(t, err.Error(), "empty.md: the prompt is empty")
+	assert.Contains(t, err.Error(), "unclosed.md: the front matter is not closed")
+	assert.Contains(t, err.Error(), "cert-expiry is already defined in")
+	assert.Contains(t, err.Error(), `the name "with space" contains spaces`)
+
+	runbooks, err = LoadRunbooks(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Empty(t, runbooks)
+}
+
+// TestRenderRunbook fills in the parameters and their defaults.
+func TestRenderRunbook(t *testing.T) {
+	runbooks, err := LoadRunbooks(writeRunbooks(t, map[string]string{"triage-pod.md": triagePodRunbook}))
+	require.NoError(t, err)
+	runbook := runbooks[0]
+
+	prompt, err := runbook.Render(map[string]string{"pod": "api-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "Pod api-1 in namespace default is crash-looping. Find out why.", prompt)
+	prompt, err = runbook.Render(map[string]string{"pod": "api-1", "namespace": "payments"})
+	require.NoError(t, err)
+	assert.Equal(t, "Pod api-1 in namespace payments is crash-looping. Find out why.", prompt)
+
+	_, err = runbook.Render(map[string]string{"pod": ""})
+	assert.EqualError(t, err, "triage-pod needs pod, see /run triage-pod pod=… [namespace=default]")
+	_, err = runbook.Render(map[string]string{"pod": "api-1", "ns": "payments"})
+	assert.EqualError(t, err, "triage-pod has no parameter ns, see /run triage-pod pod=… [namespace=default]")
+
+	_, err = parseRunArgs([]string{"pod=api-1", "payments"})
+	assert.EqualError(t, err, `"payments" is not a name=value parameter`)
+}
+
+// TestRunbookCommand asks for missing parameters, then runs the runbook with
+// only the tools it allows.
+func TestRunbookCommand(t *testing.T) {
+	doc, chat := newTestDoc(t,
+		callResponse(toolCall("1", "kubectl", "delete pod api-1 -n payments")),
+		textResponse("The pod runs out of memory."),
+	)
+	send(doc, "/run")
+	assert.Contains(t, lastBlock(doc).Text, "there are no runbooks")
+
+	var err error
+	doc.runbooks, err = LoadRunbooks(writeRunbooks(t, map[string]string{"triage-pod.md": triagePodRunbook, "certs.yaml": certExpiryRunbook}))
+	require.NoError(t, err)
+	send(doc, "/run")
+	assert.Equal(t, "Runbooks:\n\n- `/run cert-expiry ingress=…` Check when the certificate of an ingress expires.\n"+
+		"- `/run triage-pod pod=… [namespace=default]` Find out why a pod keeps crashing.", lastBlock(doc).Text)
+	send(doc, "/run triage")
+	assert.Equal(t, "there is no runbook triage, /run lists them", lastBlock(doc).Text)
+
+	send(doc, "/run triage-pod namespace=payments")
+	assert.Equal(t, "triage-pod needs:\n\n- `pod` name of the pod", lastBlock(doc).Text)
+	assert.Equal(t, "/run triage-pod namespace=payments pod=", doc.input.Value())
+	assert.Empty(t, chat.Sent())
+
+	doc.input.SetValue(doc.input.Value() + "api-1")
+	doc.HandleSend()()
+	doc.running = false
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, []any{"Pod api-1 in namespace payments is crash-looping. Find out why."}, sent[0])
+	assert.Equal(t, []any{gollm.FunctionCallResult{ID: "1", Name: "kubectl", Result: map[string]any{"error": ErrNotAllowed.Error()}}}, sent[1])
+	blocks := doc.Snapshot()
+	assert.Contains(t, blocks, Block{Text: "Pod api-1 in namespace payments is crash-looping. Find out why.", Type: UserBlock})
+	assert.Contains(t, blocks, Block{Text: "Did not run kubectl delete pod api-1 -n payments: " + ErrNotAllowed.Error(), Type: ErrorBlock})
+	// all tools are back once the runbook is done
+	assert.Len(t, chat.defs, len(doc.Definitions()))
+	assert.True(t, toolAllowed(nil, "kubectl"))
+
+	defs := doc.allowedDefinitions([]string{"k8s_get"})
+	require.Len(t, defs, 1)
+	assert.Equal(t, "k8s_get", defs[0].Name)
+}
+
+// TestCompleteRunbook completes runbook and parameter names after /run.
+func TestCompleteRunbook(t *testing.T) {
+	doc, _ := newTestDoc(t)
+	var err error
+	doc.runbooks, err = LoadRunbooks(writeRunbooks(t, map[string]string{"triage-pod.md": triagePodRunbook, "certs.yaml": certExpiryRunbook}))
+	require.NoError(t, err)
+
+	tab := func(input string) string {
+		doc.input.SetValue(input)
+		doc.Update(tea.KeyMsg{Type: tea.KeyTab})
+		return doc.input.Value()
+	}
+	assert.Equal(t, "/run triage-pod", tab("/run tr"))
+	assert.Equal(t, "/run ", tab("/run "))
+	assert.Equal(t, []string{"cert-expiry", "triage-pod"}, doc.completions)
+	assert.Equal(t, "/run triage-pod pod=", tab("/run triage-pod p"))
+	assert.Equal(t, "/run triage-pod pod=api namespace=", tab("/run triage-pod pod=api "))
+	assert.Equal(t, "/run triage-pod pod=a", tab("/run triage-pod pod=a"))
+}
+
+// TestRunbookToolsScopedToTurn restricts the tools of a runbook's turn only,
+// not of work running next to it.
+func TestRunbookToolsScopedToTurn(t *testing.T) {
+	h, _ := newFakeHistory(t, callResponse(gollm.FunctionCall{ID: "1", Name: "probe"}), textResponse("Done."))
+	release := make(chan struct{})
+	running := make(chan struct{})
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "probe"},
+		ReadOnly:   true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			close(running)
+			<-release
+			return "probed", nil
+		},
+	}))
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "echo"},
+		ReadOnly:   true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return "echoed", nil
+		},
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ChatLoopWithTools("probe it", []string{"probe"})
+	}()
+	<-running
+	// e.g. a triage or a check running while the runbook waits for its probe
+	results := h.runCalls(t.Context(), []gollm.FunctionCall{{ID: "2", Name: "echo"}}, nil)
+	close(release)
+	<-done
+	require.Len(t, results, 1)
+	assert.Equal(t, map[string]any{"output": "echoed"}, results[0].Result)
+}
@@ -0,0 +1,509 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// subscriberBuffer is how many events a slow SSE client may fall behind
+// before it is disconnected. Clients that reconnect get the replay.
+const subscriberBuffer = 64
+
+// maxSessionEvents is how many of the latest events a session keeps to
+// replay to clients that connect later.
+const maxSessionEvents = 1000
+
+// sessionIdleTimeout is how long a session is kept after it was last used
+// while no turn runs and no client follows its events.
+const sessionIdleTimeout = time.Hour
+
+// Serve runs the HTTP/JSON API on addr until the context is cancelled.
+func Serve(ctx context.Context, client gollm.Client, cfg *Config, addr string) error {
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     NewServer(ctx, client, cfg),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	fmt.Printf("BubbleChat API listening on %s\n", addr)
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// event is a single server-sent event published to a session's subscribers.
+type event struct {
+	Name string
+	Data any
+}
+
+// pendingApproval is a mutating tool call waiting for a decision from an API client.
+// When Protection is ConfirmProtection the decision must repeat Target to approve.
+type pendingApproval struct {
+	ID         string     `json:"id"`
+	Tool       string     `json:"tool"`
+	Command    string     `json:"command"`
+	Target     string     `json:"target,omitempty"`
+	Protection Protection `json:"protection"`
+	Preview    string     `json:"preview,omitempty"`
+	decision   chan bool
+}
+
+// session is one conversation served over the API. Every session owns its own History.
+type session struct {
+	ID      string
+	history *History
+	// cancel ends the history's context, which stops the session's turn.
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	busy         bool
+	lastUsed     time.Time
+	log          []event
+	subscribers  map[chan event]struct{}
+	approvals    map[string]*pendingApproval
+	nextApproval int
+}
+
+// publish records an event and fans it out to the current subscribers.
+func (s *session) publish(ev event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publishLocked(ev)
+}
+
+func (s *session) publishLocked(ev event) {
+	s.log = append(s.log, ev)
+	if len(s.log) > maxSessionEvents {
+		s.log = slices.Delete(s.log, 0, len(s.log)-maxSessionEvents)
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// the client is not keeping up, drop it and let it reconnect
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns the events published so far and a channel for the ones that follow.
+func (s *session) subscribe() ([]event, chan event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan event, subscriberBuffer)
+	s.subscribers[ch] = struct{}{}
+	return append([]event(nil), s.log...), ch
+}
+
+func (s *session) unsubscribe(ch chan event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// touch records that the session is in use.
+func (s *session) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+}
+
+// idleSince reports whether the session has been unused since before t,
+// with no turn running and nobody following its events.
+func (s *session) idleSince(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.busy && len(s.subscribers) == 0 && s.lastUsed.Before(t)
+}
+
+// close stops the session's turn and ends the event streams of its clients.
+func (s *session) close() {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// approve is the session's ApprovalFunc. It publishes the request and
+// waits until an API client decides, the turn stops or the server shuts
+// down. An undecided request is withdrawn.
+func (s *session) approve(req ApprovalRequest) bool {
+	s.mu.Lock()
+	s.nextApproval++
+	pending := &pendingApproval{
+		ID:         strconv.Itoa(s.nextApproval),
+		Tool:       req.Call.Name,
+		Command:    req.Command,
+		Target:     req.Target,
+		Protection: req.Protection,
+		Preview:    req.Preview,
+		decision:   make(chan bool, 1),
+	}
+	s.approvals[pending.ID] = pending
+	s.publishLocked(event{Name: "approval", Data: pending})
+	s.mu.Unlock()
+
+	select {
+	case approved := <-pending.decision:
+		return approved
+	case <-s.history.Context.Done():
+	case <-req.Context().Done():
+	}
+	s.mu.Lock()
+	delete(s.approvals, pending.ID)
+	s.mu.Unlock()
+	return false
+}
+
+// Server exposes bubblechat sessions over a small HTTP/JSON API
+// with server-sent events for the conversation stream.
+type Server struct {
+	ctx    context.Context
+	client gollm.Client
+	cfg    *Config
+	mux    *http.ServeMux
+
+	// idleTimeout is how long an unused session is kept.
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewServer creates the API handler. Sessions inherit ctx, so cancelling it
+// stops any running turns and pending approvals. Sessions unused for
+// sessionIdleTimeout are ended when the next one is created.
+func NewServer(ctx context.Context, client gollm.Client, cfg *Config) *Server {
+	s := &Server{
+		ctx:         ctx,
+		client:      client,
+		cfg:         cfg,
+		mux:         http.NewServeMux(),
+		idleTimeout: sessionIdleTimeout,
+		sessions:    map[string]*session{},
+	}
+
+	s.mux.HandleFunc("POST /sessions", s.handleCreateSession)
+	s.mux.HandleFunc("GET /sessions/{id}", s.handleGetSession)
+	s.mux.HandleFunc("DELETE /sessions/{id}", s.handleDeleteSession)
+	s.mux.HandleFunc("POST /sessions/{id}/cancel", s.handleCancelTurn)
+	s.mux.HandleFunc("POST /sessions/{id}/messages", s.handlePostMessage)
+	s.mux.HandleFunc("GET /sessions/{id}/events", s.handleEvents)
+	s.mux.HandleFunc("GET /sessions/{id}/approvals", s.handleListApprovals)
+	s.mux.HandleFunc("POST /sessions/{id}/approvals/{approval}", s.handleDecideApproval)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// lookup finds the session named in the request path or writes a 404.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *session {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown session %q", id)
+		return nil
+	}
+	sess.touch()
+	return sess
+}
+
+// pruneLocked ends the sessions that have been idle for longer than
+// idleTimeout. s.mu must be held.
+func (s *Server) pruneLocked() {
+	cutoff := time.Now().Add(-s.idleTimeout)
+	for id, sess := range s.sessions {
+		if sess.idleSince(cutoff) {
+			delete(s.sessions, id)
+			sess.close()
+		}
+	}
+}
+
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	sess := &session{
+		ID:          newSessionID(),
+		history:     s.cfg.NewHistory(ctx, s.client),
+		cancel:      cancel,
+		lastUsed:    time.Now(),
+		subscribers: map[chan event]struct{}{},
+		approvals:   map[string]*pendingApproval{},
+	}
+	// audit records carry the same ID that API clients see
+	sess.history.Session = sess.ID
+	sess.history.OnBlock = func(b Block) {
+		sess.publish(event{Name: "block", Data: b})
+	}
+	sess.history.OnUsage = func(turn, session Usage) {
+		sess.publish(event{Name: "usage", Data: map[string]Usage{"turn": turn, "session": session}})
+	}
+	sess.history.Approve = sess.approve
+
+	s.mu.Lock()
+	s.pruneLocked()
+	s.sessions[sess.ID] = sess
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": sess.ID})
+}
+
+// handleDeleteSession ends a session, stopping its turn and event streams.
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown session %q", id)
+		return
+	}
+	sess.close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCancelTurn stops the session's running turn. The session stays, and
+// the events endpoint emits "done" once the turn has ended.
+func (s *Server) handleCancelTurn(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+	if !sess.history.CancelTurn() {
+		writeError(w, http.StatusConflict, "session %s is not running a turn", sess.ID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+
+	sess.mu.Lock()
+	busy := sess.busy
+	sess.mu.Unlock()
+
+	_, usage := sess.history.Usage()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":     sess.ID,
+		"busy":   busy,
+		"blocks": sess.history.Snapshot(),
+		"usage":  usage,
+	})
+}
+
+// handlePostMessage starts a turn in the background. The conversation is
+// followed through the events endpoint, which emits "done" when the turn ends.
+func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	sess.mu.Lock()
+	if sess.busy {
+		sess.mu.Unlock()
+		writeError(w, http.StatusConflict, "session %s is already running a turn", sess.ID)
+		return
+	}
+	sess.busy = true
+	sess.mu.Unlock()
+
+	go func() {
+		sess.history.AddBlock(Block{
+			Text: text,
+			Type: UserBlock,
+		})
+		sess.history.ChatLoop(text)
+
+		sess.mu.Lock()
+		sess.busy = false
+		sess.lastUsed = time.Now()
+		sess.publishLocked(event{Name: "done", Data: map[string]string{"id": sess.ID}})
+		sess.mu.Unlock()
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": sess.ID})
+}
+
+// handleEvents streams the session as server-sent events. Everything that
+// happened before the client connected is replayed first.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	backlog, ch := sess.subscribe()
+	defer sess.unsubscribe(ch)
+
+	for _, ev := range backlog {
+		writeEvent(w, ev)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, ev)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev event) {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, data)
+}
+
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+
+	sess.mu.Lock()
+	pending := make([]*pendingApproval, 0, len(sess.approvals))
+	for _, a := range sess.approvals {
+		pending = append(pending, a)
+	}
+	sess.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, pending)
+}
+
+func (s *Server) handleDecideApproval(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+
+	var req struct {
+		Approved bool   `json:"approved"`
+		Confirm  string `json:"confirm"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+
+	id := r.PathValue("approval")
+	sess.mu.Lock()
+	pending, ok := sess.approvals[id]
+	if !ok {
+		sess.mu.Unlock()
+		writeError(w, http.StatusNotFound, "unknown approval %q", id)
+		return
+	}
+	if req.Approved && pending.Protection == ConfirmProtection && req.Confirm != pending.Target {
+		sess.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "%s is protected, set confirm to its name to approve", pending.Target)
+		return
+	}
+	delete(sess.approvals, id)
+	sess.publishLocked(event{Name: "decision", Data: map[string]any{"id": id, "approved": req.Approved}})
+	sess.mu.Unlock()
+
+	pending.decision <- req.Approved
+	w.WriteHeader(http.StatusNoContent)
+}
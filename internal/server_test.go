@@ -0,0 +1,312 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is a decoded server-sent event.
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// startTestServer serves the API backed by the fake LLM.
+func startTestServer(t *testing.T, responses ...gollm.ChatResponse) (*httptest.Server, *fakeChat) {
+	t.Helper()
+	client, chat := newFakeClient(responses...)
+	ts := httptest.NewServer(NewServer(t.Context(), client, &Config{Model: "fake-model"}))
+	t.Cleanup(ts.Close)
+	return ts, chat
+}
+
+func postJSON(t *testing.T, url string, body any) *http.Response {
+	t.Helper()
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(data)))
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func createSession(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	resp := postJSON(t, ts.URL+"/sessions", map[string]string{})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	require.NotEmpty(t, created.ID)
+	return created.ID
+}
+
+// readEvents opens the event stream of a session and calls fn for every
+// event until fn returns false.
+func readEvents(t *testing.T, ts *httptest.Server, id string, fn func(sseEvent) bool) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/sessions/" + id + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var ev sseEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			ev.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.Data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			if !fn(ev) {
+				return
+			}
+			ev = sseEvent{}
+		}
+	}
+	t.Fatalf("event stream ended early: %v", scanner.Err())
+}
+
+// TestServerMessage posts a message and follows the turn over SSE.
+func TestServerMessage(t *testing.T) {
+	ts, _ := startTestServer(t, textResponse("Hello from the fake model"))
+	id := createSession(t, ts)
+
+	resp := postJSON(t, ts.URL+"/sessions/"+id+"/messages", map[string]string{"text": "hi"})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var blocks []Block
+	readEvents(t, ts, id, func(ev sseEvent) bool {
+		if ev.Name == "block" {
+			var b struct {
+				Text string `json:"text"`
+				Type string `json:"type"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(ev.Data), &b))
+			blocks = append(blocks, Block{Text: b.Text})
+			assert.Contains(t, []string{"user", "agent"}, b.Type)
+		}
+		return ev.Name != "done"
+	})
+
+	require.Len(t, blocks, 2)
+	assert.Equal(t, "hi", blocks[0].Text)
+	assert.Equal(t, "Hello from the fake model", blocks[1].Text)
+
+	get, err := http.Get(ts.URL + "/sessions/" + id)
+	require.NoError(t, err)
+	defer get.Body.Close()
+	var state struct {
+		Busy   bool              `json:"busy"`
+		Blocks []json.RawMessage `json:"blocks"`
+	}
+	require.NoError(t, json.NewDecoder(get.Body).Decode(&state))
+	assert.False(t, state.Busy)
+	assert.Len(t, state.Blocks, 2)
+}
+
+// TestServerErrors checks the error responses for bad requests.
+func TestServerErrors(t *testing.T) {
+	ts, _ := startTestServer(t)
+
+	resp := postJSON(t, ts.URL+"/sessions/nope/messages", map[string]string{"text": "hi"})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	id := createSession(t, ts)
+	resp = postJSON(t, ts.URL+"/sessions/"+id+"/messages", map[string]string{"text": "  "})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, ts.URL+"/sessions/"+id+"/approvals/1", map[string]bool{"approved": true})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// TestServerApproval declines a mutating tool call through the approvals
+// endpoint and checks that the model is told about it.
+func TestServerApproval(t *testing.T) {
+	ts, chat := startTestServer(t,
+		callResponse(toolCall("call-1", "kubectl", "delete pod api-0")),
+		textResponse("Okay, I will not delete it."),
+	)
+	id := createSession(t, ts)
+
+	resp := postJSON(t, ts.URL+"/sessions/"+id+"/messages", map[string]string{"text": "delete api-0"})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	var last string
+	readEvents(t, ts, id, func(ev sseEvent) bool {
+		switch ev.Name {
+		case "approval":
+			var pending pendingApproval
+			require.NoError(t, json.Unmarshal([]byte(ev.Data), &pending))
+			assert.Equal(t, "kubectl", pending.Tool)
+			assert.Equal(t, "delete pod api-0", pending.Command)
+
+			list, err := http.Get(ts.URL + "/sessions/" + id + "/approvals")
+			require.NoError(t, err)
+			var listed []pendingApproval
+			require.NoError(t, json.NewDecoder(list.Body).Decode(&listed))
+			list.Body.Close()
+			assert.Len(t, listed, 1)
+
+			decided := postJSON(t, ts.URL+"/sessions/"+id+"/approvals/"+pending.ID, map[string]bool{"approved": false})
+			assert.Equal(t, http.StatusNoContent, decided.StatusCode)
+		case "block":
+			last = ev.Data
+		}
+		return ev.Name != "done"
+	})
+
+	assert.Contains(t, last, "Okay, I will not delete it.")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	result, ok := sent[1][0].(gollm.FunctionCallResult)
+	require.True(t, ok)
+	assert.Equal(t, "call-1", result.ID)
+	assert.Contains(t, result.Result, "error")
+}
+
+// TestSessionCleanup withdraws approvals of stopped turns and keeps only
+// the latest events for replay.
+func TestSessionCleanup(t *testing.T) {
+	h, _ := newFakeHistory(t)
+	sess := &session{
+		history:     h,
+		subscribers: map[chan event]struct{}{},
+		approvals:   map[string]*pendingApproval{},
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	declined := make(chan bool)
+	go func() {
+		declined <- sess.approve(ApprovalRequest{Call: toolCall("1", "kubectl", "delete pod api-0"), ctx: ctx})
+	}()
+	require.Eventually(t, func() bool {
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		return len(sess.approvals) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.False(t, <-declined)
+	sess.mu.Lock()
+	assert.Empty(t, sess.approvals)
+	sess.mu.Unlock()
+
+	for i := range maxSessionEvents + 10 {
+		sess.publish(event{Name: "block", Data: i})
+	}
+	replay, _ := sess.subscribe()
+	require.Len(t, replay, maxSessionEvents)
+	// the approval event and the first ten blocks are dropped
+	assert.Equal(t, 10, replay[0].Data)
+}
+
+// TestServerCancelAndDelete stops a turn waiting for approval, then ends
+// the session.
+func TestServerCancelAndDelete(t *testing.T) {
+	ts, _ := startTestServer(t, callResponse(toolCall("call-1", "kubectl", "delete pod api-0")))
+	id := createSession(t, ts)
+
+	resp := postJSON(t, ts.URL+"/sessions/"+id+"/cancel", nil)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "no turn is running")
+
+	resp = postJSON(t, ts.URL+"/sessions/"+id+"/messages", map[string]string{"text": "delete api-0"})
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var last string
+	readEvents(t, ts, id, func(ev sseEvent) bool {
+		switch ev.Name {
+		case "approval":
+			cancelled := postJSON(t, ts.URL+"/sessions/"+id+"/cancel", nil)
+			assert.Equal(t, http.StatusNoContent, cancelled.StatusCode)
+		case "block":
+			last = ev.Data
+		}
+		return ev.Name != "done"
+	})
+	assert.Contains(t, last, "Stopped the turn.")
+
+	// a client following the events is disconnected when the session ends
+	streamEnded := make(chan struct{})
+	go func() {
+		defer close(streamEnded)
+		resp, err := http.Get(ts.URL + "/sessions/" + id + "/events")
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/sessions/"+id, nil)
+	require.NoError(t, err)
+	deleted, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	deleted.Body.Close()
+	assert.Equal(t, http.StatusNoContent, deleted.StatusCode)
+	select {
+	case <-streamEnded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event stream was not ended")
+	}
+
+	get, err := http.Get(ts.URL + "/sessions/" + id)
+	require.NoError(t, err)
+	get.Body.Close()
+	assert.Equal(t, http.StatusNotFound, get.StatusCode)
+	deleted, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	deleted.Body.Close()
+	assert.Equal(t, http.StatusNotFound, deleted.StatusCode)
+}
+
+// TestServerSessionExpiry ends sessions left unused once the next one is
+// created.
+func TestServerSessionExpiry(t *testing.T) {
+	client, _ := newFakeClient()
+	srv := NewServer(t.Context(), client, &Config{Model: "fake-model"})
+	srv.idleTimeout = 50 * time.Millisecond
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	old := createSession(t, ts)
+	srv.mu.Lock()
+	history := srv.sessions[old].history
+	srv.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	current := createSession(t, ts)
+
+	srv.mu.Lock()
+	assert.NotContains(t, srv.sessions, old)
+	assert.Contains(t, srv.sessions, current)
+	srv.mu.Unlock()
+	assert.Error(t, history.Context.Err(), "the expired session is stopped")
+}
@@ -0,0 +1,292 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	// tabStyle is used for the tabs in the tab bar, activeTabStyle for the shown one.
+	tabStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("#d3d7cf")).Background(lipgloss.Color("#555753")).Padding(0, 1)
+	activeTabStyle = tabStyle.Foreground(lipgloss.Color("#1c1c1c")).Background(lipgloss.Color("#729fcf")).Bold(true)
+)
+
+// Tabs is the terminal UI: one or more conversations side by side, each a
+// Document with its own History, chat and turns. Only the active tab is
+// shown and gets the keys, the others keep running in the background.
+type Tabs struct {
+	ctx    context.Context
+	client gollm.Client
+	cfg    *Config
+
+	tabs []*tab
+	// active is the index of the shown tab.
+	active int
+	// created counts the tabs ever opened, for the names of new ones.
+	created int
+	// size is the last window size, handed to new tabs.
+	size *tea.WindowSizeMsg
+	// rename is the new name typed for the active tab, nil unless renaming.
+	rename []rune
+}
+
+// tab is one conversation.
+type tab struct {
+	name string
+	doc  *Document
+	// cancel ends the tab's turns, watches and checks when it is closed.
+	cancel context.CancelFunc
+	// unseen is set when something happened while the tab was in the background.
+	unseen bool
+}
+
+// tabMsg is a message of the document of a tab, which is handed to that
+// document whether or not its tab is shown.
+type tabMsg struct {
+	doc *Document
+	msg tea.Msg
+}
+
+// NewTabs returns the terminal UI with one tab. The scheduled checks of the
+// configuration run in that first tab.
+func NewTabs(ctx context.Context, client gollm.Client, cfg *Config) *Tabs {
+	t := &Tabs{ctx: ctx, client: client, cfg: cfg}
+	doc := t.open()
+	if err := doc.StartChecks(cfg.Checks); err != nil {
+		doc.AddBlock(Block{Text: "Not running the scheduled checks: " + err.Error(), Type: ErrorBlock})
+	}
+	return t
+}
+
+// open adds a tab with a new conversation after the others.
+func (t *Tabs) open() *Document {
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.created++
+	doc := NewDoc(ctx, t.client, t.cfg)
+	if t.size != nil {
+		doc.Update(*t.size)
+	}
+	t.tabs = append(t.tabs, &tab{name: fmt.Sprintf("chat %d", t.created), doc: doc, cancel: cancel})
+	return doc
+}
+
+// Active returns the document of the shown tab.
+func (t *Tabs) Active() *Document {
+	return t.tabs[t.active].doc
+}
+
+// Names returns the names of the tabs in order.
+func (t *Tabs) Names() []string {
+	names := make([]string, len(t.tabs))
+	for i, tab := range t.tabs {
+		names[i] = tab.name
+	}
+	return names
+}
+
+// switchTo shows the i-th tab.
+func (t *Tabs) switchTo(i int) {
+	if i < 0 || i >= len(t.tabs) {
+		return
+	}
+	t.active = i
+	t.rename = nil
+	active := t.tabs[i]
+	active.unseen = false
+	// another tab may have added to the shared prompt history
+	if active.doc.input.Value() == "" {
+		active.doc.recall = len(active.doc.prompts.Entries())
+	}
+}
+
+// close ends the conversation of the shown tab. The last tab is not closed.
+func (t *Tabs) close() {
+	if len(t.tabs) == 1 {
+		return
+	}
+	t.tabs[t.active].cancel()
+	t.tabs = append(t.tabs[:t.active], t.tabs[t.active+1:]...)
+	t.switchTo(min(t.active, len(t.tabs)-1))
+}
+
+// route makes the messages of a document's commands reach that document,
+// also after another tab is shown. Messages of the program itself, such as
+// tea.Quit, are passed on as they are.
+func route(doc *Document, cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		switch msg := cmd().(type) {
+		case tea.BatchMsg:
+			routed := make(tea.BatchMsg, len(msg))
+			for i, cmd := range msg {
+				routed[i] = route(doc, cmd)
+			}
+			return routed
+		case blockMsg, approvalMsg, alertMsg, triageMsg, turnDoneMsg:
+			return tabMsg{doc: doc, msg: msg}
+		default:
+			return msg
+		}
+	}
+}
+
+// Init starts the first tab.
+func (t *Tabs) Init() tea.Cmd {
+	return route(t.Active(), t.Active().Init())
+}
+
+// Update handles the keys for the tabs and hands everything else to the
+// document it is meant for.
+func (t *Tabs) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tabMsg:
+		for i, tab := range t.tabs {
+			if tab.doc != msg.doc {
+				continue
+			}
+			if i != t.active {
+				tab.unseen = true
+			}
+			_, cmd := tab.doc.Update(msg.msg)
+			return t, route(tab.doc, cmd)
+		}
+		// the tab was closed
+		return t, nil
+	case tea.WindowSizeMsg:
+		t.size = &msg
+		cmds := make([]tea.Cmd, len(t.tabs))
+		for i, tab := range t.tabs {
+			_, cmd := tab.doc.Update(msg)
+			cmds[i] = route(tab.doc, cmd)
+		}
+		return t, tea.Batch(cmds...)
+	case tea.KeyMsg:
+		if cmd, ok := t.handleKey(msg); ok {
+			return t, cmd
+		}
+	}
+	_, cmd := t.Active().Update(msg)
+	return t, route(t.Active(), cmd)
+}
+
+// handleKey handles the keys opening, switching, renaming and closing tabs.
+// It returns false for keys meant for the active document.
+func (t *Tabs) handleKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	if t.rename != nil {
+		switch msg.Type {
+		case tea.KeyEnter:
+			if name := strings.TrimSpace(string(t.rename)); name != "" {
+				t.tabs[t.active].name = name
+			}
+			t.rename = nil
+		case tea.KeyEsc:
+			t.rename = nil
+		case tea.KeyBackspace:
+			if len(t.rename) > 0 {
+				t.rename = t.rename[:len(t.rename)-1]
+			}
+		case tea.KeyRunes, tea.KeySpace:
+			t.rename = append(t.rename, msg.Runes...)
+		case tea.KeyCtrlC:
+			return tea.Quit, true
+		}
+		return nil, true
+	}
+
+	switch msg.String() {
+	case "ctrl+t":
+		doc := t.open()
+		t.switchTo(len(t.tabs) - 1)
+		return route(doc, doc.Init()), true
+	case "alt+w":
+		t.close()
+		return nil, true
+	case "ctrl+pgdown":
+		t.switchTo((t.active + 1) % len(t.tabs))
+		return nil, true
+	case "ctrl+pgup":
+		t.switchTo((t.active + len(t.tabs) - 1) % len(t.tabs))
+		return nil, true
+	case "f2":
+		t.rename = []rune{}
+		return nil, true
+	}
+	if msg.Alt && msg.Type == tea.KeyRunes && len(msg.Runes) == 1 && msg.Runes[0] >= '1' && msg.Runes[0] <= '9' {
+		t.switchTo(int(msg.Runes[0] - '1'))
+		return nil, true
+	}
+	return nil, false
+}
+
+// indicator shows what a tab is doing: waiting for approval, running a
+// turn, flagged by a check or changed while in the background.
+func (tab *tab) indicator() string {
+	var marks []string
+	switch {
+	case tab.doc.approval != nil:
+		marks = append(marks, "?")
+	case tab.doc.running:
+		marks = append(marks, "…")
+	}
+	if len(tab.doc.alerts) > 0 {
+		marks = append(marks, checkProblemMark)
+	}
+	if tab.unseen {
+		marks = append(marks, "•")
+	}
+	if len(marks) == 0 {
+		return ""
+	}
+	return " " + strings.Join(marks, "")
+}
+
+// bar renders the tab bar, or the name typed for the active tab while renaming.
+func (t *Tabs) bar() string {
+	if t.rename != nil {
+		return activeTabStyle.Render(fmt.Sprintf("Rename %s to: %s█", t.tabs[t.active].name, string(t.rename))) +
+			" Enter renames, Esc cancels"
+	}
+	tabs := make([]string, len(t.tabs))
+	for i, tab := range t.tabs {
+		style := tabStyle
+		if i == t.active {
+			style = activeTabStyle
+		}
+		tabs[i] = style.Render(fmt.Sprintf("%d %s%s", i+1, tab.name, tab.indicator()))
+	}
+	return strings.Join(tabs, " ")
+}
+
+// View renders the tab bar above the active document. The bar is left out
+// while there is only one tab.
+func (t *Tabs) View() string {
+	var sb strings.Builder
+	if len(t.tabs) > 1 || t.rename != nil {
+		sb.WriteString(t.bar())
+		sb.WriteString("\n")
+	}
+	sb.WriteString(t.Active().View())
+	sb.WriteString("Ctrl+T opens a tab, Alt+1-9 or Ctrl+PgUp/PgDn switch tabs, F2 renames and Alt+W closes one.\n")
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// altKey is Alt and a letter or digit.
+func altKey(r rune) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}, Alt: true}
+}
+
+// TestTabs opens, switches, renames and closes tabs, each with a
+// conversation of its own.
+func TestTabs(t *testing.T) {
+	client, _ := newFakeClient(textResponse("The service has no endpoints."))
+	tabs := NewTabs(t.Context(), client, &Config{Model: "fake-model"})
+	first := tabs.Active()
+	assert.NotContains(t, tabs.View(), "chat 1", "no tab bar for a single tab")
+
+	// a turn keeps running in its tab while another one is opened
+	first.input.SetValue("why is the checkout service down?")
+	_, turn := tabs.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, turn)
+	_, cmd := tabs.Update(tea.KeyMsg{Type: tea.KeyCtrlT})
+	assert.NotNil(t, cmd)
+	second := tabs.Active()
+	assert.NotSame(t, first, second)
+	assert.Equal(t, []string{"chat 1", "chat 2"}, tabs.Names())
+	assert.Contains(t, tabs.bar(), "1 chat 1 …")
+
+	tabs.Update(turn())
+	assert.False(t, first.running)
+	assert.Contains(t, tabs.bar(), "1 chat 1 •")
+	assert.Equal(t, "The service has no endpoints.", lastBlock(first).Text)
+	assert.Equal(t, AgentBlock, lastBlock(second).Type)
+	assert.Len(t, second.Snapshot(), 1, "only the welcome message")
+
+	// typing goes to the shown tab
+	tabs.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("db")})
+	assert.Equal(t, "db", second.input.Value())
+	assert.Empty(t, first.input.Value())
+
+	tabs.Update(tea.KeyMsg{Type: tea.KeyF2})
+	tabs.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("datx")})
+	tabs.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	tabs.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("abase")})
+	assert.Contains(t, tabs.View(), "Rename chat 2 to: database")
+	tabs.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, []string{"chat 1", "database"}, tabs.Names())
+	assert.Equal(t, "db", second.input.Value(), "renaming does not type into the input")
+
+	tabs.Update(altKey('1'))
+	assert.Same(t, first, tabs.Active())
+	assert.NotContains(t, tabs.bar(), "•")
+	tabs.Update(tea.KeyMsg{Type: tea.KeyCtrlPgDown})
+	assert.Same(t, second, tabs.Active())
+	tabs.Update(tea.KeyMsg{Type: tea.KeyCtrlPgDown})
+	assert.Same(t, first, tabs.Active())
+
+	// closing a tab ends its conversation, the last tab stays
+	tabs.Update(altKey('w'))
+	assert.Same(t, second, tabs.Active())
+	assert.Error(t, first.Context.Err())
+	assert.NoError(t, second.Context.Err())
+	tabs.Update(altKey('w'))
+	assert.Equal(t, []string{"database"}, tabs.Names())
+	assert.NoError(t, second.Context.Err())
+}
+
+// TestRoute hands messages to the document whose command produced them.
+func TestRoute(t *testing.T) {
+	doc, _ := newTestDoc(t)
+	msg := route(doc, tea.Batch(func() tea.Msg { return blockMsg{} }, tea.Quit))()
+	batch, ok := msg.(tea.BatchMsg)
+	require.True(t, ok)
+	require.Len(t, batch, 2)
+	assert.Equal(t, tabMsg{doc: doc, msg: blockMsg{}}, batch[0]())
+	assert.Equal(t, tea.QuitMsg{}, batch[1]())
+	assert.Nil(t, route(doc, nil))
+}
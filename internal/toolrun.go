@@ -0,0 +1,218 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// DefaultToolWorkers is how many read-only tool calls of one step run at
+// the same time unless configured otherwise.
+const DefaultToolWorkers = 4
+
+// ToolProgress is how far a tool call of the current step has got.
+type ToolProgress struct {
+	Call gollm.FunctionCall
+	// Started and Finished are zero while the call waits and runs.
+	Started  time.Time
+	Finished time.Time
+	Failed   bool
+}
+
+// String describes the call and its state, e.g. "kubectl get pods: done in 1.2s".
+func (p ToolProgress) String() string {
+	call := p.Call.Name
+	if command, ok := p.Call.Arguments["command"].(string); ok {
+		call += " " + strings.TrimPrefix(command, p.Call.Name+" ")
+	} else if args, err := json.Marshal(p.Call.Arguments); err == nil {
+		call += " " + string(args)
+	}
+	switch {
+	case p.Started.IsZero():
+		return call + ": waiting"
+	case p.Finished.IsZero():
+		return call + ": running"
+	case p.Failed:
+		return fmt.Sprintf("%s: failed after %s", call, p.Finished.Sub(p.Started).Round(100*time.Millisecond))
+	default:
+		return fmt.Sprintf("%s: done in %s", call, p.Finished.Sub(p.Started).Round(100*time.Millisecond))
+	}
+}
+
+// Progress returns the state of the tool calls of the current step, in
+// the order the model made them. It is empty between steps.
+func (h *History) Progress() []ToolProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]ToolProgress(nil), h.progress...)
+}
+
+// updateProgress changes the progress of the i-th call and notifies OnProgress.
+func (h *History) updateProgress(i int, update func(*ToolProgress)) {
+	h.mu.Lock()
+	if i < len(h.progress) {
+		update(&h.progress[i])
+	}
+	h.mu.Unlock()
+	if h.OnProgress != nil {
+		h.OnProgress()
+	}
+}
+
+// toolWorkers returns how many read-only calls may run at the same time.
+func (h *History) toolWorkers() int {
+	if h.ToolWorkers > 0 {
+		return h.ToolWorkers
+	}
+	return DefaultToolWorkers
+}
+
+// runCalls runs the tool calls of one step and returns their results in the
+// order of the calls. Consecutive read-only calls run concurrently, up to
+// toolWorkers at a time. Any other call waits for the calls before it and
+// runs on its own, so approvals are asked one after another. Calls run with
+// the turn's context, and none are started once it is cancelled; only those
+// are left out. When tools are given, calls of other tools are refused.
+func (h *History) runCalls(ctx context.Context, calls []gollm.FunctionCall, tools []string) []gollm.FunctionCallResult {
+	h.mu.Lock()
+	h.progress = make([]ToolProgress, len(calls))
+	for i, call := range calls {
+		h.progress[i].Call = call
+	}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.progress = nil
+		h.mu.Unlock()
+	}()
+
+	results := make([]*gollm.FunctionCallResult, len(calls))
+	workers := make(chan struct{}, h.toolWorkers())
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		if ctx.Err() != nil {
+			break
+		}
+		if !h.IsReadOnly(call) {
+			wg.Wait()
+			if ctx.Err() != nil {
+				break
+			}
+			results[i] = h.runCall(ctx, i, call, tools)
+			continue
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			results[i] = h.runCall(ctx, i, call, tools)
+		}()
+	}
+	wg.Wait()
+
+	var ordered []gollm.FunctionCallResult
+	for _, result := range results {
+		if result != nil {
+			ordered = append(ordered, *result)
+		}
+	}
+	return ordered
+}
+
+// runCall runs the i-th tool call of a step, showing what happened, and
+// returns the result for the model.
+func (h *History) runCall(ctx context.Context, i int, fnCall gollm.FunctionCall, tools []string) *gollm.FunctionCallResult {
+	h.updateProgress(i, func(p *ToolProgress) { p.Started = time.Now() })
+	var result, preview string
+	err := ErrNotAllowed
+	if toolAllowed(tools, fnCall.Name) {
+		result, preview, err = h.CallWithPreview(ctx, fnCall)
+	}
+	h.updateProgress(i, func(p *ToolProgress) {
+		p.Finished = time.Now()
+		p.Failed = err != nil
+	})
+
+	switch {
+	case errors.Is(err, ErrDeclined), errors.Is(err, ErrProtected), errors.Is(err, ErrBlocked), errors.Is(err, ErrNotAllowed):
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Did not run %s %s: %v", fnCall.Name, fnCall.Arguments["command"], err),
+			Type: ErrorBlock,
+		})
+		return &gollm.FunctionCallResult{
+			ID:     fnCall.ID,
+			Name:   fnCall.Name,
+			Result: withPreview(map[string]any{"error": err.Error()}, preview),
+		}
+	case errors.Is(err, ErrInvalidManifest):
+		// the model is told what is wrong so it can fix the manifest
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Did not save the manifest: %v", err),
+			Type: ErrorBlock,
+		})
+		return &gollm.FunctionCallResult{
+			ID:     fnCall.ID,
+			Name:   fnCall.Name,
+			Result: map[string]any{"error": err.Error()},
+		}
+	case err != nil:
+		// the model gets the error with what the command printed, so that
+		// every call of the step has a result
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Error executing %s: %v", fnCall.Name, err),
+			Type: ErrorBlock,
+		})
+	}
+
+	if fnCall.Name == writeManifestTool && err == nil {
+		content, _ := fnCall.Arguments["content"].(string)
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("# %s\n%s", h.ManifestPath(fnCall), strings.TrimRight(content, "\n")),
+			Type: ManifestBlock,
+		})
+	}
+	output, redactions := h.Redact(result)
+	if len(redactions) > 0 {
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Redacted from the %s output before sending it to the model: %s", fnCall.Name, DescribeRedactions(redactions)),
+			Type: ToolBlock,
+		})
+	}
+	output, artifact := h.Offload(fnCall, output)
+	if artifact != nil {
+		h.AddBlock(Block{
+			Text: fmt.Sprintf("Stored the %s output (%d bytes, %d lines) as artifact %s, the model gets its head and tail.", fnCall.Name, len(artifact.Content), artifact.Lines(), artifact.ID),
+			Type: ToolBlock,
+		})
+	}
+	content := map[string]any{"output": output}
+	if err != nil {
+		content["error"], _ = h.Redact(err.Error())
+	}
+	return &gollm.FunctionCallResult{
+		ID:     fnCall.ID,
+		Name:   fnCall.Name,
+		Result: withPreview(content, preview),
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callLog records when the tools of a test start and end.
+type callLog struct {
+	mu      sync.Mutex
+	events  []string
+	running int
+	peak    int
+}
+
+// run records a call of the named tool that takes the given time.
+func (l *callLog) run(name string, d time.Duration) string {
+	l.mu.Lock()
+	l.events = append(l.events, "start "+name)
+	l.running++
+	l.peak = max(l.peak, l.running)
+	l.mu.Unlock()
+
+	time.Sleep(d)
+
+	l.mu.Lock()
+	l.events = append(l.events, "end "+name)
+	l.running--
+	l.mu.Unlock()
+	return name + " output"
+}
+
+// index returns the position of an event in the log.
+func (l *callLog) index(event string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Index(l.events, event)
+}
+
+// probeCall builds a call of the read-only probe tool.
+func probeCall(id string, ms int) gollm.FunctionCall {
+	return gollm.FunctionCall{ID: id, Name: "probe", Arguments: map[string]any{"id": id, "ms": float64(ms)}}
+}
+
+// TestRunCallsInParallel runs read-only calls concurrently and the others
+// on their own, returning the results in call order.
+func TestRunCallsInParallel(t *testing.T) {
+	calls := []gollm.FunctionCall{
+		probeCall("p1", 60),
+		probeCall("p2", 40),
+		probeCall("p3", 20),
+		{ID: "s1", Name: "scale", Arguments: map[string]any{}},
+		probeCall("p4", 10),
+	}
+	h, chat := newFakeHistory(t, callResponse(calls...), textResponse("All done."))
+	h.ToolWorkers = 2
+	// OnProgress is called from the goroutines running the calls
+	var progressed atomic.Int32
+	h.OnProgress = func() { progressed.Add(1) }
+	h.Approve = func(ApprovalRequest) bool { return true }
+
+	log := &callLog{}
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "probe"},
+		ReadOnly:   true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			id, _ := args["id"].(string)
+			ms, _ := intArgument(args, "ms")
+			return log.run(id, time.Duration(ms)*time.Millisecond), nil
+		},
+	}))
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "scale"},
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return log.run("s1", 0), nil
+		},
+	}))
+
+	h.ChatLoop("check everything, then scale")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	var ids, outputs []string
+	for _, content := range sent[1] {
+		result := content.(gollm.FunctionCallResult)
+		ids = append(ids, result.ID)
+		outputs = append(outputs, result.Result["output"].(string))
+	}
+	assert.Equal(t, []string{"p1", "p2", "p3", "s1", "p4"}, ids)
+	assert.Equal(t, []string{"p1 output", "p2 output", "p3 output", "s1 output", "p4 output"}, outputs)
+
+	assert.Equal(t, 2, log.peak, "read-only calls run two at a time")
+	for _, probe := range []string{"p1", "p2", "p3"} {
+		assert.Less(t, log.index("end "+probe), log.index("start s1"), "scale waits for %s", probe)
+	}
+	assert.Less(t, log.index("end s1"), log.index("start p4"), "later calls wait for scale")
+	assert.Less(t, log.index("start p2"), log.index("end p1"), "the first two probes overlap")
+
+	assert.EqualValues(t, 2*len(calls), progressed.Load())
+	assert.Empty(t, h.Progress())
+}
+
+// TestRunCallsReportsFailures gives the model a result for a call that
+// failed, with what the command printed, so every call of a step is answered.
+func TestRunCallsReportsFailures(t *testing.T) {
+	fakeKubectl(t, `case "$*" in
+*missing*) echo 'Error from server (NotFound): pods "missing" not found' >&2; exit 1;;
+*) echo "api-0 Running";;
+esac`)
+	h, chat := newFakeHistory(t,
+		callResponse(toolCall("1", "kubectl", "get pod missing"), toolCall("2", "kubectl", "get pods")),
+		textResponse("The pod missing does not exist."))
+
+	h.ChatLoop("is the pod missing running?")
+
+	sent := chat.Sent()
+	require.Len(t, sent, 2)
+	require.Len(t, sent[1], 2)
+	failed := sent[1][0].(gollm.FunctionCallResult)
+	assert.Equal(t, "1", failed.ID)
+	assert.Equal(t, "exit status 1", failed.Result["error"])
+	assert.Contains(t, failed.Result["output"], "NotFound")
+	ok := sent[1][1].(gollm.FunctionCallResult)
+	assert.Equal(t, "2", ok.ID)
+	assert.NotContains(t, ok.Result, "error")
+	assert.Equal(t, Block{Text: "The pod missing does not exist.", Type: AgentBlock}, lastBlockOf(h))
+}
+
+// TestCancelTurnStopsCalls stops running calls and a pending approval when
+// the turn is stopped.
+func TestCancelTurnStopsCalls(t *testing.T) {
+	h, chat := newFakeHistory(t, callResponse(probeCall("p1", 0), probeCall("p2", 0), gollm.FunctionCall{ID: "s1", Name: "scale"}))
+	started := make(chan struct{}, 2)
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "probe"},
+		ReadOnly:   true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	}))
+	var scaled atomic.Bool
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "scale"},
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			scaled.Store(true)
+			return "scaled", nil
+		},
+	}))
+	h.Approve = func(req ApprovalRequest) bool {
+		<-req.Context().Done()
+		return false
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ChatLoop("check, then scale")
+	}()
+	<-started
+	<-started
+	assert.True(t, h.CancelTurn())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the turn did not stop")
+	}
+	assert.False(t, scaled.Load())
+	assert.Equal(t, Block{Text: "Stopped the turn.", Type: ErrorBlock}, lastBlockOf(h))
+	assert.Len(t, chat.Sent(), 1)
+
+	// an approval pending when the turn stops is declined
+	h, _ = newFakeHistory(t, callResponse(gollm.FunctionCall{ID: "s1", Name: "scale"}))
+	asked := make(chan struct{})
+	h.Approve = func(req ApprovalRequest) bool {
+		close(asked)
+		<-req.Context().Done()
+		return false
+	}
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "scale"},
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return "scaled", nil
+		},
+	}))
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ChatLoop("scale")
+	}()
+	<-asked
+	assert.True(t, h.CancelTurn())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the approval did not stop")
+	}
+	assert.Equal(t, Block{Text: "Stopped the turn.", Type: ErrorBlock}, lastBlockOf(h))
+}
+
+// TestCancelTurnStopsKubectl kills a kubectl that would run until stopped,
+// such as logs -f.
+func TestCancelTurnStopsKubectl(t *testing.T) {
+	fakeKubectl(t, "exec sleep 30")
+	h, _ := newFakeHistory(t, callResponse(toolCall("1", "kubectl", "logs -f api-0")))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ChatLoop("follow the logs of api-0")
+	}()
+	require.Eventually(t, func() bool {
+		progress := h.Progress()
+		return len(progress) == 1 && !progress[0].Started.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, h.CancelTurn())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("kubectl was not stopped")
+	}
+	assert.Equal(t, Block{Text: "Stopped the turn.", Type: ErrorBlock}, lastBlockOf(h))
+
+	// gcloud is stopped the same way
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "gcloud"), []byte("#!/bin/sh\nexec sleep 30\n"), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewExecutor().Call(ctx, toolCall("2", "gcloud", "logging tail"))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// TestToolProgress describes the state of a call.
+func TestToolProgress(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	call := toolCall("1", "kubectl", "kubectl get pods")
+	tests := []struct {
+		progress ToolProgress
+		want     string
+	}{
+		{progress: ToolProgress{Call: call}, want: "kubectl get pods: waiting"},
+		{progress: ToolProgress{Call: call, Started: start}, want: "kubectl get pods: running"},
+		{progress: ToolProgress{Call: call, Started: start, Finished: start.Add(1234 * time.Millisecond)}, want: "kubectl get pods: done in 1.2s"},
+		{progress: ToolProgress{Call: call, Started: start, Finished: start.Add(300 * time.Millisecond), Failed: true}, want: "kubectl get pods: failed after 300ms"},
+		{progress: ToolProgress{Call: k8sCall(k8sGetTool, map[string]any{"kind": "Node", "name": "a"})}, want: `k8s_get {"kind":"Node","name":"a"}: waiting`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.progress.String())
+	}
+}
@@ -17,10 +17,11 @@ import (
 	"context"
 	_ "embed"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
-	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
@@ -34,11 +35,9 @@ var systemPrompt string
 // and starts the BubbleTea program to handle user input and display the conversation.
 // It's expected that outside of initializing the client you will not need to do
 // anything to have an interactive session.
-func Repl(ctx context.Context, client gollm.Client) error {
+func Repl(ctx context.Context, client gollm.Client, cfg *Config) error {
 
-	doc := NewDoc(ctx, client)
-
-	p := tea.NewProgram(doc)
+	p := tea.NewProgram(NewTabs(ctx, client, cfg))
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running program: %v\n", err)
 		return err
@@ -52,7 +51,44 @@ var (
 	agentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#d3d7cf"))
 	userStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#729fcf"))
 	toolStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#32afff"))
-	otherStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#ad7fa8"))
+	// attachmentStyle is used for files attached to the user's message.
+	attachmentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#8ae234")).Italic(true)
+	otherStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("#ad7fa8"))
+	// watchStyle is used for changes noticed by a background watch.
+	watchStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#fcaf3e"))
+	// check styles are used for the results of scheduled checks.
+	checkStyle        = lipgloss.NewStyle().Foreground(lipgloss.Color("#888a85"))
+	checkProblemStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#fcaf3e")).Bold(true)
+	// statusStyle is used for the status bar above the input.
+	statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#1c1c1c")).Background(lipgloss.Color("#729fcf")).Padding(0, 1)
+	// protectedStyle replaces statusStyle when the current context is protected.
+	protectedStyle = statusStyle.Background(lipgloss.Color("#cc0000")).Foreground(lipgloss.Color("#ffffff"))
+	// alertStyle is used next to the status bar for checks that flagged a problem.
+	alertStyle = statusStyle.Background(lipgloss.Color("#fcaf3e"))
+	// retryStyle is used for the countdown to the next attempt of a failed model call.
+	retryStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#fcaf3e"))
+	approvalStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#fce94f")).Bold(true)
+	// diff styles color the preview of a change in the approval prompt.
+	diffAddStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#8ae234"))
+	diffRemoveStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#ef2929"))
+	diffHeaderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#34e2e2"))
+)
+
+// Messages sent to the BubbleTea program while a turn runs in the background.
+type (
+	// blockMsg reports that a block was added to the history.
+	blockMsg struct{}
+	// approvalMsg asks the user to approve a tool call.
+	approvalMsg struct {
+		req      ApprovalRequest
+		decision chan bool
+	}
+	// turnDoneMsg reports that the chat loop for the last query finished.
+	turnDoneMsg struct{}
+	// triageMsg asks to hand a problem a watch noticed to the model.
+	triageMsg struct{ prompt string }
+	// alertMsg reports a problem flagged by a scheduled check.
+	alertMsg struct{ result CheckResult }
 )
 
 // Render formats a Block for display in the terminal.
@@ -73,6 +109,17 @@ func Render(block Block) string {
 		lgStyle = userStyle
 	case ToolBlock:
 		lgStyle = toolStyle
+	case AttachmentBlock:
+		lgStyle = attachmentStyle
+	case ManifestBlock:
+		return highlightYAML(block.Text)
+	case WatchBlock:
+		lgStyle = watchStyle
+	case CheckBlock:
+		lgStyle = checkStyle
+		if strings.HasPrefix(block.Text, checkProblemMark) {
+			lgStyle = checkProblemStyle
+		}
 	default:
 		lgStyle = otherStyle
 	}
@@ -83,60 +130,286 @@ func Render(block Block) string {
 // Document also contains visual elements in addition
 // to the conversation history datamodel.
 type Document struct {
-	History
-	textInput textinput.Model
+	*History
+	input textarea.Model
+
+	// events carries messages from the running turn to the program.
+	events chan tea.Msg
+	// running is set while a turn is in progress.
+	running bool
+	// approval is the tool call waiting for the user's decision, if any.
+	approval *approvalMsg
+	// commands are the slash commands by name.
+	commands map[string]Command
+
+	// prompts are the previously sent prompts, recalled with Up and Down.
+	prompts *PromptHistory
+	// recall is the index of the recalled prompt, the number of prompts
+	// while a new one is typed.
+	recall int
+	// draft is the new prompt put aside while older ones are recalled.
+	draft string
+	// search is the active Ctrl+R search, if any.
+	search *promptSearch
+	// completions are the paths offered for the last Tab, if it was ambiguous.
+	completions []string
+	// triage are the prompts from watches waiting for the current turn to end.
+	triage []string
+	// alerts are the checks that flagged a problem since the user last sent something.
+	alerts []string
+	// runbooks are the prompt templates run with /run, loaded from runbookDir.
+	runbooks   []Runbook
+	runbookDir string
 }
 
-func NewDoc(context context.Context, client gollm.Client) *Document {
-	history := NewHistory(context, client, "")
+func NewDoc(context context.Context, client gollm.Client, cfg *Config) *Document {
 	doc := &Document{
-		textInput: textinput.New(),
+		History:    cfg.NewHistory(context, client),
+		input:      newInput(),
+		events:     make(chan tea.Msg),
+		commands:   map[string]Command{},
+		prompts:    cfg.Prompts,
+		runbooks:   cfg.Runbooks,
+		runbookDir: cfg.RunbooksPath(),
+	}
+	if doc.prompts == nil {
+		doc.prompts, _ = LoadPromptHistory("", cfg.PromptHistorySize)
+	}
+	doc.recall = len(doc.prompts.Entries())
+	for _, cmd := range builtinCommands {
+		doc.RegisterCommand(cmd)
 	}
-	doc.textInput.Focus()
-	doc.History = *history
 	doc.AddBlock(Block{
-		Text: "Welcome to BubbleChat! Type your message below:",
+		Text: "Welcome to BubbleChat! Type your message below, or /help for commands:",
 		Type: AgentBlock,
 	})
+	repaint := func() {
+		// only a repaint is needed, so a pending one is as good as a new one
+		select {
+		case doc.events <- blockMsg{}:
+		default:
+		}
+	}
+	doc.OnBlock = func(Block) { repaint() }
+	doc.OnProgress = repaint
+	doc.OnTriage = func(prompt string) {
+		select {
+		case doc.events <- triageMsg{prompt: prompt}:
+		case <-doc.Context.Done():
+		}
+	}
+	doc.OnAlert = func(result CheckResult) {
+		select {
+		case doc.events <- alertMsg{result: result}:
+		case <-doc.Context.Done():
+		}
+	}
+	doc.Approve = doc.approve
 
 	return doc
 }
 
+// approve is the document's ApprovalFunc. It runs on the turn's goroutine
+// and waits for the user to answer the prompt shown by View. A stopped turn
+// declines.
+func (doc *Document) approve(req ApprovalRequest) bool {
+	decision := make(chan bool, 1)
+	select {
+	case doc.events <- approvalMsg{req: req, decision: decision}:
+	case <-doc.Context.Done():
+		return false
+	case <-req.Context().Done():
+		return false
+	}
+
+	select {
+	case approved := <-decision:
+		return approved
+	case <-doc.Context.Done():
+		return false
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+// listen waits for the next message from the running turn. It gives up
+// when the conversation ends, e.g. because its tab was closed.
+func (doc *Document) listen() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case msg := <-doc.events:
+			return msg
+		case <-doc.Context.Done():
+			return nil
+		}
+	}
+}
+
+// decide answers the pending approval with the user's input. Protected
+// contexts are only approved by typing their name, others by "y" or "yes".
+func (doc *Document) decide(userInput string) {
+	req := doc.approval.req
+	var approved bool
+	if req.Protection == ConfirmProtection {
+		approved = userInput == req.Target
+	} else {
+		answer := strings.ToLower(userInput)
+		approved = answer == "y" || answer == "yes"
+	}
+	doc.approval.decision <- approved
+	doc.approval = nil
+}
+
 // HandleSend processes the user input when the Enter key is pressed.
 // It adds the user input as a new block in the conversation history
-// and sends the message to the chat service.
-func (doc *Document) HandleSend() {
-	userInput := strings.TrimSpace(doc.textInput.Value())
-	if userInput == "" {
-		return
+// and starts a turn with the chat service in the background.
+func (doc *Document) HandleSend() tea.Cmd {
+	userInput := strings.TrimSpace(doc.input.Value())
+	if doc.approval != nil {
+		doc.input.Reset()
+		doc.decide(userInput)
+		return nil
+	}
+	if userInput == "" || doc.running {
+		return nil
 	}
+	doc.alerts = nil
 
 	doc.AddBlock(Block{
 		Text: userInput,
 		Type: UserBlock,
 	})
-	doc.textInput.Reset()
-	doc.textInput.Focus()
+	doc.input.Reset()
+	doc.resizeInput()
+	doc.rememberPrompt(userInput)
 
-	doc.ChatLoop(userInput)
+	if cmd, ok := doc.handleCommand(userInput); ok {
+		return cmd
+	}
+	if command, ok := strings.CutPrefix(userInput, "!"); ok {
+		return doc.background(func() { doc.RunCommand(command) })
+	}
+
+	query, err := doc.Attach(".", userInput)
+	if err != nil {
+		// the prompt is given back to fix the mention
+		doc.AddBlock(Block{Text: err.Error(), Type: ErrorBlock})
+		doc.input.SetValue(userInput)
+		doc.resizeInput()
+		return nil
+	}
+	return doc.background(func() { doc.ChatLoop(query) })
+}
+
+// nextTriage starts a turn for the oldest waiting triage prompt, unless a
+// turn is running or the user is asked for approval.
+func (doc *Document) nextTriage() tea.Cmd {
+	if len(doc.triage) == 0 || doc.running || doc.approval != nil {
+		return nil
+	}
+	prompt := doc.triage[0]
+	doc.triage = doc.triage[1:]
+	doc.AddBlock(Block{Text: prompt, Type: UserBlock})
+	return doc.background(func() { doc.ChatLoop(prompt) })
 }
 
 // Init initializes the text input model and returns a command to start blinking the cursor.
 // This function is called by BubbleTea when the program starts.
 func (doc *Document) Init() tea.Cmd {
-	return textinput.Blink
+	return tea.Batch(textarea.Blink, doc.listen())
+}
+
+// status renders the status bar with the cluster context, its protection,
+// the token usage and how full the context window is.
+func (doc *Document) status() string {
+	status := doc.Cluster.String()
+	if turn, session := doc.Usage(); session.TotalTokens > 0 {
+		status += fmt.Sprintf("  turn: %s  session: %s", turn, session)
+	}
+	if used, limit := doc.ContextTokens(); limit > 0 {
+		status += fmt.Sprintf("  context: %d%%", 100*used/limit)
+	}
+	protection := doc.Protection()
+	if protection == Unprotected {
+		status = statusStyle.Render(status)
+	} else {
+		status = protectedStyle.Render(status + "  🔒 " + protection.String())
+	}
+	if len(doc.alerts) > 0 {
+		status += " " + alertStyle.Render(checkProblemMark+" "+strings.Join(doc.alerts, ", ")+" flagged a problem")
+	}
+	return status
+}
+
+// renderDiff colors a unified diff.
+func renderDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "@@"), strings.HasPrefix(line, "diff "):
+			lines[i] = diffHeaderStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = diffAddStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = diffRemoveStyle.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// approvalPrompt asks the user about the pending tool call, after the
+// preview of what it changes.
+func (doc *Document) approvalPrompt() string {
+	req := doc.approval.req
+	command := req.Command
+	if command == "" {
+		command = describeCall(req.Call)
+	}
+	var preview string
+	if req.Preview != "" {
+		preview = renderDiff(req.Preview) + "\n"
+	}
+	if req.Protection == ConfirmProtection {
+		return preview + approvalStyle.Render(fmt.Sprintf("%s is protected. Type %s to run `%s %s`, anything else declines:", req.Target, req.Target, req.Call.Name, command))
+	}
+	return preview + approvalStyle.Render(fmt.Sprintf("Run `%s %s`? [y/N]", req.Call.Name, command))
 }
 
 // View renders the current state of the document, including the conversation history.
 // This function is called by BubbleTea to display the UI.
 func (doc *Document) View() string {
 	var sb strings.Builder
-	for _, block := range doc.Blocks {
+	for _, block := range doc.Snapshot() {
 		sb.WriteString(Render(block))
 		sb.WriteString("\n")
 	}
-	sb.WriteString(doc.textInput.View())
-	sb.WriteString("\nPress Ctrl+C or Esc to exit.\n")
+	sb.WriteString(doc.status())
+	sb.WriteString("\n")
+	switch {
+	case doc.approval != nil:
+		sb.WriteString(doc.approvalPrompt())
+		sb.WriteString("\n")
+		sb.WriteString(doc.input.View())
+	case doc.running:
+		for _, progress := range doc.Progress() {
+			sb.WriteString(toolStyle.Render(progress.String()))
+			sb.WriteString("\n")
+		}
+		if retry := doc.Retrying(); retry != nil {
+			sb.WriteString(retryStyle.Render(retry.String() + ". Esc stops the turn."))
+		} else {
+			sb.WriteString("Thinking...")
+		}
+	case doc.search != nil:
+		sb.WriteString(doc.searchPrompt())
+	default:
+		sb.WriteString(doc.input.View())
+		if len(doc.completions) > 0 {
+			sb.WriteString("\n")
+			sb.WriteString(otherStyle.Render(strings.Join(doc.completions, "  ")))
+		}
+	}
+	sb.WriteString("\nEnter sends, Alt+Enter adds a line, Ctrl+E opens $EDITOR, Up/Down and Ctrl+R recall prompts, @file attaches a file, !kubectl runs a command. Esc stops a running turn, Ctrl+C or Esc exits.\n")
 	return sb.String()
 }
 
@@ -144,18 +417,80 @@ func (doc *Document) View() string {
 // This function is called by BubbleTea whenever there is a new message or user input.
 func (doc *Document) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case blockMsg:
+		return doc, doc.listen()
+	case approvalMsg:
+		doc.approval = &msg
+		doc.input.Reset()
+		return doc, doc.listen()
+	case alertMsg:
+		if !slices.Contains(doc.alerts, msg.result.Name) {
+			doc.alerts = append(doc.alerts, msg.result.Name)
+		}
+		return doc, doc.listen()
+	case triageMsg:
+		doc.triage = append(doc.triage, msg.prompt)
+		return doc, tea.Batch(doc.listen(), doc.nextTriage())
+	case turnDoneMsg:
+		doc.running = false
+		if doc.approval != nil && doc.approval.req.Context().Err() != nil {
+			// the turn was stopped while it waited for the answer
+			doc.approval = nil
+		}
+		return doc, doc.nextTriage()
+	case editorDoneMsg:
+		doc.editorDone(msg)
+		return doc, nil
+	case tea.WindowSizeMsg:
+		doc.input.SetWidth(msg.Width)
 	case tea.KeyMsg:
+		doc.completions = nil
+		if doc.approval == nil && doc.searchPrompts(msg) {
+			return doc, nil
+		}
 		switch msg.Type {
-		case tea.KeyCtrlC, tea.KeyEsc:
+		case tea.KeyEsc:
+			// Esc declines a pending approval or stops the turn instead of leaving
+			if doc.approval != nil {
+				doc.input.Reset()
+				doc.decide("")
+				return doc, nil
+			}
+			if doc.running && doc.CancelTurn() {
+				return doc, nil
+			}
+			return doc, tea.Quit
+		case tea.KeyCtrlC:
 			return doc, tea.Quit
 		case tea.KeyEnter:
-			doc.HandleSend()
-			return doc, nil
+			// Alt+Enter is left to the input and adds a newline
+			if !msg.Alt {
+				return doc, doc.HandleSend()
+			}
+		case tea.KeyCtrlE:
+			if !doc.running && doc.approval == nil {
+				return doc, doc.openEditor()
+			}
+		case tea.KeyTab:
+			if doc.approval == nil && (doc.completeMention() || doc.completeRunbook()) {
+				doc.resizeInput()
+				return doc, nil
+			}
+		case tea.KeyUp, tea.KeyDown:
+			if doc.approval == nil && doc.recallPrompt(msg.Type == tea.KeyUp) {
+				return doc, nil
+			}
 		}
 	}
+	if isShiftEnter(msg) {
+		doc.input.InsertString("\n")
+		doc.resizeInput()
+		return doc, nil
+	}
 
 	var cmd tea.Cmd
-	doc.textInput, cmd = doc.textInput.Update(msg)
+	doc.input, cmd = doc.input.Update(msg)
+	doc.resizeInput()
 
 	return doc, cmd
 }
@@ -0,0 +1,136 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+)
+
+// Usage counts the tokens of one or more model responses and their estimated cost in USD.
+type Usage struct {
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	TotalTokens      int     `json:"totalTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Add returns the sum of two usages.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+		Cost:             u.Cost + other.Cost,
+	}
+}
+
+// String renders the usage for the status bar, e.g. "12.3k tokens $0.0042".
+// The cost is left out when the model's price is unknown.
+func (u Usage) String() string {
+	tokens := fmt.Sprintf("%d tokens", u.TotalTokens)
+	if u.TotalTokens >= 1000 {
+		tokens = fmt.Sprintf("%.1fk tokens", float64(u.TotalTokens)/1000)
+	}
+	if u.Cost == 0 {
+		return tokens
+	}
+	return fmt.Sprintf("%s $%.4f", tokens, u.Cost)
+}
+
+// Price is what a model costs in USD per million tokens.
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// DefaultPrices are the list prices of common Gemini models. Prices in the
+// configuration are added to and override them.
+var DefaultPrices = map[string]Price{
+	"gemini-2.0-flash":      {Input: 0.10, Output: 0.40},
+	"gemini-2.0-flash-lite": {Input: 0.075, Output: 0.30},
+	"gemini-2.5-flash":      {Input: 0.30, Output: 2.50},
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10.00},
+}
+
+// mergeModelValues returns per-model defaults overridden by configured values.
+func mergeModelValues[T any](defaults, configured map[string]T) map[string]T {
+	values := maps.Clone(defaults)
+	maps.Copy(values, configured)
+	return values
+}
+
+// modelValue looks up a per-model setting such as a price. Versioned model
+// names such as gemini-2.5-pro-preview-05-06 use the value of their longest
+// known prefix.
+func modelValue[T any](values map[string]T, model string) (T, bool) {
+	if value, ok := values[model]; ok {
+		return value, true
+	}
+	best := ""
+	for name := range values {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	value, ok := values[best]
+	return value, ok && best != ""
+}
+
+// usageOf reads the token counts of a response and prices them. Providers
+// report usage in their own types, so the metadata is read through its JSON
+// form, which covers Gemini's and OpenAI's field names.
+func usageOf(resp gollm.ChatResponse, prices map[string]Price, model string) Usage {
+	if resp == nil || resp.UsageMetadata() == nil {
+		return Usage{}
+	}
+	data, err := json.Marshal(resp.UsageMetadata())
+	if err != nil {
+		return Usage{}
+	}
+
+	var metadata struct {
+		// Gemini
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+		// OpenAI
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return Usage{}
+	}
+
+	usage := Usage{
+		PromptTokens: metadata.PromptTokenCount + metadata.PromptTokens,
+		// thinking is billed as output
+		CompletionTokens: metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount + metadata.CompletionTokens,
+		TotalTokens:      metadata.TotalTokenCount + metadata.TotalTokens,
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	if price, ok := modelValue(prices, model); ok {
+		usage.Cost = (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
+	}
+	return usage
+}
@@ -0,0 +1,93 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kubectl-ai/gollm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// geminiUsage mirrors the shape of Gemini's usage metadata.
+type geminiUsage struct {
+	PromptTokenCount     int32 `json:"promptTokenCount,omitempty"`
+	CandidatesTokenCount int32 `json:"candidatesTokenCount,omitempty"`
+	ThoughtsTokenCount   int32 `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount      int32 `json:"totalTokenCount,omitempty"`
+}
+
+// withUsage attaches usage metadata to a fake response.
+func withUsage(resp *fakeResponse, usage any) *fakeResponse {
+	resp.usage = usage
+	return resp
+}
+
+// TestUsageOf reads the usage metadata of different providers and prices it.
+func TestUsageOf(t *testing.T) {
+	prices := map[string]Price{"gemini-2.5-pro": {Input: 1, Output: 10}}
+
+	usage := usageOf(withUsage(textResponse("hi"), &geminiUsage{
+		PromptTokenCount:     1000,
+		CandidatesTokenCount: 200,
+		ThoughtsTokenCount:   100,
+		TotalTokenCount:      1300,
+	}), prices, "gemini-2.5-pro-preview-05-06")
+	assert.Equal(t, Usage{PromptTokens: 1000, CompletionTokens: 300, TotalTokens: 1300, Cost: 0.004}, usage)
+	assert.Equal(t, "1.3k tokens $0.0040", usage.String())
+
+	usage = usageOf(withUsage(textResponse("hi"), map[string]int{
+		"prompt_tokens":     10,
+		"completion_tokens": 5,
+	}), prices, "gpt-4o")
+	assert.Equal(t, Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, usage)
+	assert.Equal(t, "15 tokens", usage.String())
+
+	assert.Equal(t, Usage{}, usageOf(textResponse("hi"), prices, "gemini-2.5-pro"))
+}
+
+// TestHistoryUsage adds up the usage per turn and per session.
+func TestHistoryUsage(t *testing.T) {
+	h, _ := newFakeHistory(t,
+		withUsage(callResponse(toolCall("call-1", "echo", "")), &geminiUsage{PromptTokenCount: 100, CandidatesTokenCount: 10}),
+		withUsage(textResponse("first"), &geminiUsage{PromptTokenCount: 200, CandidatesTokenCount: 20}),
+		withUsage(textResponse("second"), &geminiUsage{PromptTokenCount: 300, CandidatesTokenCount: 30}),
+	)
+	require.NoError(t, h.RegisterTool(Tool{
+		Definition: &gollm.FunctionDefinition{Name: "echo"},
+		ReadOnly:   true,
+		Run: func(ctx context.Context, args map[string]any) (string, error) {
+			return "", nil
+		},
+	}))
+	h.Model = "priced"
+	h.Prices = map[string]Price{"priced": {Input: 1, Output: 1}}
+	var updates int
+	h.OnUsage = func(turn, session Usage) { updates++ }
+
+	h.ChatLoop("first")
+	turn, session := h.Usage()
+	assert.Equal(t, 330, turn.TotalTokens)
+	assert.Equal(t, turn, session)
+
+	h.ChatLoop("second")
+	turn, session = h.Usage()
+	require.Equal(t, Usage{PromptTokens: 300, CompletionTokens: 30, TotalTokens: 330, Cost: 0.00033}, turn)
+	assert.Equal(t, 660, session.TotalTokens)
+	assert.InDelta(t, 0.00066, session.Cost, 1e-9)
+	assert.Equal(t, 3, updates)
+}
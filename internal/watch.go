@@ -0,0 +1,453 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// maxWatchSummary is how many of the problems found when a watch starts are
+// listed.
+const maxWatchSummary = 10
+
+// watchRestartDelay is how long a watch waits before watching again after
+// the API server closed it.
+var watchRestartDelay = 5 * time.Second
+
+// watchResources are the resources /watch understands, by name and alias.
+var watchResources = map[string]schema.GroupVersionResource{
+	"pods":        {Version: "v1", Resource: "pods"},
+	"pod":         {Version: "v1", Resource: "pods"},
+	"po":          {Version: "v1", Resource: "pods"},
+	"events":      {Version: "v1", Resource: "events"},
+	"event":       {Version: "v1", Resource: "events"},
+	"ev":          {Version: "v1", Resource: "events"},
+	"deployments": {Group: "apps", Version: "v1", Resource: "deployments"},
+	"deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"deploy":      {Group: "apps", Version: "v1", Resource: "deployments"},
+}
+
+// WatchSpec is what a /watch command watches.
+type WatchSpec struct {
+	// Resource is pods, events or deployments.
+	Resource string
+	// KubeContext is the kube context the watch was started in.
+	KubeContext   string
+	Namespace     string
+	AllNamespaces bool
+	// EventTypes limit watched events to these types, e.g. Warning.
+	EventTypes []string
+	// Triage hands crash loops, OOMKills and failed rollouts to the model.
+	Triage bool
+}
+
+// ParseWatchSpec parses the arguments of /watch, e.g. "pods -n payments"
+// or "events --types=Warning". Namespaced watches default to namespace.
+func ParseWatchSpec(args []string, namespace string) (WatchSpec, error) {
+	spec := WatchSpec{Namespace: namespace}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case name == "-n" || name == "--namespace":
+			if !hasValue {
+				if i+1 == len(args) {
+					return WatchSpec{}, fmt.Errorf("%s needs a namespace", arg)
+				}
+				i++
+				value = args[i]
+			}
+			spec.Namespace = value
+		case arg == "-A" || arg == "--all-namespaces":
+			spec.AllNamespaces = true
+		case name == "--types":
+			for _, t := range strings.Split(value, ",") {
+				if t != "" {
+					spec.EventTypes = append(spec.EventTypes, strings.ToUpper(t[:1])+strings.ToLower(t[1:]))
+				}
+			}
+		case arg == "--triage":
+			spec.Triage = true
+		case strings.HasPrefix(arg, "-"):
+			return WatchSpec{}, fmt.Errorf("unknown flag %s", arg)
+		case spec.Resource != "":
+			return WatchSpec{}, fmt.Errorf("only one resource can be watched, got %s and %s", spec.Resource, arg)
+		default:
+			gvr, ok := watchResources[strings.ToLower(arg)]
+			if !ok {
+				return WatchSpec{}, fmt.Errorf("cannot watch %s, only pods, events and deployments", arg)
+			}
+			spec.Resource = gvr.Resource
+		}
+	}
+	switch {
+	case spec.Resource == "":
+		return WatchSpec{}, errors.New("what to watch is missing, e.g. /watch pods -n payments")
+	case len(spec.EventTypes) > 0 && spec.Resource != "events":
+		return WatchSpec{}, errors.New("--types only applies to events")
+	}
+	return spec, nil
+}
+
+// String describes the watch, e.g. "Warning events in payments".
+func (s WatchSpec) String() string {
+	what := s.Resource
+	if len(s.EventTypes) > 0 {
+		what = strings.Join(s.EventTypes, " and ") + " " + what
+	}
+	if s.AllNamespaces {
+		return what + " in all namespaces"
+	}
+	return what + " in " + s.Namespace
+}
+
+// WatchSource lists the objects of a spec and starts watching them for
+// changes after the list. It defaults to the Kubernetes API of the spec's
+// kube context, tests use a fake watcher.
+type WatchSource func(ctx context.Context, spec WatchSpec) ([]unstructured.Unstructured, watch.Interface, error)
+
+// kubeWatch lists and watches the spec's objects through client-go. The
+// watch starts at the list's resource version, so it does not replay the
+// objects as added.
+func (e *Executor) kubeWatch(ctx context.Context, spec WatchSpec) ([]unstructured.Unstructured, watch.Interface, error) {
+	api, err := e.kubeAPIFor(ClusterContext{KubeContext: spec.KubeContext})
+	if err != nil {
+		return nil, nil, err
+	}
+	var resource dynamic.ResourceInterface = api.Dynamic.Resource(watchResources[spec.Resource])
+	if !spec.AllNamespaces {
+		resource = api.Dynamic.Resource(watchResources[spec.Resource]).Namespace(spec.Namespace)
+	}
+	opts := metav1.ListOptions{}
+	if len(spec.EventTypes) == 1 {
+		// several types are filtered when the events arrive
+		opts.FieldSelector = fields.OneTermEqualSelector("type", spec.EventTypes[0]).String()
+	}
+	list, err := resource.List(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts.ResourceVersion = list.GetResourceVersion()
+	events, err := resource.Watch(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return list.Items, events, nil
+}
+
+// watchSource returns where watches get their events from.
+func (e *Executor) watchSource() WatchSource {
+	if e.WatchSource != nil {
+		return e.WatchSource
+	}
+	return e.kubeWatch
+}
+
+// change is a notable change of a watched object.
+type change struct {
+	// key identifies the object, state what is wrong with it. A change is
+	// posted once until the object's state changes.
+	key   string
+	state string
+	text  string
+	// triage is set for crash loops, OOMKills and failed rollouts.
+	triage bool
+}
+
+// notableChange returns what is worth posting about a watch event, if anything.
+func notableChange(spec WatchSpec, event watch.Event) (change, bool) {
+	obj, ok := event.Object.(*unstructured.Unstructured)
+	if !ok || event.Type == watch.Deleted || event.Type == watch.Error {
+		return change{}, false
+	}
+	key := obj.GetNamespace() + "/" + obj.GetName()
+	switch spec.Resource {
+	case "pods":
+		var pod corev1.Pod
+		if runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod) != nil {
+			return change{}, false
+		}
+		return podChange(key, &pod)
+	case "deployments":
+		var deploy appsv1.Deployment
+		if runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &deploy) != nil {
+			return change{}, false
+		}
+		return deploymentChange(key, &deploy)
+	case "events":
+		var ev corev1.Event
+		if runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &ev) != nil {
+			return change{}, false
+		}
+		if len(spec.EventTypes) > 0 && !slices.Contains(spec.EventTypes, ev.Type) {
+			return change{}, false
+		}
+		text := fmt.Sprintf("%s %s %s/%s in %s: %s", ev.Type, ev.Reason, strings.ToLower(ev.InvolvedObject.Kind), ev.InvolvedObject.Name, ev.InvolvedObject.Namespace, ev.Message)
+		if ev.Count > 1 {
+			text += fmt.Sprintf(" (x%d)", ev.Count)
+		}
+		triage := ev.Reason == "BackOff" || ev.Reason == "OOMKilling" || ev.Reason == "ProgressDeadlineExceeded"
+		return change{key: key, state: ev.Reason, text: text, triage: triage}, true
+	}
+	return change{}, false
+}
+
+// podChange reports crash loops, OOMKills, image pull errors and failed pods.
+func podChange(key string, pod *corev1.Pod) (change, bool) {
+	statuses := append(slices.Clone(pod.Status.InitContainerStatuses), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil {
+			switch waiting.Reason {
+			case "CrashLoopBackOff":
+				reason := "crashing"
+				if last := status.LastTerminationState.Terminated; last != nil && last.Reason != "" {
+					reason = "last terminated with " + last.Reason
+				}
+				return change{
+					key:    key,
+					state:  "CrashLoopBackOff " + status.Name,
+					text:   fmt.Sprintf("Pod %s: container %s is in CrashLoopBackOff after %d restarts, %s.", key, status.Name, status.RestartCount, reason),
+					triage: true,
+				}, true
+			case "ImagePullBackOff", "ErrImagePull", "CreateContainerConfigError", "InvalidImageName":
+				return change{
+					key:   key,
+					state: waiting.Reason + " " + status.Name,
+					text:  fmt.Sprintf("Pod %s: container %s is waiting with %s: %s", key, status.Name, waiting.Reason, waiting.Message),
+				}, true
+			}
+		}
+		for _, terminated := range []*corev1.ContainerStateTerminated{status.State.Terminated, status.LastTerminationState.Terminated} {
+			if terminated != nil && terminated.Reason == "OOMKilled" {
+				return change{
+					key:    key,
+					state:  fmt.Sprintf("OOMKilled %s %d", status.Name, status.RestartCount),
+					text:   fmt.Sprintf("Pod %s: container %s was OOMKilled (%d restarts).", key, status.Name, status.RestartCount),
+					triage: true,
+				}, true
+			}
+		}
+	}
+	if pod.Status.Phase == corev1.PodFailed {
+		return change{
+			key:   key,
+			state: "Failed",
+			text:  strings.TrimSuffix(fmt.Sprintf("Pod %s failed: %s %s", key, pod.Status.Reason, pod.Status.Message), " "),
+		}, true
+	}
+	return change{key: key}, true
+}
+
+// deploymentChange reports failed and unavailable rollouts.
+func deploymentChange(key string, deploy *appsv1.Deployment) (change, bool) {
+	for _, cond := range deploy.Status.Conditions {
+		switch {
+		case cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse:
+			return change{
+				key:    key,
+				state:  cond.Reason,
+				text:   fmt.Sprintf("Deployment %s: the rollout failed with %s: %s", key, cond.Reason, cond.Message),
+				triage: true,
+			}, true
+		case cond.Type == appsv1.DeploymentAvailable && cond.Status == corev1.ConditionFalse:
+			return change{
+				key:   key,
+				state: cond.Reason,
+				text:  fmt.Sprintf("Deployment %s is unavailable: %s", key, cond.Message),
+			}, true
+		}
+	}
+	return change{key: key}, true
+}
+
+// activeWatch is a running /watch.
+type activeWatch struct {
+	id     int
+	spec   WatchSpec
+	cancel context.CancelFunc
+	// restartDelay is watchRestartDelay when the watch started.
+	restartDelay time.Duration
+}
+
+// StartWatch watches objects in the background and posts notable changes
+// as WatchBlocks until the watch is stopped or the conversation ends. It
+// returns the ID to stop it with.
+func (h *History) StartWatch(spec WatchSpec) (int, error) {
+	if _, ok := watchResources[spec.Resource]; !ok {
+		return 0, fmt.Errorf("cannot watch %s", spec.Resource)
+	}
+	if spec.KubeContext == "" {
+		spec.KubeContext = h.Cluster.KubeContext
+	}
+	ctx, cancel := context.WithCancel(h.Context)
+	objects, events, err := h.watchSource()(ctx, spec)
+	if err != nil {
+		cancel()
+		return 0, fmt.Errorf("watching %s: %w", spec, err)
+	}
+
+	h.mu.Lock()
+	h.watchSeq++
+	w := &activeWatch{id: h.watchSeq, spec: spec, cancel: cancel, restartDelay: watchRestartDelay}
+	h.watches = append(h.watches, w)
+	h.mu.Unlock()
+
+	go h.runWatch(ctx, w, objects, events)
+	return w.id, nil
+}
+
+// runWatch posts the notable changes of a watch, watching again whenever
+// the API server closes it. The problems of the objects there were when the
+// watch started are summed up in one block, without triage. After a
+// restart, the objects that changed while nobody was watching are posted
+// like any other change.
+func (h *History) runWatch(ctx context.Context, w *activeWatch, objects []unstructured.Unstructured, events watch.Interface) {
+	states := map[string]string{}
+	h.summarizeWatch(w, objects, states)
+	for {
+		h.consumeWatch(ctx, w, events, states)
+		events.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.restartDelay):
+		}
+		var err error
+		if objects, events, err = h.watchSource()(ctx, w.spec); err != nil {
+			if ctx.Err() == nil {
+				h.StopWatch(w.id)
+				h.AddBlock(Block{Text: fmt.Sprintf("Stopped watching %s: %v", w.spec, err), Type: ErrorBlock})
+			}
+			return
+		}
+		for i := range objects {
+			h.postChange(w, watch.Event{Type: watch.Modified, Object: &objects[i]}, states)
+		}
+	}
+}
+
+// summarizeWatch records the state of the objects there were when a watch
+// started and posts their problems as one block. Listed events happened
+// before the watch and are only recorded, so they are not posted again.
+func (h *History) summarizeWatch(w *activeWatch, objects []unstructured.Unstructured, states map[string]string) {
+	var problems []string
+	for i := range objects {
+		c, ok := notableChange(w.spec, watch.Event{Type: watch.Added, Object: &objects[i]})
+		if !ok || c.state == "" {
+			continue
+		}
+		states[c.key] = c.state
+		problems = append(problems, c.text)
+	}
+	if len(problems) == 0 || w.spec.Resource == "events" {
+		return
+	}
+	text := fmt.Sprintf("Already wrong when the watch on %s started:", w.spec)
+	for _, problem := range problems[:min(len(problems), maxWatchSummary)] {
+		text += "\n- " + problem
+	}
+	if len(problems) > maxWatchSummary {
+		text += fmt.Sprintf("\n- and %d more", len(problems)-maxWatchSummary)
+	}
+	text, _ = h.Redact(text)
+	h.AddBlock(Block{Text: text, Type: WatchBlock})
+}
+
+// consumeWatch posts the changes of one watch until it ends. states holds
+// what was last posted per object.
+func (h *History) consumeWatch(ctx context.Context, w *activeWatch, events watch.Interface, states map[string]string) {
+	for {
+		var event watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok = <-events.ResultChan():
+			if !ok {
+				return
+			}
+		}
+		h.postChange(w, event, states)
+	}
+}
+
+// postChange posts a watch event that changed what is wrong with an object,
+// and hands it to triage if the watch asks for that. Object status and
+// event messages can quote anything, so the text is redacted before it is
+// shown or sent to the model.
+func (h *History) postChange(w *activeWatch, event watch.Event, states map[string]string) {
+	c, ok := notableChange(w.spec, event)
+	if !ok || states[c.key] == c.state {
+		return
+	}
+	if c.state == "" {
+		// the object is fine again
+		delete(states, c.key)
+		return
+	}
+	states[c.key] = c.state
+	text, _ := h.Redact(c.text)
+	h.AddBlock(Block{Text: text, Type: WatchBlock})
+	if w.spec.Triage && c.triage && h.OnTriage != nil {
+		h.OnTriage(fmt.Sprintf("My watch on %s in kube context %s noticed this: %s\nFind out why, and suggest a fix without applying it.", w.spec, w.spec.KubeContext, text))
+	}
+}
+
+// StopWatch stops the watch with the given ID, or every watch for 0. It
+// returns the stopped watches.
+func (h *History) StopWatch(id int) []WatchSpec {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var stopped []WatchSpec
+	h.watches = slices.DeleteFunc(h.watches, func(w *activeWatch) bool {
+		if id != 0 && w.id != id {
+			return false
+		}
+		w.cancel()
+		stopped = append(stopped, w.spec)
+		return true
+	})
+	return stopped
+}
+
+// Watches describes the running watches, e.g. "1: pods in payments".
+func (h *History) Watches() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var watches []string
+	for _, w := range h.watches {
+		line := fmt.Sprintf("%d: %s (%s)", w.id, w.spec, w.spec.KubeContext)
+		if w.spec.Triage {
+			line += ", triaged by the model"
+		}
+		watches = append(watches, line)
+	}
+	return watches
+}
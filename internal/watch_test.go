@@ -0,0 +1,386 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// TestParseWatchSpec parses the arguments of /watch.
+func TestParseWatchSpec(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    WatchSpec
+		wantErr string
+	}{
+		{args: "pods", want: WatchSpec{Resource: "pods", Namespace: "default"}},
+		{args: "po -n payments", want: WatchSpec{Resource: "pods", Namespace: "payments"}},
+		{args: "deploy --namespace=web --triage", want: WatchSpec{Resource: "deployments", Namespace: "web", Triage: true}},
+		{args: "events --types=warning -A", want: WatchSpec{Resource: "events", Namespace: "default", AllNamespaces: true, EventTypes: []string{"Warning"}}},
+		{args: "", wantErr: "what to watch is missing"},
+		{args: "services", wantErr: "cannot watch services"},
+		{args: "pods events", wantErr: "only one resource"},
+		{args: "pods -n", wantErr: "-n needs a namespace"},
+		{args: "pods --types=Warning", wantErr: "--types only applies to events"},
+		{args: "pods -w", wantErr: "unknown flag -w"},
+	}
+	for _, tt := range tests {
+		got, err := ParseWatchSpec(strings.Fields(tt.args), "default")
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.args)
+			continue
+		}
+		require.NoError(t, err, tt.args)
+		assert.Equal(t, tt.want, got)
+	}
+	assert.Equal(t, "Warning events in all namespaces", WatchSpec{Resource: "events", AllNamespaces: true, EventTypes: []string{"Warning"}}.String())
+}
+
+// watchedPod builds a pod whose only container is in the given state.
+func watchedPod(name string, restarts int64, state, lastState map[string]any) *unstructured.Unstructured {
+	status := map[string]any{"name": "app", "restartCount": restarts, "state": state}
+	if lastState != nil {
+		status["lastState"] = lastState
+	}
+	return fakeObject("v1", "Pod", "payments", name, nil, map[string]any{
+		"status": map[string]any{"phase": "Running", "containerStatuses": []any{status}},
+	})
+}
+
+var (
+	runningState = map[string]any{"running": map[string]any{}}
+	crashState   = map[string]any{"waiting": map[string]any{"reason": "CrashLoopBackOff"}}
+	oomState     = map[string]any{"terminated": map[string]any{"reason": "OOMKilled", "exitCode": int64(137)}}
+)
+
+// TestNotableChange picks out what is worth posting.
+func TestNotableChange(t *testing.T) {
+	pods := WatchSpec{Resource: "pods"}
+	deployments := WatchSpec{Resource: "deployments"}
+	warnings := WatchSpec{Resource: "events", EventTypes: []string{"Warning"}}
+
+	failedRollout := fakeObject("apps/v1", "Deployment", "payments", "api", nil, map[string]any{
+		"status": map[string]any{"conditions": []any{
+			map[string]any{"type": "Available", "status": "True"},
+			map[string]any{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded", "message": `ReplicaSet "api-7d9" has timed out progressing.`},
+		}},
+	})
+	event := func(eventType, reason string) *unstructured.Unstructured {
+		return fakeObject("v1", "Event", "payments", "api-1.17a", nil, map[string]any{
+			"type": eventType, "reason": reason, "message": "Back-off restarting failed container", "count": int64(5),
+			"involvedObject": map[string]any{"kind": "Pod", "name": "api-1", "namespace": "payments"},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		spec       WatchSpec
+		event      watch.Event
+		want       string
+		wantTriage bool
+		wantNone   bool
+	}{
+		{name: "healthy pod", spec: pods, event: watch.Event{Type: watch.Added, Object: watchedPod("api-1", 0, runningState, nil)}},
+		{
+			name:       "crash loop",
+			spec:       pods,
+			event:      watch.Event{Type: watch.Modified, Object: watchedPod("api-1", 4, crashState, oomState)},
+			want:       "Pod payments/api-1: container app is in CrashLoopBackOff after 4 restarts, last terminated with OOMKilled.",
+			wantTriage: true,
+		},
+		{
+			name:       "OOMKilled",
+			spec:       pods,
+			event:      watch.Event{Type: watch.Modified, Object: watchedPod("api-1", 1, runningState, oomState)},
+			want:       "Pod payments/api-1: container app was OOMKilled (1 restarts).",
+			wantTriage: true,
+		},
+		{name: "deleted pod", spec: pods, event: watch.Event{Type: watch.Deleted, Object: watchedPod("api-1", 4, crashState, nil)}, wantNone: true},
+		{
+			name:       "failed rollout",
+			spec:       deployments,
+			event:      watch.Event{Type: watch.Modified, Object: failedRollout},
+			want:       `Deployment payments/api: the rollout failed with ProgressDeadlineExceeded: ReplicaSet "api-7d9" has timed out progressing.`,
+			wantTriage: true,
+		},
+		{
+			name:       "warning event",
+			spec:       warnings,
+			event:      watch.Event{Type: watch.Added, Object: event("Warning", "BackOff")},
+			want:       "Warning BackOff pod/api-1 in payments: Back-off restarting failed container (x5)",
+			wantTriage: true,
+		},
+		{name: "normal event", spec: warnings, event: watch.Event{Type: watch.Added, Object: event("Normal", "Pulled")}, wantNone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := notableChange(tt.spec, tt.event)
+			if tt.wantNone {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.Equal(t, tt.want, got.text)
+			assert.Equal(t, tt.wantTriage, got.triage)
+			assert.Equal(t, tt.want == "", got.state == "")
+		})
+	}
+}
+
+// fakeWatchSource hands out fake watchers and records the specs watched.
+type fakeWatchSource struct {
+	mu       sync.Mutex
+	watchers []*watch.FakeWatcher
+	specs    []WatchSpec
+	err      error
+	// objects are listed when a watch starts.
+	objects []unstructured.Unstructured
+}
+
+// watch is the WatchSource of the fake.
+func (s *fakeWatchSource) watch(ctx context.Context, spec WatchSpec) ([]unstructured.Unstructured, watch.Interface, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.specs = append(s.specs, spec)
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	w := watch.NewFake()
+	s.watchers = append(s.watchers, w)
+	return slices.Clone(s.objects), w, nil
+}
+
+// list sets the objects listed by the next watches.
+func (s *fakeWatchSource) list(objects ...*unstructured.Unstructured) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects = nil
+	for _, obj := range objects {
+		s.objects = append(s.objects, *obj)
+	}
+}
+
+// latest returns the last watcher handed out.
+func (s *fakeWatchSource) latest() *watch.FakeWatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watchers[len(s.watchers)-1]
+}
+
+// watched returns the specs watched so far.
+func (s *fakeWatchSource) watched() []WatchSpec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]WatchSpec(nil), s.specs...)
+}
+
+// watchBlocks returns the texts of the WatchBlocks of a history.
+func watchBlocks(h *History) []string {
+	var texts []string
+	for _, block := range h.Snapshot() {
+		if block.Type == WatchBlock {
+			texts = append(texts, block.Text)
+		}
+	}
+	return texts
+}
+
+// TestWatch posts notable changes once, asks for triage and restarts
+// watches closed by the server.
+func TestWatch(t *testing.T) {
+	restartDelay := watchRestartDelay
+	watchRestartDelay = 0
+	t.Cleanup(func() { watchRestartDelay = restartDelay })
+
+	h, _ := newFakeHistory(t)
+	h.Cluster = ClusterContext{KubeContext: "kind-dev", Namespace: "payments"}
+	source := &fakeWatchSource{}
+	// pods there already are summed up instead of posted one by one
+	source.list(watchedPod("api-0", 7, crashState, nil), watchedPod("web-0", 0, runningState, nil))
+	h.WatchSource = source.watch
+	triage := make(chan string, 10)
+	h.OnTriage = func(prompt string) { triage <- prompt }
+
+	id, err := h.StartWatch(WatchSpec{Resource: "pods", Namespace: "payments", Triage: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1: pods in payments (kind-dev), triaged by the model"}, h.Watches())
+	crash := "Pod payments/%s: container app is in CrashLoopBackOff after %d restarts, crashing."
+	require.Eventually(t, func() bool { return len(watchBlocks(h)) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Already wrong when the watch on pods in payments started:\n- "+fmt.Sprintf(crash, "api-0", 7), watchBlocks(h)[0])
+
+	w := source.latest()
+	// a pod that was already crashing is not posted again
+	w.Modify(watchedPod("api-0", 7, crashState, nil))
+	w.Add(watchedPod("api-1", 0, runningState, nil))
+	w.Modify(watchedPod("api-1", 3, crashState, nil))
+	// the same state is not posted again
+	w.Modify(watchedPod("api-1", 3, crashState, nil))
+	// a recovered pod that crashes again is
+	w.Modify(watchedPod("api-1", 3, runningState, nil))
+	w.Modify(watchedPod("api-1", 4, crashState, nil))
+
+	require.Eventually(t, func() bool { return len(watchBlocks(h)) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{fmt.Sprintf(crash, "api-1", 3), fmt.Sprintf(crash, "api-1", 4)}, watchBlocks(h)[1:])
+	prompt := <-triage
+	assert.Contains(t, prompt, "pods in payments in kube context kind-dev")
+	assert.Contains(t, prompt, fmt.Sprintf(crash, "api-1", 3))
+
+	// a watch closed by the API server is started again, and what changed
+	// in between is posted
+	source.list(watchedPod("api-0", 8, runningState, oomState))
+	w.Stop()
+	require.Eventually(t, func() bool { return source.latest() != w }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(watchBlocks(h)) == 4 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Pod payments/api-0: container app was OOMKilled (8 restarts).", watchBlocks(h)[3])
+	source.latest().Add(watchedPod("api-2", 1, runningState, oomState))
+	require.Eventually(t, func() bool { return len(watchBlocks(h)) == 5 }, 5*time.Second, 10*time.Millisecond)
+	specs := source.watched()
+	require.Len(t, specs, 2)
+	assert.Equal(t, WatchSpec{Resource: "pods", KubeContext: "kind-dev", Namespace: "payments", Triage: true}, specs[1])
+
+	assert.Equal(t, specs[:1], h.StopWatch(id))
+	assert.Empty(t, h.Watches())
+	assert.Empty(t, h.StopWatch(id))
+}
+
+// TestWatchSourceFailure stops a watch that cannot be started again.
+func TestWatchSourceFailure(t *testing.T) {
+	restartDelay := watchRestartDelay
+	watchRestartDelay = 0
+	t.Cleanup(func() { watchRestartDelay = restartDelay })
+
+	h, _ := newFakeHistory(t)
+	source := &fakeWatchSource{err: errors.New("forbidden")}
+	h.WatchSource = source.watch
+	_, err := h.StartWatch(WatchSpec{Resource: "events", Namespace: "web"})
+	assert.ErrorContains(t, err, "watching events in web: forbidden")
+
+	source.err = nil
+	_, err = h.StartWatch(WatchSpec{Resource: "events", Namespace: "web"})
+	require.NoError(t, err)
+	source.mu.Lock()
+	source.err = errors.New("forbidden")
+	source.mu.Unlock()
+	source.latest().Stop()
+	require.Eventually(t, func() bool { return lastBlockOf(h).Type == ErrorBlock }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Stopped watching events in web: forbidden", lastBlockOf(h).Text)
+	assert.Empty(t, h.Watches())
+}
+
+// lastBlockOf returns the latest block of a history.
+func lastBlockOf(h *History) Block {
+	blocks := h.Snapshot()
+	if len(blocks) == 0 {
+		return Block{}
+	}
+	return blocks[len(blocks)-1]
+}
+
+// TestWatchCommand starts, lists and stops watches, and runs triage
+// prompts once the current turn is over.
+func TestWatchCommand(t *testing.T) {
+	doc, chat := newTestDoc(t, textResponse("The container runs out of memory."))
+	doc.Cluster = ClusterContext{KubeContext: "kind-dev", Namespace: "payments"}
+	source := &fakeWatchSource{}
+	doc.WatchSource = source.watch
+
+	send(doc, "/watch")
+	assert.Contains(t, lastBlock(doc).Text, "Nothing is watched")
+	send(doc, "/watch events --types=Warning")
+	assert.Equal(t, "Watching Warning events in payments (watch 1). `/watch stop 1` stops it.", lastBlock(doc).Text)
+	send(doc, "/watch")
+	assert.Equal(t, "Watching:\n\n- 1: Warning events in payments (kind-dev)", lastBlock(doc).Text)
+	send(doc, "/watch services")
+	assert.Equal(t, ErrorBlock, lastBlock(doc).Type)
+	send(doc, "/watch stop 1")
+	assert.Equal(t, "Stopped watching Warning events in payments", lastBlock(doc).Text)
+	send(doc, "/watch stop")
+	assert.Equal(t, "no such watch, /watch lists them", lastBlock(doc).Text)
+
+	// a triage prompt waits for the running turn
+	doc.running = true
+	doc.Update(triageMsg{prompt: "Why is api-1 crashing?"})
+	assert.Empty(t, chat.Sent())
+	_, cmd := doc.Update(turnDoneMsg{})
+	require.NotNil(t, cmd)
+	assert.True(t, doc.running)
+	assert.IsType(t, turnDoneMsg{}, cmd())
+	require.Len(t, chat.Sent(), 1)
+	assert.Equal(t, "Why is api-1 crashing?", chat.Sent()[0][0])
+	assert.Equal(t, "The container runs out of memory.", lastBlock(doc).Text)
+}
+
+// TestKubeWatch lists the watched objects and watches for what follows.
+func TestKubeWatch(t *testing.T) {
+	executor := NewExecutor()
+	executor.NewKubeAPI = newFakeKubeAPI
+	objects, events, err := executor.kubeWatch(t.Context(), WatchSpec{Resource: "pods", Namespace: "payments"})
+	require.NoError(t, err)
+	defer events.Stop()
+	require.Len(t, objects, 1)
+	assert.Equal(t, "api-1", objects[0].GetName())
+
+	api, err := executor.kubeAPIFor(ClusterContext{})
+	require.NoError(t, err)
+	pods := api.Dynamic.Resource(watchResources["pods"]).Namespace("payments")
+	_, err = pods.Create(t.Context(), watchedPod("api-2", 0, runningState, nil), metav1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case event := <-events.ResultChan():
+		assert.Equal(t, watch.Added, event.Type)
+		assert.Equal(t, "api-2", event.Object.(*unstructured.Unstructured).GetName())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event for the new pod")
+	}
+}
+
+// TestWatchRedaction masks secrets quoted by watched objects before they
+// are shown or handed to triage.
+func TestWatchRedaction(t *testing.T) {
+	h, _ := newFakeHistory(t)
+	source := &fakeWatchSource{}
+	h.WatchSource = source.watch
+	triage := make(chan string, 1)
+	h.OnTriage = func(prompt string) { triage <- prompt }
+
+	_, err := h.StartWatch(WatchSpec{Resource: "events", Namespace: "payments", Triage: true})
+	require.NoError(t, err)
+	source.latest().Add(fakeObject("v1", "Event", "payments", "api-1.1", nil, map[string]any{
+		"type":           "Warning",
+		"reason":         "BackOff",
+		"message":        "Back-off restarting container started with DB_PASSWORD=hunter2",
+		"involvedObject": map[string]any{"kind": "Pod", "name": "api-1", "namespace": "payments"},
+	}))
+
+	want := "Warning BackOff pod/api-1 in payments: Back-off restarting container started with DB_PASSWORD=[REDACTED]"
+	require.Eventually(t, func() bool { return len(watchBlocks(h)) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, want, watchBlocks(h)[0])
+	prompt := <-triage
+	assert.Contains(t, prompt, want)
+	assert.NotContains(t, prompt, "hunter2")
+}